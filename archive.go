@@ -0,0 +1,168 @@
+package main
+
+import (
+	"archive/tar"   // For tar archives
+	"archive/zip"   // For zip archives
+	"bytes"         // Provides buffer for reading/writing data
+	"compress/gzip" // For .tar.gz archives
+	"context"       // For cancelling in-flight work
+	"fmt"           // For formatted I/O operations
+	"io"            // For general I/O primitives
+	"io/fs"         // For file information
+	"path/filepath" // For manipulating filename paths
+	"strings"       // For string manipulation
+	"sync"          // For handling concurrency
+	"time"          // For time-related operations
+)
+
+// archiveWriter adds entries to an archive format
+type archiveWriter interface {
+	add(name string, size int64, reader io.Reader) error // Append one file
+	Close() error                                        // Write the archive's trailer
+}
+
+// zipArchive writes a zip archive; PDFs are already compressed, so entries are stored as they are
+type zipArchive struct {
+	writer *zip.Writer
+}
+
+// add appends a stored zip entry
+func (archive *zipArchive) add(name string, size int64, reader io.Reader) error {
+	entry, err := archive.writer.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store, Modified: time.Now()})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(entry, reader)
+	return err
+}
+
+// Close writes the zip central directory
+func (archive *zipArchive) Close() error {
+	return archive.writer.Close()
+}
+
+// tarArchive writes a tar archive, optionally gzip-compressed
+type tarArchive struct {
+	writer *tar.Writer
+	gzip   *gzip.Writer // Compressor below writer, or nil for a plain .tar
+}
+
+// add appends a tar entry of the given size
+func (archive *tarArchive) add(name string, size int64, reader io.Reader) error {
+	if err := archive.writer.WriteHeader(&tar.Header{Name: name, Size: size, Mode: 0o644, ModTime: time.Now()}); err != nil {
+		return err
+	}
+	_, err := io.Copy(archive.writer, reader)
+	return err
+}
+
+// Close writes the tar trailer and flushes the compressor
+func (archive *tarArchive) Close() error {
+	err := archive.writer.Close()
+	if archive.gzip != nil && err == nil {
+		err = archive.gzip.Close()
+	}
+	return err
+}
+
+// archiveStorage stores documents as entries of a single zip or tar archive, written to a temp
+// file that replaces the archive once it is finished
+type archiveStorage struct {
+	fs       FS              // Filesystem the archive is written to
+	path     string          // Final path of the archive
+	file     File            // Temp file being written
+	mutex    sync.Mutex      // Serializes entries; archive writers aren't safe for concurrent use
+	writer   archiveWriter   // Format-specific writer on top of file
+	names    map[string]bool // Entries written so far
+	finished bool            // Whether Finish has run
+}
+
+// newArchiveStorage starts an archive at path on fsys, its format chosen by extension: .zip,
+// .tar, .tar.gz or .tgz
+func newArchiveStorage(fsys FS, path string) (*archiveStorage, error) {
+	lower := strings.ToLower(path)
+	if !strings.HasSuffix(lower, ".zip") && !strings.HasSuffix(lower, ".tar") && !strings.HasSuffix(lower, ".tar.gz") && !strings.HasSuffix(lower, ".tgz") {
+		return nil, fmt.Errorf("invalid -archive %q: want a .zip, .tar, .tar.gz or .tgz path", path)
+	}
+	if err := fsys.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	file, err := fsys.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*"+tempFileSuffix) // Never leave a half-written archive under its name
+	if err != nil {
+		return nil, err
+	}
+	storage := &archiveStorage{fs: fsys, path: path, file: file, names: make(map[string]bool)}
+	switch {
+	case strings.HasSuffix(lower, ".zip"):
+		storage.writer = &zipArchive{writer: zip.NewWriter(file)}
+	case strings.HasSuffix(lower, ".tar"):
+		storage.writer = &tarArchive{writer: tar.NewWriter(file)}
+	default:
+		compressor := gzip.NewWriter(file)
+		storage.writer = &tarArchive{writer: tar.NewWriter(compressor), gzip: compressor}
+	}
+	return storage, nil
+}
+
+// Exists reports whether name was already added to the archive during this run
+func (storage *archiveStorage) Exists(name string) bool {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+	return storage.names[filepath.ToSlash(name)]
+}
+
+// Write adds reader's content to the archive as name
+func (storage *archiveStorage) Write(ctx context.Context, name string, reader io.Reader) error {
+	size := int64(-1) // Tar headers need the size up front
+	if stater, ok := reader.(interface{ Stat() (fs.FileInfo, error) }); ok {
+		if info, err := stater.Stat(); err == nil {
+			size = info.Size() // Staged temp file
+		}
+	}
+	if size < 0 {
+		content, err := io.ReadAll(reader) // Small entries such as header sidecars
+		if err != nil {
+			return err
+		}
+		size, reader = int64(len(content)), bytes.NewReader(content)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+	if storage.finished {
+		return fmt.Errorf("archive %s is already finished", storage.path)
+	}
+	entry := filepath.ToSlash(name) // Archive entries always use forward slashes
+	if err := storage.writer.add(entry, size, reader); err != nil {
+		return fmt.Errorf("adding %s to %s: %w", entry, storage.path, err)
+	}
+	storage.names[entry] = true
+	return nil
+}
+
+// Finish adds the manifest as a last entry, completes the archive and moves it into place
+func (storage *archiveStorage) Finish(manifest []byte) error {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+	storage.finished = true
+	err := storage.writer.add(manifestFileName, int64(len(manifest)), bytes.NewReader(manifest))
+	if err == nil {
+		err = storage.writer.Close()
+	}
+	if err == nil {
+		err = storage.file.Sync() // Make sure the archive is on disk before the rename publishes it
+	}
+	if closeErr := storage.file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = storage.fs.Rename(storage.file.Name(), storage.path)
+	}
+	if err != nil {
+		discardFile(storage.fs, storage.file.Name()) // Never leave a broken archive behind
+		return fmt.Errorf("finishing archive %s: %w", storage.path, err)
+	}
+	return nil
+}
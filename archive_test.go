@@ -0,0 +1,133 @@
+package main
+
+import (
+	"archive/tar"   // For reading tar archives back
+	"archive/zip"   // For reading zip archives back
+	"compress/gzip" // For reading .tar.gz archives back
+	"context"       // For storage contexts
+	"io"            // For reading entries
+	"os"            // For staged files and the finished archive
+	"path/filepath" // For archive paths
+	"strings"       // For entry contents
+	"testing"       // For the test framework
+)
+
+// readArchive returns the entries of the archive at path by name, in the order they were written
+func readArchive(t *testing.T, path string) (map[string]string, []string) {
+	t.Helper()
+	entries := make(map[string]string)
+	var order []string
+	if strings.HasSuffix(path, ".zip") {
+		reader, err := zip.OpenReader(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer reader.Close()
+		for _, file := range reader.File {
+			if file.Method != zip.Store {
+				t.Errorf("zip entry %s is compressed, want it stored", file.Name)
+			}
+			entry, err := file.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			content, _ := io.ReadAll(entry)
+			entry.Close()
+			entries[file.Name] = string(content)
+			order = append(order, file.Name)
+		}
+		return entries, order
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var stream io.Reader = file
+	if !strings.HasSuffix(path, ".tar") {
+		decompressor, err := gzip.NewReader(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stream = decompressor
+	}
+	reader := tar.NewReader(stream)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return entries, order
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, _ := io.ReadAll(reader)
+		entries[header.Name] = string(content)
+		order = append(order, header.Name)
+	}
+}
+
+func TestArchiveStorageRoundTrip(t *testing.T) {
+	for _, name := range []string{"mirror.zip", "mirror.tar", "mirror.tar.gz", "mirror.tgz"} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "archives", name)
+			storage, err := newArchiveStorage(osFS{}, path)
+			if err != nil {
+				t.Fatalf("newArchiveStorage: %v", err)
+			}
+			staged := filepath.Join(dir, "staged.tmp")
+			os.WriteFile(staged, []byte("%PDF-staged"), 0o644)
+			file, _ := os.Open(staged) // Sized by Stat, as downloads are
+			defer file.Close()
+			if err := storage.Write(context.Background(), "ab/doc.pdf", file); err != nil {
+				t.Fatalf("Write(ab/doc.pdf): %v", err)
+			}
+			if err := storage.Write(context.Background(), "doc.pdf.headers.json", strings.NewReader(`{"ETag":"v1"}`)); err != nil {
+				t.Fatalf("Write(sidecar): %v", err)
+			}
+			if !storage.Exists("ab/doc.pdf") || storage.Exists("other.pdf") {
+				t.Errorf("Exists(ab/doc.pdf) = %v, Exists(other.pdf) = %v", storage.Exists("ab/doc.pdf"), storage.Exists("other.pdf"))
+			}
+			if _, err := os.Stat(path); !os.IsNotExist(err) {
+				t.Errorf("the archive is under its name before Finish: %v", err)
+			}
+			if err := storage.Finish([]byte(`{"entries":[]}`)); err != nil {
+				t.Fatalf("Finish: %v", err)
+			}
+			if err := storage.Write(context.Background(), "late.pdf", strings.NewReader("%PDF-late")); err == nil {
+				t.Error("Write after Finish succeeded")
+			}
+
+			entries, order := readArchive(t, path)
+			want := map[string]string{"ab/doc.pdf": "%PDF-staged", "doc.pdf.headers.json": `{"ETag":"v1"}`, manifestFileName: `{"entries":[]}`}
+			if len(entries) != len(want) || order[len(order)-1] != manifestFileName {
+				t.Errorf("entries %q, want %d with the manifest last", order, len(want))
+			}
+			for entry, content := range want {
+				if entries[entry] != content {
+					t.Errorf("entry %s = %q, want %q", entry, entries[entry], content)
+				}
+			}
+			if leftovers, _ := filepath.Glob(filepath.Join(dir, "archives", "*"+tempFileSuffix)); len(leftovers) != 0 {
+				t.Errorf("temp files left behind: %v", leftovers)
+			}
+			if info, err := os.Stat(path); err != nil {
+				t.Error(err)
+			} else if info.Mode().Perm() != 0o644 {
+				t.Errorf("archive mode = %v, want 0644", info.Mode())
+			}
+		})
+	}
+}
+
+func TestNewArchiveStorageRejectsUnknownFormats(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"mirror.rar", "mirror.gz", "mirror"} {
+		if _, err := newArchiveStorage(osFS{}, filepath.Join(dir, name)); err == nil || !strings.Contains(err.Error(), "want a .zip, .tar, .tar.gz or .tgz path") {
+			t.Errorf("newArchiveStorage(osFS{}, %s): err = %v", name, err)
+		}
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("files created for rejected archives: %v", entries)
+	}
+}
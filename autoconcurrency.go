@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"  // For cancelling in-flight work
+	"errors"   // For inspecting error values
+	"log/slog" // For structured, levelled logging
+	"net/http" // For observing responses
+	"sync"     // For handling concurrency
+	"time"     // For time-related operations
+)
+
+// Tuning of -concurrency-auto
+const (
+	autoWindowMin     = 10  // Fewest responses a decision is based on
+	autoErrorRate     = 0.1 // Share of failed requests in a window that halves the level
+	autoLatencyFactor = 2   // How much slower than the best window responses may get before the level drops
+)
+
+// concurrencyController decides how many downloads run at once with -concurrency-auto: it
+// starts at its minimum, adds one download after every window of healthy responses, takes one
+// away when responses get slow and halves the level when errors spike; it is safe for
+// concurrent use
+type concurrencyController struct {
+	mutex    sync.Mutex
+	cond     *sync.Cond    // Signalled when a slot frees up or the level rises
+	min, max int           // Bounds of the level
+	level    int           // Downloads allowed at once
+	active   int           // Downloads running
+	requests int           // Responses in the current window
+	failures int           // Failed requests in the current window
+	latency  time.Duration // Summed time to response headers in the current window
+	best     time.Duration // Lowest mean latency of any window so far
+}
+
+// newConcurrencyController returns a controller whose level stays between minLevel and maxLevel
+func newConcurrencyController(minLevel int, maxLevel int) *concurrencyController {
+	controller := &concurrencyController{min: minLevel, max: maxLevel, level: minLevel}
+	controller.cond = sync.NewCond(&controller.mutex)
+	return controller
+}
+
+// acquire waits for a download slot; it returns false, without a slot, once ctx is done
+func (controller *concurrencyController) acquire(ctx context.Context) bool {
+	stop := context.AfterFunc(ctx, func() {
+		controller.mutex.Lock()
+		defer controller.mutex.Unlock()
+		controller.cond.Broadcast() // Wake the waiters so they see the cancellation
+	})
+	defer stop()
+	controller.mutex.Lock()
+	defer controller.mutex.Unlock()
+	for controller.active >= controller.level && ctx.Err() == nil {
+		controller.cond.Wait()
+	}
+	if ctx.Err() != nil {
+		return false
+	}
+	controller.active++
+	return true
+}
+
+// release returns a slot taken by acquire
+func (controller *concurrencyController) release() {
+	controller.mutex.Lock()
+	defer controller.mutex.Unlock()
+	controller.active--
+	controller.cond.Signal()
+}
+
+// observe records one request's outcome, adjusting the level once a window is complete; a
+// window is at least autoWindowMin responses and twice the level, so every download counts
+func (controller *concurrencyController) observe(latency time.Duration, failed bool) {
+	controller.mutex.Lock()
+	defer controller.mutex.Unlock()
+	controller.requests++
+	controller.latency += latency
+	if failed {
+		controller.failures++
+	}
+	if controller.requests < max(autoWindowMin, 2*controller.level) {
+		return
+	}
+	errorRate := float64(controller.failures) / float64(controller.requests)
+	mean := controller.latency / time.Duration(controller.requests)
+	if controller.best == 0 || (mean < controller.best && controller.failures == 0) {
+		controller.best = mean // Fastest healthy window, the baseline for "slow"
+	}
+	level := controller.level
+	switch {
+	case errorRate > autoErrorRate:
+		level = max(controller.min, level/2) // Back off hard on an error spike
+	case mean > autoLatencyFactor*controller.best:
+		level = max(controller.min, level-1) // The server is struggling
+	default:
+		level = min(controller.max, level+1) // Healthy, try one more
+	}
+	if level != controller.level {
+		slog.Info("Adjusting download concurrency", "from", controller.level, "to", level, "error_rate", errorRate, "latency", mean, "best_latency", controller.best)
+		controller.level = level
+		controller.cond.Broadcast() // New slots, if it rose
+	}
+	controller.requests, controller.failures, controller.latency = 0, 0, 0
+}
+
+// observingTransport reports the outcome and time to response headers of every request it sends
+// to a concurrencyController; network errors, 429 and 5xx responses count as failures
+type observingTransport struct {
+	base       http.RoundTripper      // Transport that sends the requests
+	controller *concurrencyController // Receives every outcome
+}
+
+// RoundTrip sends the request and reports how it went
+func (transport *observingTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	start := time.Now()
+	response, err := transport.base.RoundTrip(request)
+	if cause := context.Cause(request.Context()); errors.Is(err, errDisallowedByRobots) || (cause != nil && !errors.Is(cause, errHeaderTimeout)) {
+		return response, err // Never reached the server, or the run is stopping
+	}
+	transport.controller.observe(time.Since(start), err != nil || isRetryableStatus(response.StatusCode))
+	return response, err
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"  // For cancelling in-flight work
+	"errors"   // For inspecting error values
+	"log/slog" // For structured, levelled logging
+	"net/http" // For making HTTP requests
+	"time"     // For time-related operations
+)
+
+// catalogPDF records the URL, size, content type and validators of the PDF at uri in the
+// manifest as filename without downloading it: a HEAD request, or a GET abandoned once its
+// headers arrive when the server can't answer HEAD; documents already mirrored keep their
+// entries, checksums included
+func (scraper *Scraper) catalogPDF(ctx context.Context, uri string, filename string) (DownloadResult, error) {
+	if previous, known := scraper.recorder.Lookup(filename); known && previous.SHA256 != "" && scraper.storage.Exists(filename) {
+		slog.Info("File already exists, keeping its manifest entry", "url", uri, "path", filename)
+		scraper.stats.SkippedExisting.Add(1)
+		return DownloadResult{Outcome: outcomeSkipped, Filename: filename, Reason: "exists"}, nil
+	}
+	resp, err := httpDoWithRetry(ctx, scraper.client, scraper.limiter, http.MethodHead, uri, nil, nil, scraper.config.RequestTimeout, scraper.config) // Send HTTP HEAD
+	if err == nil && (resp.StatusCode == http.StatusMethodNotAllowed || resp.StatusCode == http.StatusNotImplemented) {
+		resp.Body.Close()
+		slog.Debug("HEAD not supported, reading the headers of a GET", "url", uri, "status", resp.StatusCode)
+		resp, err = httpGetWithRetry(ctx, scraper.client, scraper.limiter, uri, nil, scraper.config.RequestTimeout, scraper.config)
+	}
+	if errors.Is(err, errOffSiteRedirect) {
+		scraper.stats.SkippedOffSite.Add(1) // Already logged by checkRedirect
+		return DownloadResult{Outcome: outcomeSkipped, Filename: filename, Reason: "off_site"}, nil
+	}
+	if err != nil {
+		slog.Error("Failed to catalog", "url", uri, "error", err)
+		return DownloadResult{}, scraper.downloadFailed(ctx, uri, 0, err.Error())
+	}
+	resp.Body.Close() // Only the headers are wanted; closing early abandons a GET's body
+	if resp.StatusCode != http.StatusOK {
+		slog.Error("Unexpected status while cataloging", "url", uri, "status", resp.StatusCode)
+		return DownloadResult{}, scraper.downloadFailed(ctx, uri, resp.StatusCode, http.StatusText(resp.StatusCode))
+	}
+	size := max(resp.ContentLength, 0) // 0 when the server didn't say
+	if resp.ContentLength < 0 {
+		slog.Debug("Server didn't report the size", "url", uri)
+	}
+	scraper.recorder.Record(ManifestEntry{
+		SourceURL:    uri,
+		FinalURL:     resp.Request.URL.String(),
+		Filename:     filename,
+		Size:         size,
+		DownloadedAt: time.Now().UTC(),
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		ContentType:  resp.Header.Get("Content-Type"),
+	})
+	scraper.stats.Cataloged.Add(1)
+	scraper.stats.CatalogedBytes.Add(size)
+	slog.Info("Cataloged PDF", "url", uri, "size", size, "content_type", resp.Header.Get("Content-Type"))
+	return DownloadResult{Outcome: outcomeCataloged, Filename: filename, Status: resp.StatusCode, Bytes: size}, nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import "time" // For time-related operations
+
+// Clock is the time source for rate limiting, retry backoff, crawl delays and request timeouts,
+// so their timing can be driven without real waits
+type Clock interface {
+	Now() time.Time                            // Current time
+	Sleep(d time.Duration)                     // Block for d
+	After(d time.Duration) <-chan time.Time    // Channel receiving the time once d has passed
+	AfterFunc(d time.Duration, f func()) Timer // Call f in its own goroutine once d has passed
+}
+
+// Timer is a pending AfterFunc call, as *time.Timer is for the real clock
+type Timer interface {
+	Stop() bool                 // Cancel the call, reporting whether it was still pending
+	Reset(d time.Duration) bool // Move the call to d from now, reporting whether it was still pending
+}
+
+// realClock is the Clock backed by the time package
+type realClock struct{}
+
+// Now returns time.Now
+func (realClock) Now() time.Time { return time.Now() }
+
+// Sleep calls time.Sleep
+func (realClock) Sleep(d time.Duration) { time.Sleep(d) }
+
+// After returns time.After
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// AfterFunc returns time.AfterFunc
+func (realClock) AfterFunc(d time.Duration, f func()) Timer { return time.AfterFunc(d, f) }
+
+// clock returns config's Clock, or the real one when none is set
+func (config Config) clock() Clock {
+	if config.Clock == nil {
+		return realClock{}
+	}
+	return config.Clock
+}
@@ -0,0 +1,403 @@
+package main
+
+import (
+	"context"           // For cancelling waits
+	"errors"            // For inspecting error values
+	"io"                // For reading response bodies
+	"net/http"          // For building responses
+	"net/http/httptest" // For local test servers
+	"slices"            // For ordering timers
+	"sync"              // For guarding the fake clock
+	"sync/atomic"       // For counting server hits
+	"testing"           // For the test framework
+	"time"              // For durations and times
+)
+
+// fakeClock is a Clock that only moves when advance is called, recording every wait asked of it
+type fakeClock struct {
+	mutex   sync.Mutex
+	changed *sync.Cond      // Broadcast whenever a timer is added
+	now     time.Time       // Current fake time
+	timers  []*fakeTimer    // Pending timers
+	waits   []time.Duration // Durations passed to After and Sleep, in order
+}
+
+// fakeTimer is a pending After channel or AfterFunc call of a fakeClock
+type fakeTimer struct {
+	clock    *fakeClock
+	deadline time.Time      // When the timer fires
+	channel  chan time.Time // Receives the time, for After
+	call     func()         // Called, for AfterFunc
+}
+
+// newFakeClock returns a fakeClock reading now
+func newFakeClock(now time.Time) *fakeClock {
+	clock := &fakeClock{now: now}
+	clock.changed = sync.NewCond(&clock.mutex)
+	return clock
+}
+
+// Now returns the fake time
+func (clock *fakeClock) Now() time.Time {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+	return clock.now
+}
+
+// After returns a channel that receives the fake time once it has been advanced by d
+func (clock *fakeClock) After(d time.Duration) <-chan time.Time {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+	clock.waits = append(clock.waits, d)
+	timer := &fakeTimer{clock: clock, deadline: clock.now.Add(d), channel: make(chan time.Time, 1)}
+	if d <= 0 {
+		timer.channel <- clock.now // Already due
+		return timer.channel
+	}
+	clock.add(timer)
+	return timer.channel
+}
+
+// Sleep blocks until the fake time has been advanced by d
+func (clock *fakeClock) Sleep(d time.Duration) {
+	<-clock.After(d)
+}
+
+// AfterFunc calls f once the fake time has been advanced by d
+func (clock *fakeClock) AfterFunc(d time.Duration, f func()) Timer {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+	timer := &fakeTimer{clock: clock, deadline: clock.now.Add(d), call: f}
+	clock.add(timer)
+	return timer
+}
+
+// add makes timer pending; the caller holds the mutex
+func (clock *fakeClock) add(timer *fakeTimer) {
+	clock.timers = append(clock.timers, timer)
+	clock.changed.Broadcast()
+}
+
+// advance moves the fake time forward by d, firing the timers that fall due in deadline order
+func (clock *fakeClock) advance(d time.Duration) {
+	clock.mutex.Lock()
+	clock.now = clock.now.Add(d)
+	var due []*fakeTimer
+	clock.timers = slices.DeleteFunc(clock.timers, func(timer *fakeTimer) bool {
+		if timer.deadline.After(clock.now) {
+			return false
+		}
+		due = append(due, timer)
+		return true
+	})
+	now := clock.now
+	clock.mutex.Unlock()
+	slices.SortStableFunc(due, func(a, b *fakeTimer) int { return a.deadline.Compare(b.deadline) })
+	for _, timer := range due {
+		if timer.call != nil {
+			go timer.call()
+		} else {
+			timer.channel <- now
+		}
+	}
+}
+
+// blockUntil waits until at least count After or Sleep calls are pending
+func (clock *fakeClock) blockUntil(count int) {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+	for clock.pendingWaits() < count {
+		clock.changed.Wait()
+	}
+}
+
+// blockUntilTimers waits until at least count timers of any kind are pending
+func (clock *fakeClock) blockUntilTimers(count int) {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+	for len(clock.timers) < count {
+		clock.changed.Wait()
+	}
+}
+
+// pendingWaits counts the pending After and Sleep calls; the caller holds the mutex
+func (clock *fakeClock) pendingWaits() int {
+	count := 0
+	for _, timer := range clock.timers {
+		if timer.channel != nil {
+			count++
+		}
+	}
+	return count
+}
+
+// recordedWaits returns a copy of the durations passed to After and Sleep so far
+func (clock *fakeClock) recordedWaits() []time.Duration {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+	return slices.Clone(clock.waits)
+}
+
+// Stop cancels the timer, reporting whether it was still pending
+func (timer *fakeTimer) Stop() bool {
+	clock := timer.clock
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+	pending := slices.Contains(clock.timers, timer)
+	clock.timers = slices.DeleteFunc(clock.timers, func(other *fakeTimer) bool { return other == timer })
+	return pending
+}
+
+// Reset moves the timer to d after the current fake time, reporting whether it was still pending
+func (timer *fakeTimer) Reset(d time.Duration) bool {
+	clock := timer.clock
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+	pending := slices.Contains(clock.timers, timer)
+	timer.deadline = clock.now.Add(d)
+	if !pending {
+		clock.add(timer)
+	}
+	return pending
+}
+
+// testClockStart is the fake time tests start at
+var testClockStart = time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func TestRetryDelay(t *testing.T) {
+	now := testClockStart
+	tooMany := func(retryAfter string) *http.Response {
+		return &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{"Retry-After": {retryAfter}}}
+	}
+	unavailable := &http.Response{StatusCode: http.StatusServiceUnavailable, Header: http.Header{"Retry-After": {"60"}}}
+	tests := []struct {
+		name     string
+		attempt  int
+		response *http.Response
+		min, max time.Duration
+	}{
+		{"first retry", 0, nil, 500 * time.Millisecond, 750 * time.Millisecond},
+		{"second retry doubles", 1, nil, time.Second, 1500 * time.Millisecond},
+		{"fourth retry", 3, nil, 4 * time.Second, 6 * time.Second},
+		{"backoff is capped", 10, nil, maxRetryDelay, maxRetryDelay * 3 / 2},
+		{"large attempt doesn't overflow", 1000, nil, maxRetryDelay, maxRetryDelay * 3 / 2},
+		{"retry-after seconds", 0, tooMany("7"), 7 * time.Second, 7 * time.Second},
+		{"retry-after date", 0, tooMany(now.Add(30 * time.Second).Format(http.TimeFormat)), 30 * time.Second, 30 * time.Second},
+		{"retry-after date in the past", 2, tooMany(now.Add(-time.Minute).Format(http.TimeFormat)), 0, 0},
+		{"unparseable retry-after backs off", 1, tooMany("soon"), time.Second, 1500 * time.Millisecond},
+		{"retry-after only honoured for 429", 0, unavailable, 500 * time.Millisecond, 750 * time.Millisecond},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for range 20 { // Jitter is random
+				delay := retryDelay(test.attempt, test.response, now)
+				if delay < test.min || delay > test.max {
+					t.Fatalf("retryDelay(%d) = %s, want between %s and %s", test.attempt, delay, test.min, test.max)
+				}
+			}
+		})
+	}
+}
+
+func TestHTTPDoWithRetryBackoff(t *testing.T) {
+	var hits atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch hits.Add(1) {
+		case 1:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case 2:
+			w.Header().Set("Retry-After", "2")
+			w.WriteHeader(http.StatusTooManyRequests)
+		default:
+			io.WriteString(w, "ok")
+		}
+	}))
+	defer server.Close()
+
+	clock := newFakeClock(testClockStart)
+	config := Config{MaxRetries: 3, Clock: clock, UserAgent: "test"}
+	type outcome struct {
+		response *http.Response
+		err      error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		response, err := httpGetWithRetry(context.Background(), server.Client(), nil, server.URL, nil, time.Hour, config)
+		done <- outcome{response, err}
+	}()
+	for retry := range 2 {
+		clock.blockUntil(1) // The backoff before the next attempt
+		waits := clock.recordedWaits()
+		if len(waits) != retry+1 {
+			t.Fatalf("after retry %d: waits = %v", retry, waits)
+		}
+		clock.advance(waits[retry])
+	}
+	result := <-done
+	if result.err != nil {
+		t.Fatalf("httpGetWithRetry: %v", result.err)
+	}
+	defer result.response.Body.Close()
+	if result.response.StatusCode != http.StatusOK || hits.Load() != 3 {
+		t.Fatalf("status %d after %d requests, want 200 after 3", result.response.StatusCode, hits.Load())
+	}
+	waits := clock.recordedWaits()
+	if waits[0] < 500*time.Millisecond || waits[0] > 750*time.Millisecond {
+		t.Errorf("backoff after 503 = %s, want 500ms to 750ms", waits[0])
+	}
+	if waits[1] != 2*time.Second {
+		t.Errorf("backoff after 429 = %s, want the Retry-After of 2s", waits[1])
+	}
+}
+
+func TestHTTPDoWithRetryGivesUp(t *testing.T) {
+	var hits atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	clock := newFakeClock(testClockStart)
+	done := make(chan *http.Response, 1)
+	go func() {
+		response, _ := httpGetWithRetry(context.Background(), server.Client(), nil, server.URL, nil, time.Hour, Config{MaxRetries: 2, Clock: clock})
+		done <- response
+	}()
+	for retry := range 2 {
+		clock.blockUntil(1)
+		clock.advance(clock.recordedWaits()[retry])
+	}
+	response := <-done
+	if response == nil || response.StatusCode != http.StatusBadGateway {
+		t.Fatalf("want the last 502 response, got %v", response)
+	}
+	response.Body.Close()
+	if hits.Load() != 3 {
+		t.Errorf("sent %d requests, want 3", hits.Load())
+	}
+}
+
+func TestHTTPDoWithRetryHeaderTimeout(t *testing.T) {
+	arrived := make(chan struct{}, 1)
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		arrived <- struct{}{}
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	clock := newFakeClock(testClockStart)
+	done := make(chan error, 1)
+	go func() {
+		_, err := httpGetWithRetry(context.Background(), server.Client(), nil, server.URL, nil, 10*time.Second, Config{Clock: clock})
+		done <- err
+	}()
+	<-arrived
+	clock.advance(10 * time.Second) // The header timeout fires on the fake clock
+	if err := <-done; !errors.Is(err, errHeaderTimeout) {
+		t.Fatalf("err = %v, want errHeaderTimeout", err)
+	}
+}
+
+func TestHTTPDoWithRetryStallTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "%PDF-")
+		w.(http.Flusher).Flush()
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	clock := newFakeClock(testClockStart)
+	response, err := httpGetWithRetry(context.Background(), server.Client(), nil, server.URL, nil, time.Hour, Config{Clock: clock, StallTimeout: 5 * time.Second})
+	if err != nil {
+		t.Fatalf("httpGetWithRetry: %v", err)
+	}
+	defer response.Body.Close()
+	head := make([]byte, 5)
+	if _, err := io.ReadFull(response.Body, head); err != nil {
+		t.Fatalf("reading the first bytes: %v", err)
+	}
+	clock.blockUntilTimers(1)      // The stall timer, restarted by the read
+	clock.advance(5 * time.Second) // No more bytes arrive
+	if _, err := io.ReadAll(response.Body); !errors.Is(err, errStalled) {
+		t.Fatalf("err = %v, want errStalled", err)
+	}
+}
+
+func TestRateLimiterWaitPacing(t *testing.T) {
+	clock := newFakeClock(testClockStart)
+	limiter := newRateLimiter(10, clock) // One request every 100ms
+	ctx := context.Background()
+	if err := limiter.Wait(ctx); err != nil {
+		t.Fatalf("first Wait: %v", err)
+	}
+	if waits := clock.recordedWaits(); len(waits) != 0 {
+		t.Fatalf("first Wait waited %v, want no wait", waits)
+	}
+
+	done := make(chan error, 2)
+	for range 2 {
+		go func() { done <- limiter.Wait(ctx) }()
+	}
+	clock.blockUntil(2) // Both callers reserved a slot
+	waits := clock.recordedWaits()
+	slices.Sort(waits)
+	if !slices.Equal(waits, []time.Duration{100 * time.Millisecond, 200 * time.Millisecond}) {
+		t.Fatalf("waits = %v, want 100ms and 200ms", waits)
+	}
+	clock.advance(100 * time.Millisecond)
+	if err := <-done; err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+	select {
+	case <-done:
+		t.Fatal("the second caller got its slot before 200ms")
+	case <-time.After(20 * time.Millisecond):
+	}
+	clock.advance(100 * time.Millisecond)
+	if err := <-done; err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+
+	clock.advance(time.Second) // Idle: the next slot is free right away
+	if err := limiter.Wait(ctx); err != nil {
+		t.Fatalf("Wait after idling: %v", err)
+	}
+	if waits := clock.recordedWaits(); len(waits) != 2 {
+		t.Fatalf("Wait after idling waited, waits = %v", waits)
+	}
+}
+
+func TestRateLimiterWaitCancelled(t *testing.T) {
+	clock := newFakeClock(testClockStart)
+	limiter := newRateLimiter(1, clock)
+	ctx, cancel := context.WithCancel(context.Background())
+	limiter.Wait(ctx) // Takes the free slot
+	done := make(chan error, 1)
+	go func() { done <- limiter.Wait(ctx) }()
+	clock.blockUntil(1)
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+}
+
+func TestUnlimitedRateLimiter(t *testing.T) {
+	if limiter := newRateLimiter(0, newFakeClock(testClockStart)); limiter != nil {
+		t.Fatalf("newRateLimiter(0) = %v, want nil", limiter)
+	}
+	var limiter *rateLimiter
+	if err := limiter.Wait(context.Background()); err != nil {
+		t.Fatalf("nil limiter Wait: %v", err)
+	}
+}
@@ -0,0 +1,504 @@
+package main
+
+import (
+	"crypto/x509"   // For trusting extra certificate authorities
+	"errors"        // For creating error values
+	"flag"          // For parsing command-line flags
+	"fmt"           // For formatted I/O operations
+	"io"            // For general I/O primitives
+	"log/slog"      // For structured, levelled logging
+	"net"           // For validating addresses
+	"net/http"      // For making HTTP requests
+	"net/url"       // For parsing and manipulating URLs
+	"os"            // For file and system operations
+	"regexp"        // For document link patterns
+	"slices"        // For searching slices
+	"sort"          // For ordering manifest entries
+	"strconv"       // For parsing boolean flag values
+	"strings"       // For string manipulation
+	"text/template" // For naming files from templates
+	"time"          // For time-related operations
+)
+
+// Config holds all the settings for a scraping run
+type Config struct {
+	OutputDir         string               // Directory to save downloaded PDFs
+	TempDir           string               // Directory downloads stream to before being moved into OutputDir; "" to stage them next to their final path
+	HTMLCacheDir      string               // Directory caching each scraped search page in its own file
+	CheckpointFile    string               // Deprecated -checkpoint file, only warned about; the HTML cache records the finished pages
+	RefreshHTML       bool                 // Fetch every search page again instead of reading the HTML cache
+	KeepHTML          bool                 // Keep the HTML cache after a completed crawl
+	MaxPage           int                  // Most next-page links followed for each letter
+	Letters           string               // Lowercase letters whose search pages are crawled
+	RefreshOlderThan  time.Duration        // Download existing PDFs again once their stored copy is older than this (0 never does)
+	Deterministic     bool                 // Download in sorted URL order, one request at a time, for reproducible runs
+	Concurrency       int                  // Maximum number of simultaneous requests per phase
+	ScrapeWorkers     int                  // Search or sitemap pages fetched at once (0 means Concurrency)
+	DownloadWorkers   int                  // PDFs downloaded at once (0 means Concurrency)
+	ConcurrencyAuto   bool                 // Tune the downloads running at once between ConcurrencyMin and ConcurrencyMax
+	ConcurrencyMin    int                  // Fewest downloads at once with ConcurrencyAuto
+	ConcurrencyMax    int                  // Most downloads at once with ConcurrencyAuto
+	QueueSize         int                  // Links and jobs buffered between the pipeline stages (0 means DownloadWorkers)
+	RequestTimeout    time.Duration        // Time to wait for a search page's response headers
+	DownloadTimeout   time.Duration        // Time to wait for a PDF's response headers
+	StallTimeout      time.Duration        // Abort a response body after this long without data (0 never aborts)
+	Deadline          time.Duration        // Stop the whole run after this long (0 means no limit)
+	MaxRetries        int                  // Number of times a failed request is retried
+	RequestsPerSec    float64              // Maximum request rate across all goroutines (0 means unlimited)
+	AdaptiveRPS       bool                 // Halve the request rate on 429/503 responses and recover it after sustained success
+	MaxIdleConns      int                  // Idle connections kept across all hosts (0 sizes the pool from -concurrency)
+	MaxConnsPerHost   int                  // Most connections open to one host (0 means unlimited)
+	HTTP2             bool                 // Negotiate HTTP/2 with servers that support it
+	Clock             Clock                // Time source for rate limiting, retries, crawl delays and request timeouts (nil means the real clock)
+	FS                FS                   // Filesystem for the output directory, caches, lists and logs (nil means the real disk)
+	Shuffle           bool                 // Crawl letters in a random order with random delays between search page requests
+	Seed              int64                // Seed for -shuffle's order and delays (0 picks one from the clock)
+	JitterMin         time.Duration        // Shortest random delay before a search page request with -shuffle
+	JitterMax         time.Duration        // Longest random delay before a search page request with -shuffle
+	UserAgent         string               // User-Agent header sent with every request
+	Headers           http.Header          // Extra headers sent with every request
+	BasicAuth         string               // "user:pass" sent as HTTP Basic credentials with every request
+	BearerToken       string               // Token sent as a Bearer Authorization header with every request
+	Proxy             *url.URL             // Proxy for all requests (nil falls back to HTTP_PROXY/HTTPS_PROXY)
+	RootCAs           *x509.CertPool       // Certificate authorities trusted for TLS (nil means the system's)
+	Resolve           map[string]string    // Lowercased host → IP address connections to it are pinned to
+	DNSServer         string               // host:port of the DNS server to resolve hosts with ("" uses the system's)
+	InsecureTLS       bool                 // Skip TLS certificate verification entirely
+	Search            SearchOptions        // Which SDS categories the search covers
+	MaxFiles          int                  // Stop after downloading this many PDFs (0 means unlimited)
+	MaxBytes          int64                // Stop after downloading this many bytes (0 means unlimited)
+	MaxFileSize       int64                // Skip PDFs larger than this many bytes (0 means unlimited)
+	MinBytes          int64                // Reject PDFs smaller than this many bytes as error stubs
+	Precheck          bool                 // Send a HEAD request before each download to skip unwanted files
+	MaxFailures       int                  // Exit non-zero once more downloads than this fail (-1 disables the check)
+	IgnoreRobots      bool                 // Skip the robots.txt check
+	SameHost          bool                 // Refuse redirects that leave airgas.com and the requested host
+	MaxRedirects      int                  // Longest redirect chain followed for one request
+	DocumentPatterns  []*regexp.Regexp     // Links matching these are documents too, besides .pdf links
+	URLFile           string               // File of PDF URLs to download instead of crawling the search
+	Sitemap           string               // Sitemap URL whose pages and PDFs are scraped instead of the search
+	OnlyNew           bool                 // Only download documents that aren't in the mirror yet, without checking known ones for changes
+	NewList           string               // File listing the URLs of documents that weren't in the mirror yet ("" writes none)
+	FailuresFile      string               // File listing this run's permanently failed downloads ("" means OutputDir/failures.txt)
+	Shard             string               // How PDFs are split into subdirectories: none, letter or hash
+	QueryNaming       QueryNaming          // How query strings become part of filenames
+	IgnoreParams      []string             // Query parameters, lowercased, that don't change which document a URL points at; "x*" matches a prefix
+	NameTemplate      *template.Template   // Renders each document's filename from its URL
+	NamePrefix        *template.Template   // Renders text put before each document's filename, or nil
+	NameSuffix        *template.Template   // Renders text put before each document's file extension, or nil
+	Storage           string               // Where PDFs are stored: empty for OutputDir, or s3://bucket/prefix
+	Archive           string               // Zip or tar archive to store PDFs and the manifest in, instead of the output directory
+	OnDownload        []*template.Template // Argument templates of a command run on every saved PDF (nil runs none)
+	OnDownloadDelete  bool                 // Delete a saved PDF when the -on-download command fails
+	HookConcurrency   int                  // Most -on-download commands running at once
+	OutDB             string               // SQLite database to store PDFs and their metadata in ("" stores files)
+	DBBlobs           bool                 // Store the PDFs themselves in the -out-db database, not only their metadata
+	SaveHeaders       bool                 // Save each PDF's response headers in a .meta.json sidecar
+	ResumePartial     bool                 // Keep interrupted downloads as .part files and resume them with Range requests
+	Since             time.Time            // Skip documents last updated before this time (zero downloads everything)
+	Force             bool                 // Run even if another instance holds the output directory's lock
+	HeadOnly          bool                 // Record each PDF's URL, size and headers in the manifest without downloading it
+	DryRun            bool                 // List the PDFs that would be downloaded without downloading them
+	ListOnly          bool                 // Write the discovered PDF URLs, instead of downloading them
+	URLsOut           string               // File -list-only writes to, instead of stdout
+	ListFormat        string               // -list-only line format: text or json
+	PauseFile         string               // Pause new requests while this file exists, besides SIGUSR1/SIGUSR2
+	EventsJSON        string               // Where newline-delimited JSON events go: a file, "-" for stdout or "fd:N"; "" for none
+	Verify            string               // Manifest to check the output directory against instead of downloading
+	Trace             bool                 // Log DNS, connect, TLS and first-byte timings of every request at debug level
+	MetricsAddr       string               // Address to serve Prometheus metrics on ("" disables the server)
+	ValidateExisting  bool                 // Check the documents already in the output directory before downloading
+	ValidateChecksums bool                 // Also compare them against their manifest checksums
+	ValidateKeep      bool                 // Only report corrupt documents instead of removing them
+	ReportDupes       bool                 // Print the groups of byte-identical documents in the output directory instead of downloading
+	Slowest           int                  // Number of slowest and lowest-throughput downloads listed after the summary
+	Quiet             bool                 // Don't print periodic progress lines
+	LogFormat         string               // Log output format: auto, text or json
+	LogLevel          string               // Minimum log level: debug, info, warn or error
+	LogFile           string               // File that also receives the logs as JSON lines ("" logs to stderr only)
+	LogMaxSize        int64                // Rotate the log file before it grows past this many megabytes (0 never rotates on size)
+	LogRotateEvery    time.Duration        // Rotate the log file once it is this old (0 never rotates on age)
+	LogKeep           int                  // Rotated log files kept
+}
+
+// headerFlag collects repeated -header "Key: Value" flags into an http.Header
+type headerFlag http.Header
+
+// String returns the collected headers for flag's usage output
+func (header headerFlag) String() string {
+	var pairs []string
+	for key, values := range redactHeaders(http.Header(header)) {
+		for _, value := range values {
+			pairs = append(pairs, key+": "+value) // One pair per value
+		}
+	}
+	sort.Strings(pairs) // Deterministic output
+	return strings.Join(pairs, ", ")
+}
+
+// Set parses one "Key: Value" flag value
+func (header headerFlag) Set(value string) error {
+	key, headerValue, found := strings.Cut(value, ":") // Split name from value
+	key = strings.TrimSpace(key)
+	if !found || key == "" {
+		return fmt.Errorf("header %q must look like \"Key: Value\"", value) // Reject malformed headers
+	}
+	http.Header(header).Add(key, strings.TrimSpace(headerValue)) // Store under the canonical key
+	return nil
+}
+
+// loadCAFile returns the system's certificate pool with the PEM certificates in path added
+func loadCAFile(path string) (*x509.CertPool, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading -ca-file: %w", err)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool() // No system pool, as on some minimal containers
+	}
+	if !pool.AppendCertsFromPEM(content) {
+		return nil, fmt.Errorf("invalid -ca-file %q: no PEM certificates found", path)
+	}
+	return pool, nil
+}
+
+// allLetters are the letters crawled by default
+const allLetters = "abcdefghijklmnopqrstuvwxyz"
+
+// parseLetters validates a -letters value and returns its distinct letters, lowercased, in order
+func parseLetters(value string) (string, error) {
+	var letters []rune
+	for _, char := range strings.ToLower(value) {
+		if char < 'a' || char > 'z' {
+			return "", fmt.Errorf("invalid -letters %q: %q is not a letter from a to z", value, char)
+		}
+		if !slices.Contains(letters, char) {
+			letters = append(letters, char) // Crawl each letter once
+		}
+	}
+	if len(letters) == 0 {
+		return "", fmt.Errorf("invalid -letters %q: no letters given", value)
+	}
+	return string(letters), nil
+}
+
+// parseFlags builds a Config from the command-line flags
+func parseFlags() Config {
+	var config Config
+	flag.StringVar(&config.OutputDir, "out", "PDFs/", "directory to save downloaded PDFs")                                                                                                                     // Output directory flag
+	flag.StringVar(&config.TempDir, "tmp-dir", "", "directory of its own that downloads stream to before being moved into -out, copying across filesystems (default: next to each PDF, for an atomic rename)") // Temp directory flag
+	flag.StringVar(&config.HTMLCacheDir, "html-cache", "html-cache", "directory caching each scraped search page; cached pages aren't fetched again unless -refresh-html is set")                              // HTML cache directory flag
+	flag.StringVar(&config.CheckpointFile, "checkpoint", "", "deprecated and ignored: the -html-cache directory now records which search pages are done")                                                      // Old checkpoint file flag
+	flag.BoolVar(&config.RefreshHTML, "refresh-html", false, "fetch every search page again, replacing its copy in the HTML cache, instead of reusing cached pages")                                           // Fresh crawl flag
+	flag.BoolVar(&config.KeepHTML, "keep-html", true, "keep the HTML cache after a completed crawl; -keep-html=false removes it so the next run crawls from scratch")                                          // Cache retention flag
+	flag.IntVar(&config.MaxPage, "max-page", 300, "most next-page links followed for each letter, as a safety cap")                                                                                            // Page range flag
+	config.Letters = allLetters
+	flag.Func("letters", "letters to crawl search pages for, such as abc (default a-z)", func(value string) error {
+		letters, err := parseLetters(value)
+		if err != nil {
+			return err
+		}
+		config.Letters = letters
+		return nil
+	}) // Letter subset flag
+	flag.DurationVar(&config.RefreshOlderThan, "refresh-older-than", 0, "download existing PDFs again when they were fetched longer ago than this, such as 720h (0 keeps them)")                          // Staleness flag
+	flag.BoolVar(&config.Deterministic, "deterministic", false, "sort the found URLs before downloading and use -concurrency 1, so runs over the same pages log and record in the same order")            // Reproducible order flag
+	flag.IntVar(&config.Concurrency, "concurrency", 16, "maximum number of simultaneous requests per phase")                                                                                              // Concurrency limit flag
+	flag.BoolVar(&config.ConcurrencyAuto, "concurrency-auto", false, "tune how many PDFs download at once, from -concurrency-min up to -concurrency-max, by the error rate and latency of the responses") // Adaptive concurrency flag
+	flag.IntVar(&config.ConcurrencyMin, "concurrency-min", 2, "fewest PDFs -concurrency-auto downloads at once, and where it starts")                                                                     // Adaptive concurrency floor flag
+	flag.IntVar(&config.ConcurrencyMax, "concurrency-max", 32, "most PDFs -concurrency-auto downloads at once")                                                                                           // Adaptive concurrency ceiling flag
+	flag.IntVar(&config.ScrapeWorkers, "scrape-workers", 0, "search or sitemap pages fetched at once (0 means -concurrency)")                                                                             // Scrape phase workers flag
+	flag.IntVar(&config.DownloadWorkers, "download-workers", 0, "PDFs downloaded at once (0 means -concurrency)")                                                                                         // Download phase workers flag
+	flag.IntVar(&config.QueueSize, "queue-size", 0, "found links buffered while downloads catch up; the crawl pauses when the queue is full (0 means -download-workers)")                                 // Backpressure flag
+	flag.DurationVar(&config.RequestTimeout, "timeout", 90*time.Second, "time to wait for a search page's response headers")                                                                              // Request timeout flag
+	flag.DurationVar(&config.DownloadTimeout, "download-timeout", 30*time.Second, "time to wait for a PDF's response headers")                                                                            // Download timeout flag
+	flag.DurationVar(&config.StallTimeout, "stall-timeout", 30*time.Second, "abort a download after this long without receiving data (0 never aborts)")                                                   // Stall timeout flag
+	flag.DurationVar(&config.Deadline, "deadline", 0, "stop the whole run after this long, cancelling outstanding downloads (0 means no limit)")                                                          // Run deadline flag
+	flag.IntVar(&config.MaxRetries, "retries", 3, "number of times a failed request is retried")                                                                                                          // Retry count flag
+	flag.StringVar(&config.UserAgent, "user-agent", "airgas-sds-scraper/1.0", "User-Agent header sent with every request")                                                                                // User-Agent flag
+	config.Headers = make(http.Header)
+	flag.Var(headerFlag(config.Headers), "header", `extra "Key: Value" header sent with every request (repeatable)`) // Custom header flag
+	flag.Func("basic-auth", `"user:pass" HTTP Basic credentials sent with every request`, func(value string) error {
+		if !strings.Contains(value, ":") {
+			return errors.New(`want "user:pass"`) // Never echo the credentials back
+		}
+		config.BasicAuth = value
+		return nil
+	}) // Basic auth flag
+	flag.StringVar(&config.BearerToken, "bearer-token", "", "token sent as \"Authorization: Bearer <token>\" with every request") // Bearer auth flag
+	flag.Func("proxy", "HTTP/HTTPS proxy URL for all requests (defaults to HTTP_PROXY/HTTPS_PROXY)", func(value string) error {
+		proxy, err := url.Parse(value) // Validate the proxy URL
+		if err != nil || proxy.Scheme == "" || proxy.Host == "" {
+			return fmt.Errorf("invalid proxy URL %q", value)
+		}
+		config.Proxy = proxy
+		return nil
+	}) // Proxy flag
+	flag.Func("ca-file", "PEM bundle of extra certificate authorities to trust, such as a corporate proxy's", func(value string) error {
+		pool, err := loadCAFile(value)
+		if err != nil {
+			return err
+		}
+		config.RootCAs = pool
+		return nil
+	}) // CA bundle flag
+	config.Resolve = make(map[string]string)
+	flag.Func("resolve", "connect to a host at a fixed address instead of resolving it, as host=ip, such as www.airgas.com=203.0.113.7 (repeatable)", func(value string) error {
+		host, address, found := strings.Cut(value, "=")
+		if !found || host == "" || net.ParseIP(address) == nil {
+			return fmt.Errorf("invalid -resolve %q: want host=ip", value)
+		}
+		config.Resolve[strings.ToLower(host)] = address
+		return nil
+	}) // Host override flag
+	flag.Func("dns-server", "resolve hosts with this DNS server, as ip or ip:port, instead of the system's", func(value string) error {
+		if _, _, err := net.SplitHostPort(value); err != nil {
+			value = net.JoinHostPort(value, "53") // Default DNS port
+		}
+		if host, _, _ := net.SplitHostPort(value); net.ParseIP(host) == nil {
+			return fmt.Errorf("invalid -dns-server %q: want ip or ip:port", value)
+		}
+		config.DNSServer = value
+		return nil
+	}) // Resolver flag
+	flag.BoolVar(&config.InsecureTLS, "insecure-skip-verify", false, "don't verify TLS certificates at all (unsafe; only for debugging proxies)")                                   // TLS verification opt-out
+	flag.IntVar(&config.MaxIdleConns, "max-idle-conns", 0, "idle connections kept open across all hosts (0 means max(100, -concurrency))")                                          // Idle pool flag
+	flag.IntVar(&config.MaxConnsPerHost, "max-conns-per-host", 0, "most connections open to one host at once (0 means unlimited)")                                                  // Per-host connection cap flag
+	flag.BoolVar(&config.HTTP2, "http2", true, "negotiate HTTP/2 where supported; -http2=false forces HTTP/1.1")                                                                    // HTTP/2 toggle
+	flag.Float64Var(&config.RequestsPerSec, "rps", 10, "maximum requests per second across all goroutines (0 means unlimited)")                                                     // Rate limit flag
+	flag.BoolVar(&config.AdaptiveRPS, "adaptive-rps", false, "halve the -rps rate whenever the server answers 429 or 503, and raise it back gradually once requests succeed again") // Adaptive throttle flag
+	flag.BoolVar(&config.Shuffle, "shuffle", false, "crawl letters in a random order and wait a random delay before each search page request")                                      // Crawl shuffle toggle
+	flag.Int64Var(&config.Seed, "seed", 0, "seed for -shuffle, to reproduce a run's order and delays (0 picks one from the clock)")                                                 // Shuffle seed flag
+	flag.DurationVar(&config.JitterMin, "jitter-min", 0, "shortest random delay before a search page request with -shuffle")                                                        // Minimum jitter flag
+	flag.DurationVar(&config.JitterMax, "jitter-max", 500*time.Millisecond, "longest random delay before a search page request with -shuffle")                                      // Maximum jitter flag
+	flag.BoolVar(&config.Search.PureGases, "pure-gases", false, "restrict the search to pure gas SDS sheets")                                                                       // Pure gases toggle
+	flag.BoolVar(&config.Search.MixedGases, "mixed-gases", false, "restrict the search to mixed gas SDS sheets")                                                                    // Mixed gases toggle
+	flag.BoolVar(&config.Search.HardGoods, "hard-goods", false, "restrict the search to hardgoods SDS sheets")                                                                      // Hardgoods toggle
+	config.Search.Method, config.Search.BodyFormat = http.MethodGet, "form"
+	flag.Func("search-method", "HTTP method of search page requests: GET (default), or POST sending the search parameters as the body, paged by number", func(value string) error {
+		method := strings.ToUpper(value)
+		if !slices.Contains(searchMethods, method) {
+			return fmt.Errorf("invalid -search-method %q: want GET or POST", value)
+		}
+		config.Search.Method = method
+		return nil
+	}) // Search method flag
+	flag.Func("search-body", "how -search-method POST encodes the search parameters: form (default) or json", func(value string) error {
+		if !slices.Contains(searchBodyFormats, value) {
+			return fmt.Errorf("invalid -search-body %q: want form or json", value)
+		}
+		config.Search.BodyFormat = value
+		return nil
+	}) // Search body format flag
+	flag.IntVar(&config.MaxFiles, "max-files", 0, "stop after downloading this many PDFs (0 means unlimited)")                                          // File budget flag
+	flag.Int64Var(&config.MaxBytes, "max-bytes", 0, "stop after downloading this many bytes (0 means unlimited)")                                       // Byte budget flag
+	flag.Int64Var(&config.MaxFileSize, "max-file-size", 0, "skip PDFs larger than this many bytes (0 means unlimited)")                                 // File size cap flag
+	flag.Int64Var(&config.MinBytes, "min-bytes", 1024, "reject PDFs smaller than this many bytes as error stubs (0 only rejects empty ones)")           // Minimum size flag
+	flag.BoolVar(&config.Precheck, "precheck", false, "send a HEAD request first and skip PDFs over the size cap or of the wrong type")                 // HEAD pre-check flag
+	flag.BoolVar(&config.IgnoreRobots, "ignore-robots", false, "don't fetch or obey airgas.com's robots.txt (only with permission)")                    // Robots opt-out flag
+	flag.BoolVar(&config.SameHost, "same-host", false, "skip URLs that redirect away from airgas.com (and the host originally requested)")              // Off-site redirect flag
+	flag.IntVar(&config.MaxRedirects, "max-redirects", defaultMaxRedirects, "most redirects followed for one request before it fails (0 follows none)") // Redirect limit flag
+	flag.IntVar(&config.MaxFailures, "max-failures", 0, "exit non-zero if more downloads than this fail (-1 disables the check)")                       // Failure threshold flag
+	flag.Func("doc-pattern", "regular expression for document links without a .pdf extension, such as /document/download\\?id= (repeatable; matches are kept only if they turn out to be PDFs)", func(value string) error {
+		pattern, err := regexp.Compile(value)
+		if err != nil {
+			return fmt.Errorf("invalid -doc-pattern: %w", err)
+		}
+		config.DocumentPatterns = append(config.DocumentPatterns, pattern)
+		return nil
+	}) // Document pattern flag
+	flag.BoolFunc("sds-endpoints", "also treat links to known SDS download endpoints, such as getsds.aspx and /document/download, as documents", func(value string) error {
+		enabled, err := strconv.ParseBool(value)
+		if err != nil || !enabled {
+			return err // -sds-endpoints=false leaves them off
+		}
+		for _, expression := range knownDocumentEndpoints {
+			config.DocumentPatterns = append(config.DocumentPatterns, regexp.MustCompile(expression))
+		}
+		return nil
+	}) // Known endpoint flag
+	flag.StringVar(&config.URLFile, "url-file", "", "download the newline-separated URLs in this file instead of crawling the search") // URL list flag
+	flag.Func("sitemap", "read this sitemap.xml (or sitemap index, gzipped or not) instead of crawling the search", func(value string) error {
+		if parsed, err := url.Parse(value); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return fmt.Errorf("invalid sitemap URL %q", value)
+		}
+		config.Sitemap = value
+		return nil
+	}) // Sitemap flag
+	flag.Func("since", "only download documents updated at or after this RFC3339 time or YYYY-MM-DD date", func(value string) error {
+		since, err := time.Parse(time.RFC3339, value) // Full timestamp
+		if err != nil {
+			since, err = time.Parse(time.DateOnly, value) // Plain date, midnight UTC
+		}
+		if err != nil {
+			return fmt.Errorf("invalid -since %q: want RFC3339 or YYYY-MM-DD", value)
+		}
+		config.Since = since
+		return nil
+	}) // Update cutoff flag
+	config.IgnoreParams = trackingParams
+	flag.Func("ignore-params", "comma-separated query parameters that don't change the document, so URLs differing only in them are downloaded once; x* matches a prefix (default \""+strings.Join(trackingParams, ",")+"\", \"\" for none)", func(value string) error {
+		ignored, err := parseIgnoredParams(value)
+		config.IgnoreParams = ignored
+		return err
+	}) // Ignored query parameters flag
+	config.QueryNaming = QueryNaming{Mode: "keep"}
+	flag.Func("flatten-query", "how query strings appear in filenames: keep (default), drop, hash or only:param1,param2", func(value string) error {
+		naming, err := parseQueryNaming(value)
+		if err != nil {
+			return err
+		}
+		config.QueryNaming = naming
+		return nil
+	}) // Query naming flag
+	config.NameTemplate = template.Must(parseNameTemplate(defaultNameTemplate))
+	flag.Func("name-template", "text/template for filenames, using .Host .Path .Dir .Base .Ext .Query .Hash and .ID (default \""+defaultNameTemplate+"\")", func(value string) error {
+		nameTemplate, err := parseNameTemplate(value)
+		if err != nil {
+			return err
+		}
+		config.NameTemplate = nameTemplate
+		return nil
+	}) // Filename template flag
+	flag.Func("name-prefix", "text/template put before every document filename, with the fields of -name-template, such as \"{{.ID}}-\"", func(value string) error {
+		prefix, err := parseNameAffix("name-prefix", value)
+		config.NamePrefix = prefix
+		return err
+	}) // Filename prefix flag
+	flag.Func("name-suffix", "text/template put before every document filename's extension, with the fields of -name-template, such as \"_{{.ID}}\"", func(value string) error {
+		suffix, err := parseNameAffix("name-suffix", value)
+		config.NameSuffix = suffix
+		return err
+	}) // Filename suffix flag
+	config.Shard = "none"
+	flag.Func("shard", "split PDFs into subdirectories: none, letter (first character of the document name) or hash", func(value string) error {
+		if !slices.Contains(shardModes, value) {
+			return fmt.Errorf("invalid -shard %q: want none, letter or hash", value)
+		}
+		config.Shard = value
+		return nil
+	}) // Sharding flag
+	flag.BoolVar(&config.OnlyNew, "only-new", false, "only download documents that aren't in the output directory or manifest yet, without checking known ones for changes")                 // New documents toggle
+	flag.StringVar(&config.NewList, "new-list", "", "write the URLs of documents that weren't in the mirror yet to this file, usable with -url-file (with -dry-run, nothing is downloaded)") // New document list flag
+	flag.StringVar(&config.FailuresFile, "failures-file", "", "file listing downloads that failed for good, usable with -url-file (default failures.txt in the output directory)")           // Dead-letter file flag
+	flag.StringVar(&config.Storage, "storage", "", "store PDFs in s3://bucket/prefix instead of the output directory, which then only holds the manifest and temp files")                    // Storage backend flag
+	flag.StringVar(&config.Archive, "archive", "", "store this run's PDFs and manifest in one .zip, .tar, .tar.gz or .tgz file instead of the output directory")                             // Archive flag
+	flag.Func("on-download", `command run on every saved PDF, such as "clamscan {{.Path}}", using .Path .URL .Filename .Size and .SHA256 (no shell)`, func(value string) error {
+		args, err := parseHookCommand(value)
+		if err != nil {
+			return err
+		}
+		config.OnDownload = args
+		return nil
+	}) // Post-download hook flag
+	flag.BoolVar(&config.OnDownloadDelete, "on-download-delete", false, "delete a saved PDF, and count it as failed, when the -on-download command exits non-zero")                                                                                          // Hook rejection flag
+	flag.IntVar(&config.HookConcurrency, "hook-concurrency", 4, "most -on-download commands running at once")                                                                                                                                                // Hook concurrency flag
+	flag.StringVar(&config.OutDB, "out-db", "", "store PDFs and their metadata in this SQLite database instead of the output directory")                                                                                                                     // Database flag
+	flag.BoolVar(&config.DBBlobs, "db-blobs", true, "with -out-db, store the PDFs in the database; -db-blobs=false keeps them in the output directory and only lists them")                                                                                  // Blob toggle
+	flag.BoolVar(&config.SaveHeaders, "save-headers", false, "save each PDF's response headers and final URL to <filename>.meta.json")                                                                                                                       // Header sidecar flag
+	flag.BoolVar(&config.ResumePartial, "resume-partial", false, "keep interrupted downloads as <filename>.part and resume them with HTTP Range requests")                                                                                                   // Resume flag
+	flag.BoolVar(&config.Force, "force", false, "run even if another instance holds the output directory's lock (both may clobber each other's files)")                                                                                                      // Lock override flag
+	flag.BoolVar(&config.HeadOnly, "head-only", false, "record each PDF's URL, size, content type and Last-Modified in the manifest from a HEAD request, without downloading it")                                                                            // Catalog mode flag
+	flag.BoolVar(&config.DryRun, "dry-run", false, "list the PDFs that would be downloaded and their output paths, then exit")                                                                                                                               // Dry run flag
+	flag.BoolVar(&config.ListOnly, "list-only", false, "crawl and write the discovered PDF URLs, one per line, to stdout or -urls-out, then exit without downloading")                                                                                       // URL list flag
+	flag.StringVar(&config.URLsOut, "urls-out", "", "file -list-only writes the URLs to, replacing it, instead of stdout (usable with -url-file or wget -i)")                                                                                                // URL list file flag
+	flag.StringVar(&config.PauseFile, "pause-file", "", "pause new page fetches and downloads while this file exists, letting running ones finish (SIGUSR1 and SIGUSR2 also pause and resume)")                                                              // Pause control file flag
+	flag.StringVar(&config.EventsJSON, "events-json", "", "write newline-delimited JSON progress events (page_fetched, link_found, download_started, download_complete, download_skipped, download_failed, run_summary) to this file, - for stdout or fd:N") // Event stream flag
+	flag.StringVar(&config.ListFormat, "list-format", "text", "-list-only output: text for bare URLs, or json for one {\"url\", \"filename\"} object per line")                                                                                              // URL list format flag
+	flag.StringVar(&config.Verify, "verify", "", "check the files in the output directory against this manifest instead of downloading; exits non-zero on any difference")                                                                                   // Verify mode flag
+	flag.BoolVar(&config.Trace, "trace", false, "log the DNS, connect, TLS and first-byte timings of every request (shown with -log-level debug)")                                                                                                           // Tracing flag
+	flag.StringVar(&config.MetricsAddr, "metrics-addr", "", "serve Prometheus metrics at http://<addr>/metrics while running, such as :9090")                                                                                                                // Metrics address flag
+	flag.BoolVar(&config.ValidateExisting, "validate-existing", false, "first check the PDFs already in the output directory (signature, -min-bytes) and remove corrupt ones so they are downloaded again")                                                  // Existing file check flag
+	flag.BoolVar(&config.ValidateChecksums, "validate-checksums", false, "with -validate-existing, also compare each file against its manifest checksum")                                                                                                    // Checksum check flag
+	flag.BoolVar(&config.ValidateKeep, "validate-keep", false, "with -validate-existing, only report corrupt files instead of removing them")                                                                                                                // Report-only flag
+	flag.BoolVar(&config.ReportDupes, "report-dupes", false, "list the byte-identical documents in the output directory, with their URLs, instead of downloading")                                                                                           // Duplicate report flag
+	flag.IntVar(&config.Slowest, "slowest", 5, "after the summary, list this many of the slowest and lowest-throughput downloads (0 lists none)")                                                                                                            // Slowest report flag
+	flag.BoolVar(&config.Quiet, "quiet", false, "don't print progress every 10s (it is never printed when stdout isn't a terminal)")                                                                                                                         // Progress toggle
+	flag.StringVar(&config.LogFormat, "log-format", "auto", "log format: auto (text on a terminal, JSON otherwise), text or json")                                                                                                                           // Log format flag
+	flag.StringVar(&config.LogLevel, "log-level", "info", "minimum log level: debug, info, warn or error")                                                                                                                                                   // Log level flag
+	flag.StringVar(&config.LogFile, "log-file", "", "also write logs to this file as JSON lines, rotating it as -log-max-size and -log-rotate-every say")                                                                                                    // Log file flag
+	flag.Int64Var(&config.LogMaxSize, "log-max-size", 100, "rotate the log file before it grows past this many megabytes (0 never rotates on size)")                                                                                                         // Log size rotation flag
+	flag.DurationVar(&config.LogRotateEvery, "log-rotate-every", 0, "rotate the log file once it is this old, such as 24h (0 never rotates on age)")                                                                                                         // Log age rotation flag
+	flag.IntVar(&config.LogKeep, "log-keep", 5, "number of rotated log files to keep")                                                                                                                                                                       // Rotated log count flag
+	configFile := flag.String("config", "", "read settings from this YAML or JSON file, keyed by flag name; flags on the command line override it")                                                                                                          // Config file flag
+	flag.Parse()                                                                                                                                                                                                                                             // Parse command-line flags
+	if *configFile != "" {
+		if err := applyConfigFile(flag.CommandLine, *configFile); err != nil {
+			fmt.Fprintln(flag.CommandLine.Output(), err) // Reported like an invalid flag
+			os.Exit(2)
+		}
+	}
+	return config // Return the populated config
+}
+
+// isTerminal reports whether the file is attached to a terminal
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat() // Get file info
+	if err != nil {
+		return false // Assume not a terminal
+	}
+	return info.Mode()&os.ModeCharDevice != 0 // Terminals are character devices
+}
+
+// setupLogging installs the default slog logger described by format and level, also writing
+// JSON lines to file unless it is nil
+func setupLogging(format string, level string, file io.Writer) error {
+	var logLevel slog.Level
+	if err := logLevel.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("invalid -log-level %q: %w", level, err) // Unknown level name
+	}
+	options := &slog.HandlerOptions{Level: logLevel} // Handler settings
+	if format == "auto" {
+		format = "json" // Machine-readable output for files and pipes
+		if isTerminal(os.Stderr) {
+			format = "text" // Human-readable output on a terminal
+		}
+	}
+	var handler slog.Handler // Console output
+	switch format {
+	case "text":
+		handler = slog.NewTextHandler(os.Stderr, options) // Key=value lines
+	case "json":
+		handler = slog.NewJSONHandler(os.Stderr, options) // JSON lines
+	default:
+		return fmt.Errorf("invalid -log-format %q: want auto, text or json", format) // Unknown format
+	}
+	if file != nil {
+		handler = teeHandler{handler, slog.NewJSONHandler(file, options)} // Files are always JSON lines
+	}
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+// localFiles reports whether the PDFs end up as files in the output directory
+func (config Config) localFiles() bool {
+	return config.Storage == "" && config.Archive == "" && (config.OutDB == "" || !config.DBBlobs)
+}
+
+// scrapeWorkers returns how many pages the crawl fetches at once
+func (config Config) scrapeWorkers() int {
+	if config.ScrapeWorkers > 0 {
+		return config.ScrapeWorkers
+	}
+	return max(config.Concurrency, 1)
+}
+
+// downloadWorkers returns how many PDFs are downloaded at once
+func (config Config) downloadWorkers() int {
+	if config.ConcurrencyAuto {
+		return max(config.ConcurrencyMax, 1) // Most that may be allowed at once
+	}
+	if config.DownloadWorkers > 0 {
+		return config.DownloadWorkers
+	}
+	return max(config.Concurrency, 1)
+}
+
+// queueSize returns how many links each pipeline stage buffers before the one feeding it waits
+func (config Config) queueSize() int {
+	if config.QueueSize > 0 {
+		return config.QueueSize
+	}
+	return config.downloadWorkers()
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json" // For JSON config files
+	"errors"        // For creating error values
+	"flag"          // For looking up the settings a file sets
+	"fmt"           // For formatted I/O operations
+	"maps"          // For iterating config keys
+	"os"            // For file and system operations
+	"path/filepath" // For the config file's extension
+	"slices"        // For sorting config keys
+	"strconv"       // For formatting numeric values
+	"strings"       // For string manipulation
+	"time"          // For YAML timestamps
+
+	"gopkg.in/yaml.v3" // For YAML config files
+)
+
+// readConfigFile parses the YAML (.yaml or .yml) or JSON (.json) file at path into its
+// top-level keys and values
+func readConfigFile(path string) (map[string]any, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	settings := make(map[string]any)
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		err = json.Unmarshal(content, &settings)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(content, &settings)
+	default:
+		return nil, fmt.Errorf("config file %s: want a .yaml, .yml or .json file", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+	return settings, nil
+}
+
+// configValue formats a value from a config file the way it would be given on the command line
+func configValue(value any) (string, error) {
+	switch typed := value.(type) {
+	case string:
+		return typed, nil
+	case bool:
+		return strconv.FormatBool(typed), nil
+	case int:
+		return strconv.Itoa(typed), nil
+	case float64:
+		return strconv.FormatFloat(typed, 'f', -1, 64), nil // JSON numbers, and YAML ones with a fraction
+	case time.Time:
+		return typed.Format(time.RFC3339Nano), nil // Unquoted YAML dates and timestamps, such as since: 2024-01-01
+	case nil:
+		return "", errors.New("missing value")
+	default:
+		return "", fmt.Errorf("want a string, number or boolean, not %T", value)
+	}
+}
+
+// applyConfigFile sets the flags of flags named by the keys of the config file at path, such as
+// out: PDFs/ or concurrency: 4, leaving those given on the command line as they are; a list
+// value sets a repeatable flag once per item, and unknown keys are an error
+func applyConfigFile(flags *flag.FlagSet, path string) error {
+	settings, err := readConfigFile(path)
+	if err != nil {
+		return err
+	}
+	onCommandLine := make(map[string]bool) // Flags that override the file
+	flags.Visit(func(set *flag.Flag) { onCommandLine[set.Name] = true })
+
+	var unknown []string
+	for _, key := range slices.Sorted(maps.Keys(settings)) {
+		if flags.Lookup(key) == nil || key == "config" {
+			unknown = append(unknown, key) // A config file can't name another one
+			continue
+		}
+		if onCommandLine[key] {
+			continue
+		}
+		values, isList := settings[key].([]any)
+		if !isList {
+			values = []any{settings[key]} // A single value
+		}
+		for _, item := range values {
+			value, err := configValue(item)
+			if err != nil {
+				return fmt.Errorf("config file %s: invalid %s: %w", path, key, err)
+			}
+			if err := flags.Set(key, value); err != nil {
+				return fmt.Errorf("config file %s: invalid %s %q: %w", path, key, value, err)
+			}
+		}
+	}
+	if len(unknown) > 0 {
+		return fmt.Errorf("config file %s: unknown keys %s; keys are flag names without the dash, such as out or concurrency", path, strings.Join(unknown, ", "))
+	}
+	return nil
+}
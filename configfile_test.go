@@ -0,0 +1,120 @@
+package main
+
+import (
+	"flag"          // For test flag sets
+	"os"            // For writing config files
+	"path/filepath" // For config file paths
+	"strings"       // For inspecting errors
+	"testing"       // For the test framework
+	"time"          // For parsed dates
+)
+
+// writeConfigFile writes content to a config file named name in a new temp directory
+func writeConfigFile(t *testing.T, name string, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// testFlagSet returns a flag set with a few flags of each kind, the -since one parsed like the real flag
+func testFlagSet(since *time.Time) (*flag.FlagSet, *string, *int, *bool, *[]string) {
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	out := flags.String("out", "PDFs/", "")
+	concurrency := flags.Int("concurrency", 4, "")
+	robots := flags.Bool("ignore-robots", false, "")
+	var headers []string
+	flags.Func("header", "", func(value string) error { headers = append(headers, value); return nil })
+	flags.Func("since", "", func(value string) error {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			parsed, err = time.Parse(time.DateOnly, value)
+		}
+		*since = parsed
+		return err
+	})
+	flags.String("config", "", "")
+	return flags, out, concurrency, robots, &headers
+}
+
+func TestApplyConfigFileYAML(t *testing.T) {
+	var since time.Time
+	flags, out, concurrency, robots, headers := testFlagSet(&since)
+	path := writeConfigFile(t, "scraper.yaml", "out: mirror/\nconcurrency: 8\nignore-robots: true\nsince: 2024-01-01\nheader:\n  - \"X-A: 1\"\n  - \"X-B: 2\"\n")
+	if err := flags.Parse(nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyConfigFile(flags, path); err != nil {
+		t.Fatalf("applyConfigFile: %v", err)
+	}
+	if *out != "mirror/" || *concurrency != 8 || !*robots {
+		t.Errorf("out %q, concurrency %d, ignore-robots %v", *out, *concurrency, *robots)
+	}
+	if want := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC); !since.Equal(want) {
+		t.Errorf("since = %s, want %s", since, want)
+	}
+	if len(*headers) != 2 || (*headers)[0] != "X-A: 1" || (*headers)[1] != "X-B: 2" {
+		t.Errorf("headers = %q", *headers)
+	}
+}
+
+func TestApplyConfigFileYAMLTimestamp(t *testing.T) {
+	var since time.Time
+	flags, _, _, _, _ := testFlagSet(&since)
+	path := writeConfigFile(t, "scraper.yml", "since: 2024-03-01T08:30:00Z\n")
+	flags.Parse(nil)
+	if err := applyConfigFile(flags, path); err != nil {
+		t.Fatalf("applyConfigFile: %v", err)
+	}
+	if want := time.Date(2024, 3, 1, 8, 30, 0, 0, time.UTC); !since.Equal(want) {
+		t.Errorf("since = %s, want %s", since, want)
+	}
+}
+
+func TestApplyConfigFileCommandLineWins(t *testing.T) {
+	var since time.Time
+	flags, out, concurrency, _, _ := testFlagSet(&since)
+	path := writeConfigFile(t, "scraper.json", `{"out": "from-file/", "concurrency": 16}`)
+	if err := flags.Parse([]string{"-out", "from-flag/"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyConfigFile(flags, path); err != nil {
+		t.Fatalf("applyConfigFile: %v", err)
+	}
+	if *out != "from-flag/" {
+		t.Errorf("out = %q, want the command line's from-flag/", *out)
+	}
+	if *concurrency != 16 {
+		t.Errorf("concurrency = %d, want the file's 16", *concurrency)
+	}
+}
+
+func TestApplyConfigFileErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		want    string
+	}{
+		{"unknown key", "c.yaml", "outt: x\n", "unknown keys outt"},
+		{"nested config", "c.yaml", "config: other.yaml\n", "unknown keys config"},
+		{"bad value", "c.json", `{"concurrency": "many"}`, "invalid concurrency"},
+		{"object value", "c.json", `{"out": {"dir": "x"}}`, "want a string, number or boolean"},
+		{"missing value", "c.yaml", "out:\n", "missing value"},
+		{"unsupported extension", "c.toml", "out = 'x'\n", "want a .yaml, .yml or .json file"},
+		{"malformed", "c.json", `{"out": `, "parsing config file"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var since time.Time
+			flags, _, _, _, _ := testFlagSet(&since)
+			flags.Parse(nil)
+			err := applyConfigFile(flags, writeConfigFile(t, test.file, test.content))
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Fatalf("err = %v, want it to mention %q", err, test.want)
+			}
+		})
+	}
+}
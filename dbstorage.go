@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"         // Provides buffer for reading/writing data
+	"context"       // For cancelling in-flight work
+	"database/sql"  // For the SQLite database
+	"fmt"           // For formatted I/O operations
+	"io"            // For general I/O primitives
+	"net/url"       // For building the connection string
+	"path/filepath" // For manipulating filename paths
+	"sync"          // For handling concurrency
+	"time"          // For time-related operations
+
+	_ "modernc.org/sqlite" // Pure-Go SQLite driver, registered as "sqlite"
+)
+
+// documentsSchema creates the table holding one row per stored document
+const documentsSchema = `CREATE TABLE IF NOT EXISTS documents (
+	filename     TEXT PRIMARY KEY, -- Path relative to the storage root
+	url          TEXT,             -- URL the download was requested from
+	final_url    TEXT,             -- URL after following redirects
+	size         INTEGER,          -- Size in bytes
+	sha256       TEXT,             -- Hex-encoded SHA-256 checksum
+	content_type TEXT,             -- Content-Type the server sent
+	fetched_at   TEXT,             -- RFC 3339 time of the download
+	content      BLOB              -- The document itself, unless it is kept in the output directory
+)`
+
+// documentRecorder is implemented by storages that keep each document's metadata next to it
+type documentRecorder interface {
+	RecordDocument(ctx context.Context, entry ManifestEntry, contentType string) error // Save the metadata of a stored document
+}
+
+// dbStorage keeps documents, or only their metadata, in a SQLite database; every write is a
+// transaction of its own, and writes are serialized
+type dbStorage struct {
+	db    *sql.DB    // Open database
+	mutex sync.Mutex // Serializes writes; SQLite allows one writer at a time
+	files Storage    // Where the content goes when it isn't stored in the database, or nil
+}
+
+// newDBStorage opens, or creates, the database at path; documents are stored in it as blobs
+// unless files is set, in which case the content goes there and the database only lists it
+func newDBStorage(ctx context.Context, path string, files Storage) (*dbStorage, error) {
+	disk := osFS{} // SQLite opens the database itself, so it's always on the real disk
+	if !directoryExists(disk, filepath.Dir(path)) {
+		if err := createDirectory(disk, filepath.Dir(path), 0o755); err != nil {
+			return nil, err
+		}
+	}
+	dsn := "file:" + (&url.URL{Path: path}).EscapedPath() + "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1) // One connection, so writes never contend
+	if _, err := db.ExecContext(ctx, documentsSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("creating schema in %s: %w", path, err)
+	}
+	return &dbStorage{db: db, files: files}, nil
+}
+
+// Exists reports whether the database has a row for name, with its content stored
+func (storage *dbStorage) Exists(name string) bool {
+	var hasContent bool
+	err := storage.db.QueryRow(`SELECT content IS NOT NULL FROM documents WHERE filename = ?`, filepath.ToSlash(name)).Scan(&hasContent)
+	if err != nil {
+		return false // No row, or the database can't tell
+	}
+	if storage.files != nil {
+		return storage.files.Exists(name) // Listed, and the file is still there
+	}
+	return hasContent
+}
+
+// Write stores reader's content under name, as a blob or in files
+func (storage *dbStorage) Write(ctx context.Context, name string, reader io.Reader) error {
+	var content []byte // NULL when the content lives in files
+	if storage.files != nil {
+		if err := storage.files.Write(ctx, name, reader); err != nil {
+			return err
+		}
+	} else {
+		var buffer bytes.Buffer
+		if _, err := io.Copy(&buffer, reader); err != nil {
+			return err
+		}
+		content = buffer.Bytes()
+	}
+	return storage.transaction(ctx, `INSERT INTO documents (filename, content) VALUES (?, ?)
+		ON CONFLICT (filename) DO UPDATE SET content = excluded.content`, filepath.ToSlash(name), content)
+}
+
+// RecordDocument saves the metadata of the document stored as entry.Filename
+func (storage *dbStorage) RecordDocument(ctx context.Context, entry ManifestEntry, contentType string) error {
+	return storage.transaction(ctx, `INSERT INTO documents (filename, url, final_url, size, sha256, content_type, fetched_at) VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (filename) DO UPDATE SET url = excluded.url, final_url = excluded.final_url, size = excluded.size,
+			sha256 = excluded.sha256, content_type = excluded.content_type, fetched_at = excluded.fetched_at`,
+		filepath.ToSlash(entry.Filename), entry.SourceURL, entry.FinalURL, entry.Size, entry.SHA256, contentType, entry.DownloadedAt.Format(time.RFC3339))
+}
+
+// transaction runs one statement in a transaction of its own, after any other write finished
+func (storage *dbStorage) transaction(ctx context.Context, statement string, args ...any) error {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+	tx, err := storage.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, statement, args...); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// Close closes the database
+func (storage *dbStorage) Close() error {
+	return storage.db.Close()
+}
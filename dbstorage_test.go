@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"       // For storage contexts
+	"fmt"           // For generated names
+	"os"            // For deleting a listed file
+	"path/filepath" // For database paths
+	"strings"       // For document contents
+	"sync"          // For concurrent writes
+	"testing"       // For the test framework
+	"time"          // For download times
+)
+
+// openTestDB opens the database at path until the test ends, its content kept in files when set
+func openTestDB(t *testing.T, path string, files Storage) *dbStorage {
+	t.Helper()
+	storage, err := newDBStorage(context.Background(), path, files)
+	if err != nil {
+		t.Fatalf("newDBStorage: %v", err)
+	}
+	t.Cleanup(func() { storage.Close() })
+	return storage
+}
+
+func TestDBStorageBlobs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db", "sds.db")
+	storage := openTestDB(t, path, nil)
+	if storage.Exists("ab/doc.pdf") {
+		t.Fatal("a document exists in an empty database")
+	}
+	if err := storage.Write(context.Background(), "ab/doc.pdf", strings.NewReader("%PDF-1.7 one")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	entry := testEntry("ab/doc.pdf", "https://www.airgas.com/msds/doc.pdf")
+	if err := storage.RecordDocument(context.Background(), entry, "application/pdf"); err != nil {
+		t.Fatalf("RecordDocument: %v", err)
+	}
+	if err := storage.Write(context.Background(), "ab/doc.pdf", strings.NewReader("%PDF-1.7 two")); err != nil {
+		t.Fatalf("rewriting: %v", err)
+	}
+	if !storage.Exists("ab/doc.pdf") {
+		t.Error("the written document doesn't exist")
+	}
+	storage.Close()
+
+	reopened := openTestDB(t, path, nil)
+	var content []byte
+	var url, sha, contentType, fetchedAt string
+	var size int64
+	err := reopened.db.QueryRow(`SELECT content, url, size, sha256, content_type, fetched_at FROM documents WHERE filename = ?`, "ab/doc.pdf").Scan(&content, &url, &size, &sha, &contentType, &fetchedAt)
+	if err != nil {
+		t.Fatalf("reading the row back: %v", err)
+	}
+	if string(content) != "%PDF-1.7 two" {
+		t.Errorf("content = %q, want the rewritten document", content)
+	}
+	if url != entry.SourceURL || size != entry.Size || sha != entry.SHA256 || contentType != "application/pdf" || fetchedAt != entry.DownloadedAt.Format(time.RFC3339) {
+		t.Errorf("metadata = %s %d %s %s %s, want %+v", url, size, sha, contentType, fetchedAt, entry)
+	}
+	if !reopened.Exists("ab/doc.pdf") {
+		t.Error("the document doesn't exist after reopening")
+	}
+}
+
+func TestDBStorageMetadataOnly(t *testing.T) {
+	dir := t.TempDir()
+	storage := openTestDB(t, filepath.Join(dir, "sds.db"), &localStorage{fs: osFS{}, dir: filepath.Join(dir, "PDFs")})
+	if err := storage.Write(context.Background(), "doc.pdf", strings.NewReader("%PDF-1.7")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if got, err := os.ReadFile(filepath.Join(dir, "PDFs", "doc.pdf")); err != nil || string(got) != "%PDF-1.7" {
+		t.Errorf("stored file = %q, %v", got, err)
+	}
+	var hasContent bool
+	storage.db.QueryRow(`SELECT content IS NOT NULL FROM documents WHERE filename = ?`, "doc.pdf").Scan(&hasContent)
+	if hasContent || !storage.Exists("doc.pdf") {
+		t.Errorf("blob stored %v, Exists %v; want only the listing", hasContent, storage.Exists("doc.pdf"))
+	}
+	os.Remove(filepath.Join(dir, "PDFs", "doc.pdf"))
+	if storage.Exists("doc.pdf") {
+		t.Error("a listed document whose file is gone exists")
+	}
+}
+
+func TestDBStorageConcurrentWrites(t *testing.T) {
+	storage := openTestDB(t, filepath.Join(t.TempDir(), "sds.db"), nil)
+	var writers sync.WaitGroup
+	for number := range 20 {
+		writers.Add(1)
+		go func() {
+			defer writers.Done()
+			name := fmt.Sprintf("%d.pdf", number)
+			if err := storage.Write(context.Background(), name, strings.NewReader("%PDF-"+name)); err != nil {
+				t.Errorf("Write(%s): %v", name, err)
+			}
+			if err := storage.RecordDocument(context.Background(), testEntry(name, "https://www.airgas.com/msds/"+name), "application/pdf"); err != nil {
+				t.Errorf("RecordDocument(%s): %v", name, err)
+			}
+		}()
+	}
+	writers.Wait()
+	var rows int
+	storage.db.QueryRow(`SELECT COUNT(*) FROM documents WHERE content IS NOT NULL AND sha256 IS NOT NULL`).Scan(&rows)
+	if rows != 20 {
+		t.Errorf("%d complete rows, want 20", rows)
+	}
+}
@@ -0,0 +1,601 @@
+package main
+
+import (
+	"bufio"         // For peeking at response bodies
+	"bytes"         // Provides buffer for reading/writing data
+	"compress/gzip" // For PDFs served gzip-compressed without saying so
+	"context"       // For cancelling in-flight work
+	"crypto/sha256" // For checksumming downloaded files
+	"encoding/hex"  // For encoding checksums as text
+	"encoding/json" // For writing header sidecars
+	"errors"        // For combining error values
+	"fmt"           // For formatted I/O operations
+	"io"            // For general I/O primitives
+	"io/fs"         // For file error values
+	"log/slog"      // For structured, levelled logging
+	"mime"          // For extensions of content types
+	"net/http"      // For making HTTP requests
+	"path/filepath" // For manipulating filename paths
+	"strconv"       // For quoting header values
+	"strings"       // For string manipulation
+	"time"          // For time-related operations
+)
+
+// errRetryDownload is returned by downloadAttempt when the download should be tried again
+var errRetryDownload = errors.New("retry download")
+
+// errSizeMismatch means a download's size differs from the size its response declared
+var errSizeMismatch = errors.New("size doesn't match Content-Length")
+
+// tempFileSuffix marks in-progress downloads; such files are never mistaken for finished PDFs
+const tempFileSuffix = ".tmp"
+
+// writeTempFile streams reader into a new temp file in dir and syncs it to disk, returning its
+// path, size and hex SHA-256; on any error (including cancellation) the temp file is removed
+func writeTempFile(ctx context.Context, fsys FS, dir string, filename string, reader io.Reader) (string, int64, string, error) {
+	tempFile, err := fsys.CreateTemp(dir, filename+".*"+tempFileSuffix) // Temp file next to the target for an atomic rename
+	if err != nil {
+		return "", 0, "", err
+	}
+	hasher := sha256.New()                                            // Hash the content while it streams
+	written, err := io.Copy(io.MultiWriter(tempFile, hasher), reader) // Stream data to disk
+	if err == nil {
+		err = tempFile.Sync() // Make sure the bytes are on disk before the rename publishes them
+	}
+	err = errors.Join(err, tempFile.Close(), ctx.Err()) // Any failure, including cancellation, aborts the write
+	if err != nil {
+		discardFile(fsys, tempFile.Name()) // Never leave a partial download behind
+		return "", 0, "", err
+	}
+	return tempFile.Name(), written, hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// removeStaleTempFiles deletes temp files left in dir, or its shard subdirectories, by a run that
+// was killed mid-download
+func removeStaleTempFiles(fsys FS, dir string) {
+	var matches []string
+	for _, pattern := range []string{"*" + tempFileSuffix, filepath.Join("*", "*"+tempFileSuffix)} {
+		found, err := fsys.Glob(filepath.Join(dir, pattern)) // In-progress downloads
+		if err != nil {
+			slog.Warn("Failed to list temp files", "path", dir, "error", err)
+			return
+		}
+		matches = append(matches, found...)
+	}
+	for _, match := range matches {
+		slog.Info("Removing stale temp file", "path", match)
+		discardFile(fsys, match) // Leftover from an interrupted run
+	}
+}
+
+// sizeCap returns the largest PDF that may be downloaded now: -max-file-size or what is left of
+// the -max-bytes budget, whichever is smaller (0 means no cap)
+func (scraper *Scraper) sizeCap() int64 {
+	limit := scraper.config.MaxFileSize // Per-file cap
+	if budget := scraper.config.MaxBytes; budget > 0 {
+		remaining := max(budget-scraper.stats.Bytes.Load(), 1) // Bytes left in the run's budget
+		if limit <= 0 || remaining < limit {
+			limit = remaining
+		}
+	}
+	return limit
+}
+
+// tooLarge reports whether a response announces a body bigger than the size cap
+func (scraper *Scraper) tooLarge(resp *http.Response) bool {
+	limit := scraper.sizeCap()
+	return limit > 0 && resp.ContentLength > limit // Unknown lengths (-1) pass
+}
+
+// precheck sends a HEAD request for uri and reports whether the PDF is worth a GET; when the
+// server can't answer HEAD the download goes ahead so the GET can decide
+func (scraper *Scraper) precheck(ctx context.Context, uri string) bool {
+	resp, err := httpDoWithRetry(ctx, scraper.client, scraper.limiter, http.MethodHead, uri, nil, nil, scraper.config.RequestTimeout, scraper.config) // Send HTTP HEAD
+	if err != nil {
+		slog.Debug("HEAD pre-check failed, falling back to GET", "url", uri, "error", err)
+		return true
+	}
+	resp.Body.Close() // HEAD responses have no body
+	if resp.StatusCode != http.StatusOK {
+		slog.Debug("HEAD pre-check not supported, falling back to GET", "url", uri, "status", resp.StatusCode)
+		return true // Many servers answer HEAD with 405 or 501
+	}
+	if scraper.tooLarge(resp) {
+		slog.Info("PDF exceeds the size cap, skipping", "url", uri, "size", resp.ContentLength, "cap", scraper.sizeCap())
+		scraper.stats.SkippedTooLarge.Add(1)
+		return false
+	}
+	if contentType := resp.Header.Get("Content-Type"); contentType != "" && !strings.Contains(contentType, "application/pdf") {
+		slog.Debug("HEAD pre-check content type isn't application/pdf, letting the GET check the body", "url", uri, "content_type", contentType)
+	}
+	return true // Some servers label real PDFs application/octet-stream or text/html, so only the body can tell
+}
+
+// downloadSkipped reports a download that was started but not kept, for a reason such as
+// not_modified or duplicate, to the -events-json stream, and returns its result
+func (scraper *Scraper) downloadSkipped(uri string, filename string, status int, reason string) DownloadResult {
+	scraper.events.emit(Event{Event: eventDownloadSkipped, URL: uri, Filename: filename, Status: status, Reason: reason})
+	return DownloadResult{Outcome: outcomeSkipped, Filename: filename, Status: status, Reason: reason}
+}
+
+// failuresFileName is the default dead-letter file, kept in the output directory
+const failuresFileName = "failures.txt"
+
+// downloadFailed counts a download that didn't complete and, unless the run was cancelled,
+// appends the URL to the failures file: a "# time status=... error=..." comment followed by the
+// URL, so the file can be passed straight back to -url-file; it returns the error the download
+// ends with
+func (scraper *Scraper) downloadFailed(ctx context.Context, uri string, status int, reason string) error {
+	scraper.stats.downloadFailed(ctx)
+	scraper.events.emit(Event{Event: eventDownloadFailed, URL: uri, Status: status, Error: reason, Cancelled: ctx.Err() != nil})
+	failure := fmt.Errorf("downloading %s: %s", uri, reason)
+	if ctx.Err() != nil || scraper.config.FailuresFile == "" {
+		return failure // Cancelled downloads aren't permanent failures
+	}
+	entry := fmt.Sprintf("# %s status=%d error=%q\n%s\n", time.Now().UTC().Format(time.RFC3339), status, reason, uri)
+	if err := scraper.checkWriteError(appendByteToFile(scraper.config.fileSystem(), scraper.config.FailuresFile, []byte(entry))); err != nil {
+		slog.Warn("Failed to record failed download", "url", uri, "path", scraper.config.FailuresFile, "error", err)
+	}
+	return failure
+}
+
+// metadataSuffix is appended to a PDF's filename to name its -save-headers sidecar
+const metadataSuffix = ".meta.json"
+
+// PDFMetadata is the provenance sidecar saved next to a PDF with -save-headers
+type PDFMetadata struct {
+	SourceURL     string    `json:"source_url"`               // URL the download was requested from
+	FinalURL      string    `json:"final_url"`                // URL after following redirects
+	Status        int       `json:"status"`                   // HTTP status of the response
+	ContentType   string    `json:"content_type,omitempty"`   // Content-Type response header
+	ContentLength string    `json:"content_length,omitempty"` // Content-Length response header (absent for compressed bodies)
+	LastModified  string    `json:"last_modified,omitempty"`  // Last-Modified response header
+	ETag          string    `json:"etag,omitempty"`           // ETag response header
+	DownloadedAt  time.Time `json:"downloaded_at"`            // When the download finished
+}
+
+// saveMetadata stores the provenance of a downloaded PDF next to it as filename+metadataSuffix
+func (scraper *Scraper) saveMetadata(ctx context.Context, filename string, sourceURL string, resp *http.Response) error {
+	metadata := PDFMetadata{
+		SourceURL:     sourceURL,
+		FinalURL:      resp.Request.URL.String(),
+		Status:        resp.StatusCode,
+		ContentType:   resp.Header.Get("Content-Type"),
+		ContentLength: resp.Header.Get("Content-Length"),
+		LastModified:  resp.Header.Get("Last-Modified"),
+		ETag:          resp.Header.Get("ETag"),
+		DownloadedAt:  time.Now().UTC(),
+	}
+	data, err := json.MarshalIndent(metadata, "", "  ") // Human-readable sidecar
+	if err != nil {
+		return err
+	}
+	return scraper.storage.Write(ctx, filename+metadataSuffix, bytes.NewReader(append(data, '\n')))
+}
+
+// publish moves the finished temp file at tempPath into storage as filename, removing the temp
+// file once its content is stored
+func (scraper *Scraper) publish(ctx context.Context, filename string, tempPath string) error {
+	if adopter, ok := scraper.storage.(fileAdopter); ok {
+		return adopter.Adopt(filename, tempPath) // Local storage: just rename it
+	}
+	fsys := scraper.config.fileSystem()
+	defer discardFile(fsys, tempPath) // The stored copy is the only one kept
+	file, err := fsys.Open(tempPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return scraper.storage.Write(ctx, filename, file) // Upload the staged content
+}
+
+// stagingWriter is the default bodyWriter, which streams each download to a new temp file in dir,
+// or next to the final file when dir is empty, and never resumes one
+type stagingWriter struct {
+	dir string // -temp-dir, which keeps in-progress bytes off the output volume
+}
+
+// prepare asks for the whole document
+func (stagingWriter) prepare(fsys FS, filePath string, header http.Header) int64 {
+	return 0
+}
+
+// write streams body to a temp file, which is removed unless it's complete
+func (writer stagingWriter) write(ctx context.Context, fsys FS, filePath string, filename string, offset int64, state partialState, body io.Reader, expectedSize int64) (string, int64, string, error) {
+	stagingDir := filepath.Dir(filePath) // Same filesystem as the final file, so publishing is a rename
+	if writer.dir != "" {
+		stagingDir = writer.dir
+	}
+	tempPath, written, checksumHex, err := writeTempFile(ctx, fsys, stagingDir, filepath.Base(filename), body) // Stream body to a temp file
+	if err == nil && expectedSize >= 0 && written != expectedSize {
+		discardFile(fsys, tempPath) // Truncated, or padded, even though the transfer looked complete
+		err = fmt.Errorf("%w: downloaded %d bytes, expected %d", errSizeMismatch, written, expectedSize)
+	}
+	return tempPath, written, checksumHex, err
+}
+
+// fetchesInFull reports whether downloading filename would fetch the whole document: it isn't
+// stored yet, or it's stale, rather than skipped as existing or fetched with a conditional GET
+func (scraper *Scraper) fetchesInFull(filename string) bool {
+	if !scraper.storage.Exists(filename) {
+		return true
+	}
+	filePath, err := safeJoin(scraper.config.OutputDir, filename)
+	if err != nil {
+		return true // Left for the download to refuse
+	}
+	previous, known := scraper.recorder.Lookup(filename)
+	return scraper.isStale(filePath, previous, known)
+}
+
+// isStale reports whether the stored copy of a document is older than -refresh-older-than, going
+// by when the manifest says it was downloaded or else by the modification time of the file at
+// filePath; without -refresh-older-than nothing is stale
+func (scraper *Scraper) isStale(filePath string, previous ManifestEntry, known bool) bool {
+	if scraper.config.RefreshOlderThan <= 0 {
+		return false
+	}
+	fetched := previous.DownloadedAt // Time the stored copy was downloaded
+	if !known || fetched.IsZero() {
+		info, err := scraper.config.fileSystem().Stat(filePath)
+		if err != nil {
+			return false // Stored elsewhere without a manifest entry; can't tell its age
+		}
+		fetched = info.ModTime()
+	}
+	return scraper.config.clock().Now().Sub(fetched) > scraper.config.RefreshOlderThan
+}
+
+// downloadPDF downloads a PDF from a URL, saves it as filename to the configured storage and records it in the manifest;
+// a body that arrives empty or shorter than its Content-Length is fetched again, with backoff, up to -retries times
+func (scraper *Scraper) downloadPDF(ctx context.Context, finalURL string, filename string) (DownloadResult, error) {
+	key := canonicalizeURL(finalURL, scraper.config.IgnoreParams) // Same document however the URL is spelled
+	if _, busy := scraper.inFlight.LoadOrStore(key, struct{}{}); busy {
+		slog.Info("Download already in progress, skipping", "url", finalURL)
+		scraper.stats.SkippedDuplicate.Add(1)
+		return DownloadResult{Outcome: outcomeSkipped, Filename: filename, Reason: "in_progress"}, nil // The other goroutine saves it
+	}
+	defer scraper.inFlight.Delete(key)   // Allow later downloads of the URL
+	scraper.stats.InFlight.Add(1)        // Shown by the in-flight gauge
+	defer scraper.stats.InFlight.Add(-1) // However the download ends
+	start := time.Now()                  // Track how long the download takes
+	clock := scraper.config.clock()      // Times the backoff between attempts
+	for attempt := 0; ; attempt++ {
+		result, err := scraper.downloadAttempt(ctx, finalURL, filename, start, attempt < scraper.config.MaxRetries)
+		if !errors.Is(err, errRetryDownload) {
+			return result, err
+		}
+		delay := retryDelay(attempt, nil, clock.Now())
+		slog.Warn("Retrying download", "url", finalURL, "attempt", attempt+1, "max_retries", scraper.config.MaxRetries, "delay", delay)
+		select {
+		case <-ctx.Done():
+			return DownloadResult{}, scraper.downloadFailed(ctx, finalURL, 0, ctx.Err().Error()) // Run cancelled while waiting
+		case <-clock.After(delay): // Wait before retrying
+		}
+	}
+}
+
+// downloadAttempt makes one attempt at downloadPDF, timed from start; when canRetry is set, a
+// body that arrives empty or cut short isn't counted as a failure, and errRetryDownload is
+// returned so the download is tried again
+func (scraper *Scraper) downloadAttempt(ctx context.Context, finalURL string, filename string, start time.Time, canRetry bool) (DownloadResult, error) {
+	filePath, err := safeJoin(scraper.config.OutputDir, filename) // Combine with output directory
+	if err != nil {
+		slog.Error("Refusing to save outside the output directory", "url", finalURL, "path", filename, "error", err)
+		return DownloadResult{}, scraper.downloadFailed(ctx, finalURL, 0, err.Error())
+	}
+
+	header := make(http.Header) // Conditional request headers, if any
+	previous, known := scraper.recorder.Lookup(filename)
+	refreshing := scraper.storage.Exists(filename) && scraper.isStale(filePath, previous, known) // Fetched in full whatever its validators say
+	if refreshing {
+		slog.Info("File is older than -refresh-older-than, downloading again", "url", finalURL, "path", filePath)
+	} else if scraper.storage.Exists(filename) {
+		if !known || (previous.ETag == "" && previous.LastModified == "") {
+			slog.Info("File already exists, skipping", "url", finalURL, "path", filePath)
+			scraper.stats.SkippedExisting.Add(1)
+			return DownloadResult{Outcome: outcomeSkipped, Filename: filename, Reason: "exists"}, nil // No validators to ask the server whether it changed
+		}
+		if previous.ETag != "" {
+			header.Set("If-None-Match", previous.ETag) // Ask for the body only if the ETag changed
+		}
+		if previous.LastModified != "" {
+			header.Set("If-Modified-Since", previous.LastModified) // Ask for the body only if modified since
+		}
+	}
+
+	fsys := scraper.config.fileSystem()
+	writer := bodyWriterFrom(ctx, stagingWriter{dir: scraper.config.TempDir}) // A .part file under resumeDownloader
+	offset := writer.prepare(fsys, filePath, header)                          // Bytes kept from an interrupted download
+
+	scraper.events.emit(Event{Event: eventDownloadStarted, URL: finalURL, Filename: filename})
+	requestCtx, sentAt := withSendTime(ctx, start)                                                                                               // Times the download from the request, not the limiter queue
+	resp, err := httpGetWithRetry(requestCtx, scraper.client, scraper.limiter, finalURL, header, scraper.config.DownloadTimeout, scraper.config) // Send HTTP GET
+	if err == nil && offset > 0 && resp.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+		resp.Body.Close()
+		slog.Info("Partial download can't be resumed, downloading in full", "url", finalURL, "offset", offset)
+		discardPartial(fsys, filePath)
+		offset = 0
+		header.Del("Range")
+		header.Del("If-Range")
+		resp, err = httpGetWithRetry(requestCtx, scraper.client, scraper.limiter, finalURL, header, scraper.config.DownloadTimeout, scraper.config) // Start over
+	}
+	if errors.Is(err, errOffSiteRedirect) {
+		scraper.stats.SkippedOffSite.Add(1) // Already logged by checkRedirect
+		return scraper.downloadSkipped(finalURL, filename, 0, "off_site"), nil
+	}
+	if err != nil {
+		slog.Error("Failed to download", "url", finalURL, "error", err)
+		return DownloadResult{}, scraper.downloadFailed(ctx, finalURL, 0, err.Error())
+	}
+	defer resp.Body.Close() // Ensure response body is closed
+
+	if resp.StatusCode == http.StatusNotModified {
+		slog.Info("File not modified on server, skipping", "url", finalURL, "path", filePath)
+		scraper.stats.SkippedExisting.Add(1)
+		return scraper.downloadSkipped(finalURL, filename, resp.StatusCode, "not_modified"), nil
+	}
+	expectedSize := resp.ContentLength // Size of the complete document (-1 if unknown)
+	if offset > 0 && resp.StatusCode == http.StatusPartialContent {
+		start, total, ok := contentRangeStart(resp.Header.Get("Content-Range"))
+		if !ok || start != offset {
+			slog.Error("Unexpected Content-Range, discarding partial download", "url", finalURL, "content_range", resp.Header.Get("Content-Range"), "offset", offset)
+			discardPartial(fsys, filePath) // The next run starts over
+			return DownloadResult{}, scraper.downloadFailed(ctx, finalURL, resp.StatusCode, "unexpected Content-Range")
+		}
+		slog.Info("Resuming partial download", "url", finalURL, "offset", offset, "size", total)
+		expectedSize = total
+	} else if resp.StatusCode != http.StatusOK {
+		slog.Error("Download failed", "url", finalURL, "status", resp.StatusCode)
+		return DownloadResult{}, scraper.downloadFailed(ctx, finalURL, resp.StatusCode, http.StatusText(resp.StatusCode))
+	} else if offset > 0 {
+		slog.Info("Server sent the whole document, not resuming", "url", finalURL, "offset", offset)
+		offset = 0 // No range support, or the document changed
+	}
+
+	if since := scraper.config.Since; !since.IsZero() {
+		if modified, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil && modified.Before(since) {
+			slog.Info("Document not updated since cutoff, skipping", "url", finalURL, "last_modified", modified, "since", since)
+			scraper.stats.SkippedOld.Add(1)
+			return scraper.downloadSkipped(finalURL, filename, resp.StatusCode, "old"), nil // Closing the body abandons the transfer
+		}
+	}
+
+	if scraper.tooLarge(resp) {
+		slog.Info("PDF exceeds the size cap, skipping", "url", finalURL, "size", resp.ContentLength, "cap", scraper.sizeCap())
+		scraper.stats.SkippedTooLarge.Add(1)
+		return scraper.downloadSkipped(finalURL, filename, resp.StatusCode, "too_large"), nil // Closing the body abandons the transfer
+	}
+	if offset == 0 && expectedSize == 0 && resp.StatusCode == http.StatusOK {
+		slog.Warn("Server sent an empty PDF", "url", finalURL, "content_length", expectedSize, "received", 0)
+		if canRetry {
+			return DownloadResult{}, errRetryDownload // Usually a hiccup that the next request doesn't have
+		}
+		return DownloadResult{}, scraper.downloadFailed(ctx, finalURL, resp.StatusCode, "empty body")
+	}
+	if expectedSize >= 0 && expectedSize < scraper.config.MinBytes {
+		slog.Warn("PDF is suspiciously small, not downloading it", "url", finalURL, "size", expectedSize, "min_bytes", scraper.config.MinBytes)
+		return DownloadResult{}, scraper.downloadFailed(ctx, finalURL, resp.StatusCode, fmt.Sprintf("only %d bytes", expectedSize)) // Most likely an error page served as a PDF
+	}
+
+	contentType := resp.Header.Get("Content-Type")                 // Get content-type header
+	labeledPDF := strings.Contains(contentType, "application/pdf") // Checked against the body below; some servers get it wrong
+	if !labeledPDF && offset > 0 {
+		slog.Warn("Invalid content type, expected application/pdf", "url", finalURL, "content_type", contentType)
+		return DownloadResult{}, scraper.downloadFailed(ctx, finalURL, resp.StatusCode, "invalid content type "+strconv.Quote(contentType)) // A resumed body starts mid-file, so there's no signature to go by
+	}
+
+	reader := bufio.NewReader(resp.Body) // Buffered reader so the header can be inspected first
+	head, _ := reader.Peek(sniffLen)     // Look at the first bytes without consuming them; a resumed body starts mid-file
+	if offset == 0 && len(head) == 0 {
+		slog.Warn("Downloaded 0 bytes, not creating file", "url", finalURL, "content_length", expectedSize, "received", 0)
+		if canRetry && ctx.Err() == nil {
+			return DownloadResult{}, errRetryDownload // Usually a hiccup that the next request doesn't have
+		}
+		return DownloadResult{}, scraper.downloadFailed(ctx, finalURL, resp.StatusCode, "empty body")
+	}
+	compressed := offset == 0 && !looksLikePDF(head) && bytes.HasPrefix(head, gzipMagic) // Gzip sent without Content-Encoding, or encoded twice
+	if compressed {
+		slog.Info("PDF body is gzip-compressed without saying so, decompressing", "url", finalURL)
+		decompressor, err := gzip.NewReader(reader)
+		if err != nil {
+			slog.Warn("Body looked gzip-compressed but can't be decompressed, not creating file", "url", finalURL, "error", err)
+			return DownloadResult{}, scraper.downloadFailed(ctx, finalURL, resp.StatusCode, "corrupt gzip body: "+err.Error())
+		}
+		defer decompressor.Close()
+		reader = bufio.NewReader(decompressor)
+		head, _ = reader.Peek(sniffLen) // The decompressed document must be a PDF too
+		expectedSize = -1               // Content-Length counted the compressed bytes
+	}
+	extension := contentExtension(contentType, head, offset) // Sniffed before judging the body, so other documents keep an honest name
+	if offset == 0 && !looksLikePDF(head) {
+		sniffed, _, _ := mime.ParseMediaType(http.DetectContentType(head)) // What the body is, whatever the server says
+		if compressed || labeledPDF || errorPageTypes[sniffed] {
+			slog.Warn("Body is not a PDF (missing %PDF- header), not creating file", "url", finalURL, "content_type", contentType, "sniffed", sniffed)
+			return DownloadResult{}, scraper.downloadFailed(ctx, finalURL, resp.StatusCode, "missing %PDF- header") // An error page, or a PDF that got mangled
+		}
+		slog.Info("Body is not a PDF, keeping it under its own type", "url", finalURL, "content_type", contentType, "extension", extension)
+	} else if !labeledPDF {
+		slog.Info("Server sent a misleading content type, but the body is a PDF", "url", finalURL, "content_type", contentType)
+	}
+
+	fileDir := filepath.Dir(filePath) // Staging directory next to the final file, if stored locally
+	if err := scraper.checkWriteError(createDirectory(fsys, fileDir, 0o755)); err != nil && !errors.Is(err, fs.ErrExist) {
+		slog.Error("Failed to create shard directory", "url", finalURL, "path", fileDir, "error", err)
+		return DownloadResult{}, scraper.downloadFailed(ctx, finalURL, resp.StatusCode, err.Error())
+	}
+	var tempPath, checksumHex string
+	var written int64
+	received := &byteCounter{reader: reader} // Bytes of the body read, for logging a short one
+	state := partialState{ETag: resp.Header.Get("ETag"), LastModified: resp.Header.Get("Last-Modified")}
+	if compressed {
+		state = partialState{} // Byte ranges of the compressed body can't continue the decompressed file
+	}
+	tempPath, written, checksumHex, err = writer.write(ctx, fsys, filePath, filename, offset, state, received, expectedSize) // Stream body to a file to publish
+	elapsed := time.Since(sentAt())                                                                                          // Request, retries and transfer, without the checks and hooks that follow
+	if (errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, errSizeMismatch)) && ctx.Err() == nil {
+		scraper.stats.SizeMismatches.Add(1)
+		slog.Warn("Downloaded size doesn't match the declared size", "url", finalURL, "content_length", expectedSize, "received", offset+received.count, "error", err)
+		if canRetry {
+			return DownloadResult{}, errRetryDownload // A -resume-partial .part file is resumed by the next attempt
+		}
+	}
+	if scraper.checkWriteError(err) != nil {
+		slog.Error("Failed to write PDF to file", "url", finalURL, "path", filePath, "error", err)
+		return DownloadResult{}, scraper.downloadFailed(ctx, finalURL, resp.StatusCode, err.Error())
+	}
+	if written < scraper.config.MinBytes {
+		slog.Warn("PDF is suspiciously small, not keeping it", "url", finalURL, "bytes", written, "min_bytes", scraper.config.MinBytes)
+		discardFile(fsys, tempPath) // Most likely an error page served as a PDF
+		return DownloadResult{}, scraper.downloadFailed(ctx, finalURL, resp.StatusCode, fmt.Sprintf("only %d bytes", written))
+	}
+	if extension != getFileExtension(filename) {
+		corrected := scraper.names.withExtension(finalURL, filename, extension) // Such as .pdf for a PDF served by getsds.aspx
+		slog.Info("Correcting file extension to match content", "url", finalURL, "path", filename, "corrected", corrected)
+		filename = corrected
+		if filePath, err = safeJoin(scraper.config.OutputDir, filename); err != nil {
+			discardFile(fsys, tempPath)
+			return DownloadResult{}, scraper.downloadFailed(ctx, finalURL, resp.StatusCode, err.Error())
+		}
+	}
+
+	if existing, duplicate := scraper.recorder.ClaimContent(checksumHex, filename); duplicate && existing == filename {
+		slog.Info("Server reported a change but content is identical, skipping", "url", finalURL, "path", filePath)
+		discardFile(fsys, tempPath) // Keep the file already on disk
+		if refreshing && known {
+			previous.DownloadedAt = time.Now().UTC() // Checked now, so not stale again until the threshold passes
+			previous.ETag, previous.LastModified = resp.Header.Get("ETag"), resp.Header.Get("Last-Modified")
+			scraper.recorder.Record(previous)
+		}
+		scraper.stats.SkippedExisting.Add(1)
+		return scraper.downloadSkipped(finalURL, filename, resp.StatusCode, "unchanged"), nil
+	} else if duplicate {
+		slog.Info("Content identical to an existing file, skipping", "url", finalURL, "existing", existing)
+		discardFile(fsys, tempPath)                          // Don't keep a second copy
+		scraper.recorder.AddAlternateURL(existing, finalURL) // Note where else this document lives
+		scraper.stats.SkippedDuplicate.Add(1)
+		return scraper.downloadSkipped(finalURL, filename, resp.StatusCode, "duplicate"), nil
+	}
+
+	if err := scraper.checkWriteError(scraper.publish(ctx, filename, tempPath)); err != nil { // Publish the finished file under its final name
+		slog.Error("Failed to store PDF", "url", finalURL, "path", filename, "error", err)
+		scraper.recorder.ReleaseContent(checksumHex) // Nothing was kept for this content
+		return DownloadResult{}, scraper.downloadFailed(ctx, finalURL, resp.StatusCode, err.Error())
+	}
+
+	if len(scraper.config.OnDownload) > 0 {
+		hookErr := scraper.runHook(ctx, HookFields{Path: filePath, URL: finalURL, Filename: filename, Size: written, SHA256: checksumHex})
+		if hookErr != nil && scraper.config.OnDownloadDelete {
+			slog.Warn("Deleting PDF rejected by the download hook", "url", finalURL, "path", filePath)
+			discardFile(fsys, filePath)
+			scraper.recorder.ReleaseContent(checksumHex) // Nothing is kept for this content
+			return DownloadResult{}, scraper.downloadFailed(ctx, finalURL, resp.StatusCode, "rejected by -on-download: "+hookErr.Error())
+		}
+	}
+
+	entry := ManifestEntry{
+		SourceURL:    finalURL,
+		FinalURL:     resp.Request.URL.String(),
+		Filename:     filename,
+		Size:         written,
+		SHA256:       checksumHex,
+		DownloadedAt: time.Now().UTC(),
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		DurationMS:   elapsed.Milliseconds(),
+	}
+	if expectedSize >= 0 {
+		entry.ContentLength = expectedSize // Matches Size, or the download would have failed
+	}
+	scraper.recorder.Record(entry)
+	if documents, ok := scraper.storage.(documentRecorder); ok {
+		if err := scraper.checkWriteError(documents.RecordDocument(ctx, entry, contentType)); err != nil {
+			slog.Warn("Failed to record document metadata", "url", finalURL, "path", filename, "error", err)
+		}
+	}
+	if known && previous.SHA256 != checksumHex {
+		scraper.recorder.ReleaseContent(previous.SHA256) // The old content is no longer on disk
+	}
+	if scraper.config.SaveHeaders {
+		if err := scraper.checkWriteError(scraper.saveMetadata(ctx, filename, finalURL, resp)); err != nil {
+			slog.Warn("Failed to save response headers", "url", finalURL, "path", filename+metadataSuffix, "error", err)
+		}
+	}
+	scraper.stats.Downloaded.Add(1)           // Count the saved PDF
+	scraper.stats.Bytes.Add(written - offset) // Add what this run transferred to the total size
+	scraper.stats.recordTiming(DownloadTiming{URL: finalURL, Bytes: written - offset, Duration: elapsed})
+	scraper.events.emit(Event{Event: eventDownloadComplete, URL: finalURL, Filename: filename, Status: resp.StatusCode, Bytes: written, SHA256: checksumHex, DurationMS: elapsed.Milliseconds()})
+	slog.Info("Downloaded PDF", "url", finalURL, "path", filePath, "status", resp.StatusCode, "bytes", written, "duration", elapsed)
+	return DownloadResult{Outcome: outcomeDownloaded, Filename: filename, Status: resp.StatusCode, Bytes: written, SHA256: checksumHex}, nil
+}
+
+// byteCounter counts the bytes read through it
+type byteCounter struct {
+	reader io.Reader
+	count  int64
+}
+
+// Read reads from the underlying reader, counting what it returns
+func (counter *byteCounter) Read(p []byte) (int, error) {
+	n, err := counter.reader.Read(p)
+	counter.count += int64(n)
+	return n, err
+}
+
+// preferredExtensions are the usual extensions of common types, where the system's MIME table
+// lists several
+var preferredExtensions = map[string]string{
+	"text/html":        ".html",
+	"text/plain":       ".txt",
+	"text/xml":         ".xml",
+	"application/xml":  ".xml",
+	"application/json": ".json",
+	"image/jpeg":       ".jpg",
+}
+
+// contentExtension returns the extension matching a downloaded body: .pdf when it starts with
+// the PDF signature (or resumes a partial that did, when offset is past the start), otherwise
+// the first extension registered for the type sniffed from head or, failing that, its
+// Content-Type, and .bin when neither is known
+func contentExtension(contentType string, head []byte, offset int64) string {
+	if offset > 0 || looksLikePDF(head) {
+		return ".pdf"
+	}
+	for _, candidate := range []string{http.DetectContentType(head), contentType} {
+		mediaType, _, err := mime.ParseMediaType(candidate)
+		if err != nil || mediaType == "application/octet-stream" {
+			continue // Unrecognised, so the other candidate may know better
+		}
+		if extension, ok := preferredExtensions[mediaType]; ok {
+			return extension
+		}
+		if extensions, _ := mime.ExtensionsByType(mediaType); len(extensions) > 0 {
+			return extensions[0] // Sorted, so not always the usual one
+		}
+	}
+	return ".bin"
+}
+
+// sniffLen is how much of a body is peeked at to tell what it is, as much as
+// http.DetectContentType considers
+const sniffLen = 512
+
+// errorPageTypes are the sniffed types of bodies that are error or login pages rather than
+// documents; other bodies that aren't PDFs are kept under their own extension
+var errorPageTypes = map[string]bool{
+	"text/html":       true,
+	"text/xml":        true,
+	"text/plain":      true, // Plain-text errors, and JSON, which isn't sniffed separately
+	"application/xml": true,
+}
+
+// pdfMagic is the signature every PDF file starts with
+var pdfMagic = []byte("%PDF-")
+
+// gzipMagic is the signature that starts every gzip stream
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// looksLikePDF reports whether data starts with the PDF magic signature
+func looksLikePDF(data []byte) bool {
+	return bytes.HasPrefix(data, pdfMagic) // Real PDFs begin with "%PDF-"
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"bytes"             // For comparing stored bodies
+	"context"           // For download contexts
+	"errors"            // For simulated read errors
+	"net/http"          // For test handlers
+	"net/http/httptest" // For test servers
+	"os"                // For the output directory
+	"path/filepath"     // For output paths
+	"strings"           // For the test document
+	"sync"              // For concurrent downloads
+	"sync/atomic"       // For counting requests
+	"testing"           // For the test framework
+	"text/template"     // For the default name template
+	"time"              // For timeouts
+)
+
+// testConfig returns the settings of a download run into a new temp directory
+func testConfig(t *testing.T) Config {
+	return Config{
+		OutputDir:       t.TempDir(),
+		UserAgent:       "test",
+		RequestTimeout:  time.Minute,
+		DownloadTimeout: time.Minute,
+		IgnoreParams:    trackingParams,
+		QueryNaming:     QueryNaming{Mode: "keep"},
+		NameTemplate:    template.Must(parseNameTemplate(defaultNameTemplate)),
+	}
+}
+
+// newTestScraper returns a scraper that stores its downloads in config.OutputDir, as main sets one up
+func newTestScraper(t *testing.T, config Config, client *http.Client) *Scraper {
+	t.Helper()
+	scraper := newScraper(config, client, func(err error) { t.Errorf("run aborted: %v", err) })
+	scraper.storage = &localStorage{fs: config.fileSystem(), dir: config.OutputDir}
+	recorder, err := newManifestRecorder(config.fileSystem(), "", scraper.storage, config.IgnoreParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scraper.recorder = recorder
+	scraper.names = newNameAssigner(config, nil)
+	return scraper
+}
+
+// serveBody returns a test server answering every request with body as contentType
+func serveBody(t *testing.T, contentType string, body []byte) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("Content-Type", contentType)
+		writer.Write(body)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// pngBody is the start of a PNG image, such as a scanned data sheet
+var pngBody = append([]byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"), make([]byte, 64)...)
+
+func TestDownloadPDFSniffsBody(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        []byte
+		want        string // Stored filename, "" when the body is rejected
+	}{
+		{"pdf", "application/pdf", []byte("%PDF-1.7\n%%EOF\n"), "doc.pdf"},
+		{"pdf with misleading type", "text/html", []byte("%PDF-1.4\n%%EOF\n"), "doc.pdf"},
+		{"html error page", "text/html; charset=utf-8", []byte("<!DOCTYPE html><html><body>Not found</body></html>"), ""},
+		{"html error page labeled as pdf", "application/pdf", []byte("<html><body>Session expired</body></html>"), ""},
+		{"plain-text error", "text/plain", []byte("Service unavailable"), ""},
+		{"png image", "image/png", pngBody, "doc.png"},
+		{"png image without a type", "application/octet-stream", pngBody, "doc.png"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := serveBody(t, test.contentType, test.body)
+			config := testConfig(t)
+			scraper := newTestScraper(t, config, server.Client())
+			result, err := scraper.downloadPDF(context.Background(), server.URL+"/msds/doc.pdf", "doc.pdf")
+			if test.want == "" {
+				if err == nil {
+					t.Fatalf("downloaded %+v, want the body rejected", result)
+				}
+				if names, _ := os.ReadDir(config.OutputDir); len(names) != 0 {
+					t.Fatalf("files left behind: %v", names)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("downloadPDF: %v", err)
+			}
+			if result.Outcome != outcomeDownloaded || result.Filename != test.want {
+				t.Fatalf("result = %+v, want %s downloaded", result, test.want)
+			}
+			if got, _ := os.ReadFile(filepath.Join(config.OutputDir, test.want)); string(got) != string(test.body) {
+				t.Errorf("stored %q, want %q", got, test.body)
+			}
+			if entry, ok := scraper.recorder.Lookup(test.want); !ok || entry.SHA256 != checksumOf(test.body) {
+				t.Errorf("manifest entry = %+v, %v", entry, ok)
+			}
+		})
+	}
+}
+
+func TestContentExtension(t *testing.T) {
+	tests := []struct {
+		contentType string
+		head        string
+		offset      int64
+		want        string
+	}{
+		{"application/pdf", "%PDF-1.7", 0, ".pdf"},
+		{"text/html", "%PDF-1.7", 0, ".pdf"},
+		{"application/pdf", "anything", 1024, ".pdf"}, // A resumed body starts mid-file
+		{"application/octet-stream", string(pngBody), 0, ".png"},
+		{"image/jpeg", "\xff\xd8\xff\xe0\x00\x10JFIF", 0, ".jpg"},
+		{"text/html; charset=utf-8", "<html></html>", 0, ".html"},
+		{"application/octet-stream", "\x00\x01\x02\x03", 0, ".bin"},
+	}
+	for _, test := range tests {
+		if got := contentExtension(test.contentType, []byte(test.head), test.offset); got != test.want {
+			t.Errorf("contentExtension(%q, %q, %d) = %q, want %q", test.contentType, test.head, test.offset, got, test.want)
+		}
+	}
+}
+
+func TestLooksLikePDF(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want bool
+	}{
+		{"minimal pdf", "%PDF-1.4\n1 0 obj\n<<>>\nendobj\ntrailer\n<<>>\n%%EOF\n", true},
+		{"signature only", "%PDF-", true},
+		{"html fragment", "<html><body><h1>404 Not Found</h1></body></html>", false},
+		{"html doctype", "<!DOCTYPE html>\n%PDF-1.4", false},
+		{"leading whitespace", " %PDF-1.4", false},
+		{"lowercase signature", "%pdf-1.4", false},
+		{"truncated signature", "%PDF", false},
+		{"empty", "", false},
+	}
+	for _, test := range tests {
+		if got := looksLikePDF([]byte(test.data)); got != test.want {
+			t.Errorf("%s: looksLikePDF(%q) = %v, want %v", test.name, test.data, got, test.want)
+		}
+	}
+}
+
+func TestWriteTempFileFailureLeavesNoFile(t *testing.T) {
+	dir := t.TempDir()
+	reader := &failingReader{data: []byte("%PDF-1.7\npartial"), err: errors.New("connection reset")}
+	if _, _, _, err := writeTempFile(context.Background(), osFS{}, dir, "doc.pdf", reader); err == nil {
+		t.Fatal("writeTempFile succeeded on a failing body")
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("files left behind: %v", entries)
+	}
+}
+
+func TestDownloadPDFInterruptedBodyKeepsStoredCopy(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("Content-Type", "application/pdf")
+		writer.Header().Set("Content-Length", "1000")
+		writer.Write([]byte("%PDF-1.7\nnew version"))
+		writer.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler) // Cut the transfer short
+	}))
+	defer server.Close()
+	config := testConfig(t)
+	dir := config.OutputDir
+	scraper := newTestScraper(t, config, server.Client())
+	stored := "%PDF-1.7\nold version\n%%EOF\n"
+	os.WriteFile(filepath.Join(dir, "stored.pdf"), []byte(stored), 0o644)
+	scraper.recorder.Record(ManifestEntry{SourceURL: server.URL + "/stored.pdf", Filename: "stored.pdf", ETag: `"v1"`}) // Validators, so it's fetched again
+
+	for _, filename := range []string{"new.pdf", "stored.pdf"} {
+		scraper.downloadPDF(context.Background(), server.URL+"/"+filename, filename)
+	}
+	if failed := scraper.stats.Failed.Load(); failed != 2 {
+		t.Errorf("Failed = %d, want both downloads", failed)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 || entries[0].Name() != "stored.pdf" {
+		t.Errorf("files = %v, want only the stored copy", entries)
+	}
+	if got, _ := os.ReadFile(filepath.Join(dir, "stored.pdf")); string(got) != stored {
+		t.Errorf("stored copy replaced by %q", got)
+	}
+}
+
+func TestRemoveStaleTempFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.pdf", "a.pdf.123" + tempFileSuffix, "b.pdf.456" + tempFileSuffix} {
+		os.WriteFile(filepath.Join(dir, name), []byte("%PDF-"), 0o644)
+	}
+	removeStaleTempFiles(osFS{}, dir)
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 || entries[0].Name() != "a.pdf" {
+		t.Errorf("files = %v, want only the finished PDF", entries)
+	}
+}
+
+func TestDownloadPDFSkipsDocumentsInFlight(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	var requests atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		requests.Add(1)
+		started <- struct{}{}
+		<-release // Hold the first download open while the same URL is scheduled again
+		writer.Header().Set("Content-Type", "application/pdf")
+		writer.Write([]byte("%PDF-1.7\n%%EOF\n"))
+	}))
+	defer server.Close()
+	config := testConfig(t)
+	scraper := newTestScraper(t, config, server.Client())
+
+	first := make(chan struct{})
+	go func() {
+		scraper.downloadPDF(context.Background(), server.URL+"/doc.pdf", "doc.pdf")
+		close(first)
+	}()
+	<-started
+	var duplicates sync.WaitGroup
+	for _, spelling := range []string{"/doc.pdf", "/doc.pdf?utm_source=mail", "/doc.pdf#top"} {
+		duplicates.Add(1)
+		go func() {
+			defer duplicates.Done()
+			scraper.downloadPDF(context.Background(), server.URL+spelling, "doc.pdf")
+		}()
+	}
+	duplicates.Wait()
+	close(release)
+	<-first
+	if count := requests.Load(); count != 1 {
+		t.Errorf("%d requests, want a single download", count)
+	}
+	if skipped := scraper.stats.SkippedDuplicate.Load(); skipped != 3 {
+		t.Errorf("SkippedDuplicate = %d, want 3", skipped)
+	}
+	if downloaded := scraper.stats.Downloaded.Load(); downloaded != 1 || !fileExists(osFS{}, filepath.Join(config.OutputDir, "doc.pdf")) {
+		t.Errorf("Downloaded = %d, want the document saved once", downloaded)
+	}
+	if _, busy := scraper.inFlight.Load(canonicalizeURL(server.URL+"/doc.pdf", trackingParams)); busy {
+		t.Error("the URL is still marked in flight")
+	}
+}
+
+func TestDownloadPDFDecodesCompressedBodies(t *testing.T) {
+	document := []byte("%PDF-1.7\n" + strings.Repeat("compressible stream\n", 50) + "%%EOF\n")
+	tests := []struct {
+		name            string
+		contentEncoding string // Content-Encoding header sent
+		encoding        string // How the body is really encoded
+	}{
+		{"gzip content encoding", "gzip", "gzip"},
+		{"deflate content encoding", "deflate", "zlib"},
+		{"gzip without a header", "", "gzip"},
+		{"gzip encoded twice", "gzip", "twice"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+				body := encodeBody(t, test.encoding, document)
+				if test.encoding == "twice" {
+					body = encodeBody(t, "gzip", encodeBody(t, "gzip", document))
+				}
+				writer.Header().Set("Content-Type", "application/pdf")
+				if test.contentEncoding != "" {
+					writer.Header().Set("Content-Encoding", test.contentEncoding)
+				}
+				writer.Write(body)
+			}))
+			defer server.Close()
+			config := testConfig(t)
+			scraper := newTestScraper(t, config, newHTTPClient(config))
+			scraper.downloadPDF(context.Background(), server.URL+"/doc.pdf", "doc.pdf")
+			if got, _ := os.ReadFile(filepath.Join(config.OutputDir, "doc.pdf")); !bytes.Equal(got, document) {
+				t.Errorf("stored %d bytes starting %q, want the decoded PDF", len(got), got[:min(len(got), 8)])
+			}
+			if entry, _ := scraper.recorder.Lookup("doc.pdf"); entry.Size != int64(len(document)) || entry.SHA256 != checksumOf(document) {
+				t.Errorf("manifest entry = %+v, want the decoded size and checksum", entry)
+			}
+		})
+	}
+}
+
+func TestDownloadPDFRejectsCorruptGzip(t *testing.T) {
+	server := serveBody(t, "application/pdf", append([]byte{0x1f, 0x8b}, "not really gzip"...))
+	config := testConfig(t)
+	scraper := newTestScraper(t, config, server.Client())
+	scraper.downloadPDF(context.Background(), server.URL+"/doc.pdf", "doc.pdf")
+	if scraper.stats.Failed.Load() != 1 {
+		t.Fatal("a corrupt gzip body was saved")
+	}
+	if names, _ := os.ReadDir(config.OutputDir); len(names) != 0 {
+		t.Errorf("files left behind: %v", names)
+	}
+}
+
+func TestDownloadPDFContentTypeHeaders(t *testing.T) {
+	document := []byte("%PDF-1.4\n%%EOF\n")
+	tests := []struct {
+		contentType string
+		misleading  bool
+	}{
+		{"application/pdf", false},
+		{"application/pdf; charset=binary", false},
+		{"application/octet-stream", true},
+		{"text/html; charset=utf-8", true},
+		{"", true},
+	}
+	for _, test := range tests {
+		t.Run(test.contentType, func(t *testing.T) {
+			logs := captureLogs(t)
+			server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+				writer.Header()["Content-Type"] = []string{test.contentType} // Sent even when empty, so it isn't sniffed
+				writer.Write(document)
+			}))
+			defer server.Close()
+			config := testConfig(t)
+			scraper := newTestScraper(t, config, server.Client())
+			scraper.downloadPDF(context.Background(), server.URL+"/getsds.aspx?id=7", "getsds_id=7.aspx")
+			if got, _ := os.ReadFile(filepath.Join(config.OutputDir, "getsds_id=7.pdf")); !bytes.Equal(got, document) {
+				t.Errorf("stored %q, want the document with its extension corrected to .pdf", got)
+			}
+			if logged := strings.Contains(logs.String(), "misleading content type"); logged != test.misleading {
+				t.Errorf("misleading content type logged = %v, want %v", logged, test.misleading)
+			}
+		})
+	}
+}
+
+func TestDownloadPDFWriteFailureLeavesNoFile(t *testing.T) {
+	files := newMemFS()
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("Content-Type", "application/pdf")
+		writer.Write([]byte("%PDF-1.7\nnew version\n%%EOF\n"))
+	}))
+	defer server.Close()
+	config := testConfig(t)
+	config.FS, config.OutputDir = files, "out"
+	config.RefreshOlderThan = time.Hour // The stored copy is from 2024, so it's fetched again
+	scraper := newTestScraper(t, config, server.Client())
+	createDirectory(files, "out", 0o755)
+	writeFile(files, "out/stored.pdf", []byte("%PDF-1.7\nold version\n%%EOF\n"), 0o644)
+	scraper.recorder.Record(testEntry("stored.pdf", server.URL+"/stored.pdf"))
+	files.failWrites = errors.New("input/output error")
+
+	for _, filename := range []string{"new.pdf", "stored.pdf"} {
+		if _, err := scraper.downloadPDF(context.Background(), server.URL+"/"+filename, filename); err == nil {
+			t.Fatalf("%s: the download succeeded on a failing disk", filename)
+		}
+	}
+	if names := files.names(); len(names) != 1 || names[0] != "out/stored.pdf" {
+		t.Errorf("files = %v, want only the stored copy", names)
+	}
+	if got, _ := files.contents("out/stored.pdf"); string(got) != "%PDF-1.7\nold version\n%%EOF\n" {
+		t.Errorf("stored copy replaced by %q", got)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"       // For cancelling in-flight work
+	"fmt"           // For formatted I/O operations
+	"io"            // For general I/O primitives
+	"net/http"      // For request headers
+	"path/filepath" // For manipulating filename paths
+)
+
+// Downloader fetches the document at url and stores it as dest, a path relative to the output
+// directory; implementations count and report what happened themselves, so a caller only needs
+// the error to know the document wasn't stored
+type Downloader interface {
+	Download(ctx context.Context, url string, dest string) (DownloadResult, error)
+}
+
+// Outcomes of a download that didn't fail
+const (
+	outcomeDownloaded = "downloaded" // The document was saved
+	outcomeSkipped    = "skipped"    // The document was checked but not saved, as when it didn't change
+	outcomeCataloged  = "cataloged"  // The document's headers were recorded with -head-only
+	outcomeListed     = "listed"     // The document was printed or listed instead of downloaded
+)
+
+// DownloadResult describes how a Download ended
+type DownloadResult struct {
+	Outcome  string // One of the outcomes
+	Filename string // Path the document is stored under, which may differ from dest, as when its extension was corrected
+	Status   int    // HTTP status of the response, when one arrived
+	Bytes    int64  // Size of the saved document, or the size the server reported for a cataloged one
+	SHA256   string // Hex checksum of the saved document
+	Reason   string // Why a skipped document wasn't saved: exists, in_progress, off_site, not_modified, old, too_large, unchanged or duplicate
+}
+
+// DownloaderFunc adapts a function to the Downloader interface
+type DownloaderFunc func(ctx context.Context, url string, dest string) (DownloadResult, error)
+
+// Download calls the function
+func (download DownloaderFunc) Download(ctx context.Context, url string, dest string) (DownloadResult, error) {
+	return download(ctx, url, dest)
+}
+
+// dryRunDownloader prints each document's URL and would-be path instead of downloading it
+type dryRunDownloader struct {
+	dir string // Output directory the paths are shown in
+}
+
+// Download prints url and the path dest would be saved at
+func (downloader dryRunDownloader) Download(ctx context.Context, url string, dest string) (DownloadResult, error) {
+	fmt.Printf("%s\t%s\n", url, filepath.Join(downloader.dir, dest)) // URL and would-be path, instead of downloading
+	return DownloadResult{Outcome: outcomeListed, Filename: dest}, nil
+}
+
+// precheckDownloader sends a HEAD request before handing a document to next, and skips it when
+// it's over the size cap; a document that's already stored isn't checked, as it's either skipped
+// or fetched with a conditional GET, which is cheap already
+type precheckDownloader struct {
+	next    Downloader // Downloads the documents worth a GET
+	scraper *Scraper   // Sends the HEAD requests
+}
+
+// Download prechecks url unless dest is stored and current, then downloads it with next
+func (downloader precheckDownloader) Download(ctx context.Context, url string, dest string) (DownloadResult, error) {
+	if downloader.scraper.fetchesInFull(dest) && !downloader.scraper.precheck(ctx, url) {
+		return DownloadResult{Outcome: outcomeSkipped, Filename: dest, Reason: "too_large"}, nil
+	}
+	return downloader.next.Download(ctx, url, dest)
+}
+
+// resumeDownloader has next keep interrupted downloads as .part files and continue them with
+// Range requests, instead of staging every download in a temp file that's lost on failure
+type resumeDownloader struct {
+	next Downloader // Downloads the documents
+}
+
+// Download downloads url to dest with next, writing the body to a resumable .part file
+func (downloader resumeDownloader) Download(ctx context.Context, url string, dest string) (DownloadResult, error) {
+	return downloader.next.Download(context.WithValue(ctx, bodyWriterKey{}, partialWriter{}), url, dest)
+}
+
+// bodyWriter stores the body of a download as it arrives, in a file that's published once it's
+// complete
+type bodyWriter interface {
+	// prepare returns how many bytes of the document at filePath on fsys are stored already,
+	// adding the headers asking for the rest to header
+	prepare(fsys FS, filePath string, header http.Header) int64
+	// write writes body to a file for the document filename at filePath after its first offset
+	// bytes, which came from the response identified by state, and returns the file's path,
+	// complete size and hex SHA-256; a size other than expectedSize, unless it's -1, fails with
+	// errSizeMismatch
+	write(ctx context.Context, fsys FS, filePath string, filename string, offset int64, state partialState, body io.Reader, expectedSize int64) (string, int64, string, error)
+}
+
+// bodyWriterKey is the context key of the bodyWriter a decorator chose
+type bodyWriterKey struct{}
+
+// bodyWriterFrom returns the bodyWriter set on ctx by a decorator such as resumeDownloader, or
+// fallback
+func bodyWriterFrom(ctx context.Context, fallback bodyWriter) bodyWriter {
+	if writer, ok := ctx.Value(bodyWriterKey{}).(bodyWriter); ok {
+		return writer
+	}
+	return fallback
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"             // For the test document
+	"context"           // For download contexts
+	"net/http"          // For test handlers
+	"net/http/httptest" // For test servers
+	"os"                // For the output directory
+	"path/filepath"     // For output paths
+	"strings"           // For the test document
+	"sync"              // For guarding recorded requests
+	"testing"           // For the test framework
+	"time"              // For the served modification time
+)
+
+// requestLog records the method and Range header of the requests a test server receives
+type requestLog struct {
+	mutex    sync.Mutex
+	requests []string // "METHOD range" of each request
+}
+
+// add records request
+func (log *requestLog) add(request *http.Request) {
+	log.mutex.Lock()
+	defer log.mutex.Unlock()
+	log.requests = append(log.requests, strings.TrimSpace(request.Method+" "+request.Header.Get("Range")))
+}
+
+// list returns the requests recorded so far
+func (log *requestLog) list() []string {
+	log.mutex.Lock()
+	defer log.mutex.Unlock()
+	return append([]string(nil), log.requests...)
+}
+
+func TestPrecheckDownloaderSkipsLargeDocuments(t *testing.T) {
+	var log requestLog
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		log.add(request)
+		writer.Header().Set("Content-Type", "application/pdf")
+		writer.Header().Set("Content-Length", "5000000")
+	}))
+	defer server.Close()
+	config := testConfig(t)
+	config.MaxFileSize = 1000
+	scraper := newTestScraper(t, config, server.Client())
+	next := DownloaderFunc(func(ctx context.Context, url string, dest string) (DownloadResult, error) {
+		t.Errorf("a document over the size cap was downloaded")
+		return DownloadResult{}, nil
+	})
+	result, err := precheckDownloader{next: next, scraper: scraper}.Download(context.Background(), server.URL+"/big.pdf", "big.pdf")
+	if err != nil || result.Outcome != outcomeSkipped || result.Reason != "too_large" {
+		t.Fatalf("Download = %+v, %v; want skipped as too_large", result, err)
+	}
+	if requests := log.list(); len(requests) != 1 || requests[0] != http.MethodHead {
+		t.Errorf("requests = %q, want a single HEAD", requests)
+	}
+}
+
+func TestPrecheckDownloaderLeavesStoredDocumentsAlone(t *testing.T) {
+	config := testConfig(t)
+	os.WriteFile(filepath.Join(config.OutputDir, "doc.pdf"), []byte("%PDF-stored"), 0o644)
+	var log requestLog
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		log.add(request)
+	}))
+	defer server.Close()
+	scraper := newTestScraper(t, config, server.Client())
+	downloader := precheckDownloader{next: DownloaderFunc(scraper.downloadPDF), scraper: scraper}
+	result, err := downloader.Download(context.Background(), server.URL+"/doc.pdf", "doc.pdf")
+	if err != nil || result.Reason != "exists" {
+		t.Fatalf("Download = %+v, %v; want skipped as exists", result, err)
+	}
+	if requests := log.list(); len(requests) != 0 {
+		t.Errorf("requests = %q, want none for a stored document", requests)
+	}
+	if got, _ := os.ReadFile(filepath.Join(config.OutputDir, "doc.pdf")); string(got) != "%PDF-stored" {
+		t.Errorf("stored copy changed to %q", got)
+	}
+}
+
+func TestResumeDownloaderContinuesInterruptedDownload(t *testing.T) {
+	document := []byte("%PDF-1.7\n" + strings.Repeat("resumable bytes\n", 64) + "%%EOF\n")
+	modified := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	var log requestLog
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		log.add(request)
+		writer.Header().Set("Content-Type", "application/pdf")
+		writer.Header().Set("ETag", `"v1"`)
+		if len(log.list()) == 1 {
+			writer.Header().Set("Content-Length", "1000")
+			writer.Write(document[:300])
+			writer.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler) // Cut the first transfer short
+		}
+		http.ServeContent(writer, request, "doc.pdf", modified, bytes.NewReader(document))
+	}))
+	defer server.Close()
+	config := testConfig(t)
+	scraper := newTestScraper(t, config, server.Client())
+	downloader := resumeDownloader{next: DownloaderFunc(scraper.downloadPDF)}
+
+	if _, err := downloader.Download(context.Background(), server.URL+"/doc.pdf", "doc.pdf"); err == nil {
+		t.Fatal("the interrupted download succeeded")
+	}
+	if partial, err := os.ReadFile(filepath.Join(config.OutputDir, "doc.pdf"+partialSuffix)); err != nil || !bytes.Equal(partial, document[:300]) {
+		t.Fatalf("kept partial = %q, %v; want the first 300 bytes", partial, err)
+	}
+	result, err := downloader.Download(context.Background(), server.URL+"/doc.pdf", "doc.pdf")
+	if err != nil || result.Outcome != outcomeDownloaded {
+		t.Fatalf("resumed Download = %+v, %v", result, err)
+	}
+	if got, _ := os.ReadFile(filepath.Join(config.OutputDir, "doc.pdf")); !bytes.Equal(got, document) {
+		t.Errorf("stored %d bytes, want the %d-byte document", len(got), len(document))
+	}
+	if result.SHA256 != checksumOf(document) {
+		t.Errorf("checksum %s, want the whole document's", result.SHA256)
+	}
+	if requests := log.list(); len(requests) != 2 || requests[1] != "GET bytes=300-" {
+		t.Errorf("requests = %q, want the second to ask for bytes=300-", requests)
+	}
+	if leftovers, _ := filepath.Glob(filepath.Join(config.OutputDir, "doc.pdf.part*")); len(leftovers) != 0 {
+		t.Errorf("%v left behind after the download completed", leftovers)
+	}
+}
+
+func TestDownloadWithoutResumeDiscardsInterruptedDownload(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("Content-Length", "1000")
+		writer.Write([]byte("%PDF-1.7\npartial"))
+		writer.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	}))
+	defer server.Close()
+	config := testConfig(t)
+	scraper := newTestScraper(t, config, server.Client())
+	if _, err := scraper.downloadPDF(context.Background(), server.URL+"/doc.pdf", "doc.pdf"); err == nil {
+		t.Fatal("the interrupted download succeeded")
+	}
+	if names, _ := os.ReadDir(config.OutputDir); len(names) != 0 {
+		t.Errorf("files left behind: %v", names)
+	}
+}
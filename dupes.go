@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json" // For reading the manifest
+	"fmt"           // For formatted I/O operations
+	"io"            // For general I/O primitives
+	"io/fs"         // For walking the output directory
+	"os"            // For file and system operations
+	"path/filepath" // For manipulating filename paths
+	"sort"          // For ordering the report
+)
+
+// DuplicateFile is one stored copy of a document and the URLs that served it
+type DuplicateFile struct {
+	Filename string   // Path relative to the output directory
+	URLs     []string // Source and alternate URLs recorded for the file
+}
+
+// DuplicateCluster is a set of byte-identical documents: several files, or one file served by
+// several URLs
+type DuplicateCluster struct {
+	SHA256 string          // Checksum shared by the files
+	Size   int64           // Size of each copy in bytes
+	Files  []DuplicateFile // Copies, sorted by filename
+}
+
+// redundantFiles returns how many copies the cluster holds beyond the first
+func (cluster DuplicateCluster) redundantFiles() int {
+	return len(cluster.Files) - 1
+}
+
+// wastedBytes returns the space taken by the redundant copies
+func (cluster DuplicateCluster) wastedBytes() int64 {
+	return int64(cluster.redundantFiles()) * cluster.Size
+}
+
+// urlCount returns how many URLs serve the cluster's content
+func (cluster DuplicateCluster) urlCount() int {
+	count := 0
+	for _, file := range cluster.Files {
+		count += len(file.URLs)
+	}
+	return count
+}
+
+// findDuplicates groups the documents below dir on fsys by SHA-256, using the checksums recorded
+// in the manifest at manifestPath and hashing the files it doesn't list, and returns the groups
+// with more than one file or URL, largest first; ignored names, relative to dir, are skipped
+func findDuplicates(fsys FS, manifestPath string, dir string, ignored []string) ([]DuplicateCluster, error) {
+	recorded := make(map[string]ManifestEntry) // Manifest entries by cleaned filename
+	if content, err := readFile(fsys, manifestPath); err == nil {
+		var manifest Manifest
+		if err := json.Unmarshal(content, &manifest); err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", manifestPath, err)
+		}
+		for _, entry := range manifest.Entries {
+			recorded[filepath.Clean(entry.Filename)] = entry
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	clusters := make(map[string]*DuplicateCluster) // Clusters by checksum
+	err := fsys.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.Type().IsRegular() {
+			return err // Directories and special files aren't documents
+		}
+		name, err := filepath.Rel(dir, path)
+		if err != nil || isBookkeepingFile(name, ignored) {
+			return err
+		}
+		file := DuplicateFile{Filename: filepath.ToSlash(name)}
+		var size int64
+		var checksum string
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if known, ok := recorded[name]; ok && known.Size == info.Size() && known.SHA256 != "" {
+			size, checksum = known.Size, known.SHA256 // Hashed when it was downloaded
+			file.URLs = append([]string{known.SourceURL}, known.AlternateURLs...)
+		} else if size, checksum, err = fileChecksum(fsys, path); err != nil {
+			return err
+		}
+		cluster, ok := clusters[checksum]
+		if !ok {
+			cluster = &DuplicateCluster{SHA256: checksum, Size: size}
+			clusters[checksum] = cluster
+		}
+		cluster.Files = append(cluster.Files, file)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var duplicates []DuplicateCluster
+	for _, cluster := range clusters {
+		if len(cluster.Files) > 1 || cluster.urlCount() > 1 {
+			sort.Slice(cluster.Files, func(i, j int) bool { return cluster.Files[i].Filename < cluster.Files[j].Filename })
+			duplicates = append(duplicates, *cluster)
+		}
+	}
+	sort.Slice(duplicates, func(i, j int) bool {
+		if duplicates[i].wastedBytes() != duplicates[j].wastedBytes() {
+			return duplicates[i].wastedBytes() > duplicates[j].wastedBytes() // Most wasted space first
+		}
+		return duplicates[i].SHA256 < duplicates[j].SHA256 // Stable order for diffing reports
+	})
+	return duplicates, nil
+}
+
+// printDuplicateReport writes every cluster, with its files and their URLs, and a closing total to w
+func printDuplicateReport(w io.Writer, clusters []DuplicateCluster) {
+	files, urls, wasted := 0, 0, int64(0)
+	for _, cluster := range clusters {
+		fmt.Fprintf(w, "DUPLICATE\tsha256=%s\tsize=%d\tfiles=%d\turls=%d\n", cluster.SHA256, cluster.Size, len(cluster.Files), cluster.urlCount())
+		for _, file := range cluster.Files {
+			fmt.Fprintf(w, "\t%s\n", file.Filename)
+			for _, uri := range file.URLs {
+				fmt.Fprintf(w, "\t\t%s\n", uri)
+			}
+		}
+		files += cluster.redundantFiles()
+		urls += max(cluster.urlCount()-1, 0)
+		wasted += cluster.wastedBytes()
+	}
+	fmt.Fprintf(w, "Duplicates: %d clusters, %d redundant files (%.2f MB), %d redundant URLs\n", len(clusters), files, float64(wasted)/(1<<20), urls)
+}
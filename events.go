@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json" // For encoding events
+	"fmt"           // For formatted I/O operations
+	"io"            // For general I/O primitives
+	"os"            // For file and system operations
+	"strconv"       // For parsing file descriptor numbers
+	"strings"       // For string manipulation
+	"sync"          // For handling concurrency
+	"time"          // For time-related operations
+)
+
+// Event kinds written to -events-json
+const (
+	eventPageFetched      = "page_fetched"      // A search page or sitemap was fetched
+	eventLinkFound        = "link_found"        // A new document link was queued
+	eventDownloadStarted  = "download_started"  // The GET for a document was sent
+	eventDownloadComplete = "download_complete" // A document was saved
+	eventDownloadSkipped  = "download_skipped"  // A download ended without saving, as when the document didn't change
+	eventDownloadFailed   = "download_failed"   // A download failed, or was cancelled
+	eventRunSummary       = "run_summary"       // The run's counters, written last
+)
+
+// Event is one line of -events-json; fields that don't apply to the kind are left out, and new
+// fields may be added but existing ones are never renamed or removed
+type Event struct {
+	Time       time.Time   `json:"time"`                  // When the event happened, in UTC
+	Event      string      `json:"event"`                 // One of the event kinds
+	URL        string      `json:"url,omitempty"`         // Page or document URL
+	Filename   string      `json:"filename,omitempty"`    // Document path, relative to the output directory
+	Status     int         `json:"status,omitempty"`      // HTTP status, when a response arrived
+	Bytes      int64       `json:"bytes,omitempty"`       // Size of the page or saved document
+	SHA256     string      `json:"sha256,omitempty"`      // Hex checksum of the saved document
+	DurationMS int64       `json:"duration_ms,omitempty"` // How long the fetch took
+	Error      string      `json:"error,omitempty"`       // Why the download failed
+	Reason     string      `json:"reason,omitempty"`      // Why the download was skipped: off_site, not_modified, old, too_large, unchanged or duplicate
+	Cancelled  bool        `json:"cancelled,omitempty"`   // Whether the download was abandoned rather than failed
+	Summary    *RunSummary `json:"summary,omitempty"`     // Counters of a run_summary event
+}
+
+// RunSummary holds the end-of-run counters of a run_summary event, as in the printed summary
+type RunSummary struct {
+	Requests         int64 `json:"requests"`
+	PagesFetched     int64 `json:"pages_fetched"`
+	PagesCached      int64 `json:"pages_cached"`
+	PagesFailed      int64 `json:"pages_failed"`
+	LinksFound       int64 `json:"links_found"`
+	Downloaded       int64 `json:"downloaded"`
+	SkippedExisting  int64 `json:"skipped_existing"`
+	SkippedDuplicate int64 `json:"skipped_duplicate"`
+	SkippedOld       int64 `json:"skipped_old"`
+	SkippedTooLarge  int64 `json:"skipped_too_large"`
+	SkippedOffSite   int64 `json:"skipped_off_site"`
+	SkippedInvalid   int64 `json:"skipped_invalid"`
+	Failed           int64 `json:"failed"`
+	Cancelled        int64 `json:"cancelled"`
+	Cataloged        int64 `json:"cataloged"`
+	NewDocuments     int64 `json:"new_documents"`
+	Bytes            int64 `json:"bytes"`
+	ElapsedMS        int64 `json:"elapsed_ms"`
+	PausedMS         int64 `json:"paused_ms"`
+	SizeMismatches   int64 `json:"size_mismatches"`
+	LimitReached     bool  `json:"limit_reached"`
+}
+
+// summary returns the counters for a run_summary event
+func (stats *Stats) summary() *RunSummary {
+	return &RunSummary{
+		Requests:         stats.Requests.Load(),
+		PagesFetched:     stats.PagesFetched.Load(),
+		PagesCached:      stats.PagesCached.Load(),
+		PagesFailed:      stats.PagesFailed.Load(),
+		LinksFound:       stats.LinksFound.Load(),
+		Downloaded:       stats.Downloaded.Load(),
+		SkippedExisting:  stats.SkippedExisting.Load(),
+		SkippedDuplicate: stats.SkippedDuplicate.Load(),
+		SkippedOld:       stats.SkippedOld.Load(),
+		SkippedTooLarge:  stats.SkippedTooLarge.Load(),
+		SkippedOffSite:   stats.SkippedOffSite.Load(),
+		SkippedInvalid:   stats.SkippedInvalid.Load(),
+		Failed:           stats.Failed.Load(),
+		Cancelled:        stats.Cancelled.Load(),
+		Cataloged:        stats.Cataloged.Load(),
+		NewDocuments:     stats.NewDocuments.Load(),
+		Bytes:            stats.Bytes.Load(),
+		ElapsedMS:        time.Since(stats.Start).Milliseconds(),
+		PausedMS:         time.Duration(stats.Paused.Load()).Milliseconds(),
+		SizeMismatches:   stats.SizeMismatches.Load(),
+		LimitReached:     stats.LimitReached.Load(),
+	}
+}
+
+// eventStream writes newline-delimited JSON events; it is safe for concurrent use, and a nil
+// stream drops every event
+type eventStream struct {
+	mutex  sync.Mutex
+	writer io.Writer // Unbuffered, so a reader sees each event as it happens
+	file   io.Closer // File opened for the stream, or nil for stdout and stderr
+}
+
+// openEventStream opens an -events-json destination: "-" for stdout, "fd:N" for an inherited
+// file descriptor, or a file path on fsys, replaced if it exists
+func openEventStream(fsys FS, destination string) (*eventStream, error) {
+	if destination == "-" {
+		return &eventStream{writer: os.Stdout}, nil
+	}
+	if number, ok := strings.CutPrefix(destination, "fd:"); ok {
+		fd, err := strconv.ParseUint(number, 10, 31)
+		if err != nil || fd < 3 {
+			return nil, fmt.Errorf("invalid -events-json %q: want fd:N with N at least 3, or - for stdout", destination)
+		}
+		file := os.NewFile(uintptr(fd), destination) // Opened by the parent process
+		return &eventStream{writer: file, file: file}, nil
+	}
+	file, err := fsys.Create(destination)
+	if err != nil {
+		return nil, err
+	}
+	return &eventStream{writer: file, file: file}, nil
+}
+
+// emit writes one event, stamped with the current time
+func (events *eventStream) emit(event Event) {
+	if events == nil {
+		return // -events-json not set
+	}
+	event.Time = time.Now().UTC()
+	line, err := json.Marshal(event)
+	if err != nil {
+		return // Every field is plain data, so this doesn't happen
+	}
+	events.mutex.Lock()
+	defer events.mutex.Unlock()
+	events.writer.Write(append(line, '\n')) // A reader that went away mustn't stop the run
+}
+
+// Close closes the file the stream writes to
+func (events *eventStream) Close() error {
+	if events == nil || events.file == nil {
+		return nil
+	}
+	return events.file.Close()
+}
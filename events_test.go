@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"             // For capturing the stream
+	"context"           // For download contexts
+	"encoding/json"     // For decoding events
+	"fmt"               // For generated URLs
+	"net/http"          // For test handlers
+	"net/http/httptest" // For test servers
+	"os"                // For reading the stream back
+	"path/filepath"     // For the stream's path
+	"strings"           // For splitting the stream into lines
+	"sync"              // For concurrent emits
+	"testing"           // For the test framework
+	"time"              // For event times
+)
+
+// decodeEvents parses a newline-delimited JSON stream, failing the test on any malformed line
+func decodeEvents(t *testing.T, stream []byte) []map[string]any {
+	t.Helper()
+	var events []map[string]any
+	for line := range strings.Lines(string(stream)) {
+		var event map[string]any
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			t.Fatalf("malformed event line %q: %v", line, err)
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+func TestEventStreamWritesJSONLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	events, err := openEventStream(osFS{}, path)
+	if err != nil {
+		t.Fatalf("openEventStream: %v", err)
+	}
+	before := time.Now().UTC().Add(-time.Second)
+	var emitters sync.WaitGroup
+	for number := range 50 {
+		emitters.Add(1)
+		go func() {
+			defer emitters.Done()
+			events.emit(Event{Event: eventLinkFound, URL: fmt.Sprintf("https://www.airgas.com/msds/%d.pdf", number)})
+		}()
+	}
+	emitters.Wait()
+	if err := events.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	stream, _ := os.ReadFile(path)
+	decoded := decodeEvents(t, stream)
+	if len(decoded) != 50 {
+		t.Fatalf("%d events, want 50", len(decoded))
+	}
+	urls := make(map[any]bool)
+	for _, event := range decoded {
+		urls[event["url"]] = true
+		stamp, err := time.Parse(time.RFC3339Nano, fmt.Sprint(event["time"]))
+		if err != nil || stamp.Before(before) || !strings.HasSuffix(fmt.Sprint(event["time"]), "Z") {
+			t.Errorf("time %v, want a UTC time of this run", event["time"])
+		}
+		if len(event) != 3 || event["event"] != eventLinkFound {
+			t.Errorf("event %v, want only time, event and url", event)
+		}
+	}
+	if len(urls) != 50 {
+		t.Errorf("%d distinct URLs, want every emitted event", len(urls))
+	}
+}
+
+func TestNilEventStream(t *testing.T) {
+	var events *eventStream
+	events.emit(Event{Event: eventLinkFound}) // -events-json not set
+	if err := events.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+}
+
+func TestOpenEventStreamDestinations(t *testing.T) {
+	if events, err := openEventStream(osFS{}, "-"); err != nil || events.file != nil {
+		t.Errorf("openEventStream(osFS{}, -) = %+v, %v; want stdout, left open", events, err)
+	}
+	for _, destination := range []string{"fd:0", "fd:2", "fd:x", "fd:", "fd:-3"} {
+		if _, err := openEventStream(osFS{}, destination); err == nil || !strings.Contains(err.Error(), "want fd:N with N at least 3") {
+			t.Errorf("openEventStream(osFS{}, %q): err = %v", destination, err)
+		}
+	}
+}
+
+func TestDownloadEvents(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.URL.Path == "/missing.pdf" {
+			http.NotFound(writer, request)
+			return
+		}
+		writer.Header().Set("ETag", `"v1"`)
+		if request.Header.Get("If-None-Match") == `"v1"` {
+			writer.WriteHeader(http.StatusNotModified)
+			return
+		}
+		writer.Header().Set("Content-Type", "application/pdf")
+		writer.Write([]byte("%PDF-1.7\n%%EOF\n"))
+	}))
+	defer server.Close()
+	scraper := newTestScraper(t, testConfig(t), server.Client())
+	var stream bytes.Buffer
+	scraper.events = &eventStream{writer: &stream}
+
+	scraper.downloadPDF(context.Background(), server.URL+"/doc.pdf", "doc.pdf")
+	scraper.downloadPDF(context.Background(), server.URL+"/doc.pdf", "doc.pdf")
+	scraper.downloadPDF(context.Background(), server.URL+"/missing.pdf", "missing.pdf")
+
+	want := []map[string]any{
+		{"event": eventDownloadStarted, "filename": "doc.pdf", "url": server.URL + "/doc.pdf"},
+		{"event": eventDownloadComplete, "filename": "doc.pdf", "status": 200.0, "bytes": 15.0, "sha256": checksumOf([]byte("%PDF-1.7\n%%EOF\n"))},
+		{"event": eventDownloadStarted, "filename": "doc.pdf"},
+		{"event": eventDownloadSkipped, "filename": "doc.pdf", "status": 304.0, "reason": "not_modified"},
+		{"event": eventDownloadStarted, "filename": "missing.pdf"},
+		{"event": eventDownloadFailed, "status": 404.0, "error": "Not Found"},
+	}
+	decoded := decodeEvents(t, stream.Bytes())
+	if len(decoded) != len(want) {
+		t.Fatalf("events = %v, want %d", decoded, len(want))
+	}
+	for number, fields := range want {
+		for field, value := range fields {
+			if decoded[number][field] != value {
+				t.Errorf("event %d %s = %v, want %v", number, field, decoded[number][field], value)
+			}
+		}
+	}
+	if _, set := decoded[5]["cancelled"]; set {
+		t.Errorf("failure event %v, want no cancelled flag", decoded[5])
+	}
+}
+
+func TestRunSummaryEvent(t *testing.T) {
+	stats := &Stats{Start: time.Now().Add(-2 * time.Second)}
+	stats.Downloaded.Add(3)
+	stats.SkippedExisting.Add(2)
+	stats.Failed.Add(1)
+	stats.Bytes.Add(4096)
+	stats.Paused.Add(int64(time.Second))
+	stats.LimitReached.Store(true)
+	var stream bytes.Buffer
+	(&eventStream{writer: &stream}).emit(Event{Event: eventRunSummary, Summary: stats.summary()})
+
+	decoded := decodeEvents(t, stream.Bytes())
+	if len(decoded) != 1 || decoded[0]["event"] != eventRunSummary {
+		t.Fatalf("events = %v, want one run_summary", decoded)
+	}
+	summary, _ := decoded[0]["summary"].(map[string]any)
+	for field, value := range map[string]any{"downloaded": 3.0, "skipped_existing": 2.0, "failed": 1.0, "bytes": 4096.0, "paused_ms": 1000.0, "limit_reached": true, "pages_fetched": 0.0} {
+		if summary[field] != value {
+			t.Errorf("summary %s = %v, want %v", field, summary[field], value)
+		}
+	}
+	if elapsed, _ := summary["elapsed_ms"].(float64); elapsed < 2000 {
+		t.Errorf("elapsed_ms = %v, want at least 2000", summary["elapsed_ms"])
+	}
+}
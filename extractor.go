@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json" // For parsing JSON search responses
+	"log/slog"      // For structured, levelled logging
+	"maps"          // For iterating object keys
+	"mime"          // For parsing Content-Type values
+	"net/url"       // For parsing and manipulating URLs
+	"regexp"        // For document endpoint patterns
+	"slices"        // For sorting object keys
+	"strings"       // For string manipulation
+)
+
+// Extractor pulls PDF links, and the link to the next page, out of a search response body,
+// resolving relative links against base
+type Extractor interface {
+	Extract(body []byte, base *url.URL) []PDFLink
+	NextPage(body []byte, base *url.URL) (string, bool)
+}
+
+// htmlExtractor extracts links from the HTML search pages
+type htmlExtractor struct {
+	patterns []*regexp.Regexp // Document links without a .pdf extension
+}
+
+// Extract returns the PDF links in an HTML page
+func (extractor htmlExtractor) Extract(body []byte, base *url.URL) []PDFLink {
+	return extractPDFLinks(string(body), base, extractor.patterns)
+}
+
+// NextPage returns the page an HTML page's next link points at
+func (htmlExtractor) NextPage(body []byte, base *url.URL) (string, bool) {
+	return findNextPageURL(string(body), base)
+}
+
+// jsonURLKeys are the object keys whose string values may hold a document URL
+var jsonURLKeys = []string{"url", "href", "link", "pdfUrl", "pdf_url", "sdsUrl", "sds_url"}
+
+// jsonDateKeys are the object keys whose string values may hold a document's last-updated date
+var jsonDateKeys = []string{"modified", "lastModified", "last_modified", "updated", "updatedAt", "date"}
+
+// jsonExtractor extracts links from JSON search API responses: any object, at any depth, with a
+// jsonURLKeys string pointing at a .pdf, or matching a pattern, is a document, dated by an optional jsonDateKeys sibling,
+// such as {"results": [{"url": "/msds/001001.pdf", "modified": "2024-03-01"}]}
+type jsonExtractor struct {
+	patterns []*regexp.Regexp // Document links without a .pdf extension
+}
+
+// Extract returns the PDF links in a JSON response
+func (extractor jsonExtractor) Extract(body []byte, base *url.URL) []PDFLink {
+	var document any
+	if err := json.Unmarshal(body, &document); err != nil {
+		slog.Error("Failed to parse JSON", "error", err) // Log parsing error
+		return nil
+	}
+	seen := make(map[string]bool) // Links already kept
+	var links []PDFLink
+	var walk func(value any)
+	walk = func(value any) {
+		switch typed := value.(type) {
+		case map[string]any:
+			for _, key := range jsonURLKeys {
+				raw, _ := typed[key].(string)
+				link, ok := resolvePDFLink(raw, base, extractor.patterns)
+				if !ok || seen[link] {
+					continue // Not a new PDF link
+				}
+				seen[link] = true
+				document := PDFLink{URL: link}
+				for _, dateKey := range jsonDateKeys {
+					if value, ok := typed[dateKey].(string); ok {
+						if date, ok := parseDocumentDate(value); ok {
+							document.Modified = date // Date listed with the document
+							break
+						}
+					}
+				}
+				links = append(links, document)
+			}
+			for _, key := range slices.Sorted(maps.Keys(typed)) {
+				walk(typed[key]) // Nested objects, in a stable order
+			}
+		case []any:
+			for _, item := range typed {
+				walk(item)
+			}
+		}
+	}
+	walk(document)
+	return links
+}
+
+// NextPage returns the page a JSON response names as its next one
+func (jsonExtractor) NextPage(body []byte, base *url.URL) (string, bool) {
+	return findNextJSONPageURL(body, base)
+}
+
+// pageExtensions are the extensions a search response's copy in the HTML cache can get
+var pageExtensions = []string{".html", ".json"}
+
+// extractorFor returns the extractor, matching patterns besides .pdf links, for a response
+// Content-Type and the extension of its cached copy; anything not JSON is treated as HTML
+func extractorFor(contentType string, patterns []*regexp.Regexp) (Extractor, string) {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if mediaType == "application/json" || strings.HasSuffix(mediaType, "+json") {
+		return jsonExtractor{patterns: patterns}, ".json"
+	}
+	return htmlExtractor{patterns: patterns}, ".html"
+}
+
+// extractorForExtension returns the extractor, matching patterns besides .pdf links, for a
+// cached page with one of pageExtensions
+func extractorForExtension(extension string, patterns []*regexp.Regexp) Extractor {
+	if extension == ".json" {
+		return jsonExtractor{patterns: patterns}
+	}
+	return htmlExtractor{patterns: patterns}
+}
@@ -0,0 +1,380 @@
+package main
+
+import (
+	"crypto/sha256" // For checksumming downloaded files
+	"encoding/hex"  // For encoding checksums as text
+	"fmt"           // For formatted I/O operations
+	"io"            // For general I/O primitives
+	"log/slog"      // For structured, levelled logging
+	"net/url"       // For parsing and manipulating URLs
+	"path"          // For extensions of URL paths
+	"path/filepath" // For manipulating filename paths
+	"regexp"        // For finding document IDs in URLs
+	"slices"        // For searching slices
+	"strings"       // For string manipulation
+	"sync"          // For handling concurrency
+	"text/template" // For -name-template filename patterns
+	"unicode/utf8"  // For cutting long names on character boundaries
+)
+
+// QueryNaming controls how a URL's query string is folded into its filename
+type QueryNaming struct {
+	Mode   string   // keep (the whole query), drop, hash (a short hash) or only (Params)
+	Params []string // Query parameters kept by the only mode
+}
+
+// parseQueryNaming parses a -flatten-query value: keep, drop, hash or only:param1,param2
+func parseQueryNaming(value string) (QueryNaming, error) {
+	mode, params, hasParams := strings.Cut(value, ":")
+	switch {
+	case !hasParams && (mode == "keep" || mode == "drop" || mode == "hash"):
+		return QueryNaming{Mode: mode}, nil
+	case hasParams && mode == "only" && params != "":
+		return QueryNaming{Mode: mode, Params: strings.Split(params, ",")}, nil
+	default:
+		return QueryNaming{}, fmt.Errorf("invalid -flatten-query %q: want keep, drop, hash or only:param1,param2", value)
+	}
+}
+
+// queryPart returns the filename part for a raw query string ("" when nothing is kept)
+func (naming QueryNaming) queryPart(rawQuery string) string {
+	if rawQuery == "" {
+		return ""
+	}
+	switch naming.Mode {
+	case "drop":
+		return "" // Collisions are resolved by nameAssigner
+	case "hash":
+		return shortURLHash(rawQuery) // Short but still tells queries apart
+	case "only":
+		query, _ := url.ParseQuery(rawQuery) // Malformed pairs are skipped, valid ones kept
+		kept := url.Values{}
+		for _, param := range naming.Params {
+			if values, ok := query[param]; ok {
+				kept[param] = values // Whitelisted parameter
+			}
+		}
+		return strings.ReplaceAll(kept.Encode(), "&", "_") // Sorted by key
+	default:
+		return strings.ReplaceAll(rawQuery, "&", "_") // Replace & in query with underscore
+	}
+}
+
+// FilenameFields are the parts of a URL a -name-template can use, all lowercased
+type FilenameFields struct {
+	Host  string // Host of the URL, such as www.airgas.com
+	Path  string // Path without its extension, slashes replaced by underscores, such as _msds_001001
+	Dir   string // Directory part of Path, such as _msds
+	Base  string // Last path segment without its extension, such as 001001
+	Ext   string // Extension, dot included, or .pdf when the path has none
+	Query string // Query string as chosen by -flatten-query, or empty
+	Hash  string // Short hash of the full URL, as used to tell colliding names apart
+	ID    string // Document or product ID from a known airgas.com URL pattern, such as 001001, or empty
+}
+
+// defaultNameTemplate is the naming scheme used without -name-template: host, path and query
+// joined by underscores
+const defaultNameTemplate = `{{.Host}}{{if .Path}}_{{.Path}}{{end}}{{if .Query}}_{{.Query}}{{end}}{{.Ext}}`
+
+// parseNameAffix parses a -name-prefix or -name-suffix value, a template like -name-template's
+func parseNameAffix(flagName string, text string) (*template.Template, error) {
+	affix, err := template.New(flagName).Parse(text)
+	if err == nil {
+		err = affix.Execute(io.Discard, FilenameFields{}) // Such as an unknown field
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid -%s: %w", flagName, err)
+	}
+	return affix, nil
+}
+
+// parseNameTemplate parses a -name-template value and checks that it renders a usable name for
+// a sample URL, so mistakes are reported at startup rather than once per document
+func parseNameTemplate(text string) (*template.Template, error) {
+	nameTemplate, err := template.New("name").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -name-template: %w", err)
+	}
+	var name strings.Builder
+	if err := nameTemplate.Execute(&name, FilenameFields{}); err != nil {
+		return nil, fmt.Errorf("invalid -name-template: %w", err) // Such as an unknown field
+	}
+	if sample := urlToFilename("https://www.airgas.com/msds/001001.pdf", QueryNaming{Mode: "keep"}, nameTemplate); sample == "" {
+		return nil, fmt.Errorf("invalid -name-template %q: renders an empty filename", text)
+	}
+	return nameTemplate, nil
+}
+
+// invalidFilenameChars are characters not allowed in filenames on common filesystems
+var invalidFilenameChars = []string{`"`, `\`, `/`, `:`, `*`, `?`, `<`, `>`, `|`}
+
+// sanitizeFilename makes a rendered name safe to use as a single path element: invalid and
+// control characters become underscores and names made only of dots are rejected
+func sanitizeFilename(name string) string {
+	for _, char := range invalidFilenameChars {
+		name = strings.ReplaceAll(name, char, "_") // Replace invalid characters
+	}
+	name = strings.Map(func(char rune) rune {
+		if char < ' ' || char == 0x7f {
+			return '_' // Newlines, tabs and other control characters
+		}
+		return char
+	}, name)
+	if strings.Trim(name, ".") == "" {
+		return "" // ".", ".." or nothing at all
+	}
+	return strings.ToLower(name)
+}
+
+// urlToFilename converts a URL into a filesystem-safe filename rendered by nameTemplate from the
+// URL's FilenameFields; the default template keeps the extension of the URL path, lowercased,
+// or ends in .pdf when the path has none, and naming decides what becomes of the query string;
+// it returns "" for a URL that can't be parsed or whose name would be only an extension
+func urlToFilename(rawURL string, naming QueryNaming, nameTemplate *template.Template) string {
+	fields, ok := filenameFields(rawURL, naming)
+	if !ok {
+		return "" // Return empty string if parsing fails
+	}
+	return renderFilename(rawURL, fields, nameTemplate, nil, nil)
+}
+
+// filenameFields returns the FilenameFields of rawURL, or false if it can't be parsed
+func filenameFields(rawURL string, naming QueryNaming) (FilenameFields, bool) {
+	parsed, err := url.Parse(rawURL) // Parse the URL
+	if err != nil {
+		slog.Warn("Failed to parse URL", "url", rawURL, "error", err) // Log parsing error
+		return FilenameFields{}, false
+	}
+	extension := documentExtension(parsed.Path) // Extension to end the filename with
+	urlPath := strings.ToLower(parsed.Path)     // Path the name is built from
+	for strings.HasSuffix(urlPath, extension) {
+		urlPath = strings.TrimSuffix(urlPath, extension) // Drop the extension, and any repeats like .pdf.pdf
+	}
+	dir, base := path.Split(urlPath)
+	fields := FilenameFields{
+		Host:  strings.ToLower(parsed.Host),
+		Path:  strings.ReplaceAll(urlPath, "/", "_"), // Replace slashes with underscores
+		Dir:   strings.ReplaceAll(strings.TrimSuffix(dir, "/"), "/", "_"),
+		Base:  base,
+		Ext:   extension,
+		Query: strings.ToLower(naming.queryPart(parsed.RawQuery)), // Query as chosen by -flatten-query
+		Hash:  shortURLHash(rawURL),
+		ID:    documentID(parsed),
+	}
+	return fields, true
+}
+
+// renderFilename renders fields with nameTemplate, adding what prefix and suffix render, when
+// set, before the name and before its extension, and truncates names that are too long; it
+// returns "" when the name would be only an extension
+func renderFilename(rawURL string, fields FilenameFields, nameTemplate *template.Template, prefix *template.Template, suffix *template.Template) string {
+	var parts [3]strings.Builder // Prefix, name and suffix
+	for i, part := range []*template.Template{prefix, nameTemplate, suffix} {
+		if part == nil {
+			continue // No affix
+		}
+		if err := part.Execute(&parts[i], fields); err != nil {
+			slog.Warn("Failed to render filename", "url", rawURL, "error", err)
+			return ""
+		}
+	}
+	name := parts[1].String()
+	extension := "" // Suffixes go before the extension, if the template ended the name with it
+	if strings.HasSuffix(name, fields.Ext) {
+		extension = fields.Ext
+	}
+	name = sanitizeFilename(parts[0].String() + strings.TrimSuffix(name, extension) + parts[2].String() + extension)
+	if strings.Trim(strings.TrimSuffix(name, extension), "._") == "" {
+		return "" // Only the extension, such as .pdf, was left
+	}
+	return truncateFilename(rawURL, name, extension) // Return sanitized and lowercased filename
+}
+
+// maxFilenameBytes is the longest rendered filename; it leaves room below the 255-byte limit of
+// common filesystems for a collision hash and the .tmp, .part.json or .meta.json suffixes
+const maxFilenameBytes = 200
+
+// truncateFilename shortens a name longer than maxFilenameBytes, cutting its stem on a
+// character boundary and appending a short hash of the full URL before extension, so truncated
+// names of different URLs stay apart
+func truncateFilename(rawURL string, name string, extension string) string {
+	if len(name) <= maxFilenameBytes {
+		return name
+	}
+	if len(extension) > maxFilenameBytes/4 {
+		extension = "" // Not a real extension; cut it like the rest
+	}
+	hash := "_" + shortURLHash(rawURL)
+	cut := maxFilenameBytes - len(hash) - len(extension) // Bytes of the stem that are kept
+	for cut > 0 && !utf8.RuneStart(name[cut]) {
+		cut-- // Don't split a multi-byte character
+	}
+	return name[:cut] + hash + extension
+}
+
+// documentIDPatterns find the document or product ID in known airgas.com URL paths; the first
+// group is the ID
+var documentIDPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)/msds/([^/?#]+?)(?:\.pdf)?$`),              // /msds/001001.pdf
+	regexp.MustCompile(`(?i)/sds[-_]?download/([^/?#]+?)(?:\.pdf)?$`),  // /sds-download/123
+	regexp.MustCompile(`(?i)/document/download/([^/?#]+?)(?:\.pdf)?$`), // /document/download/123
+	regexp.MustCompile(`(?i)/product/([^/?#]+)/`),                      // /product/ABC123/sds.pdf
+}
+
+// documentIDParameters are the query parameters, matched case-insensitively, that hold a
+// document or product ID, as in getsds.aspx?id=123
+var documentIDParameters = []string{"id", "sdsid", "sds_id", "docid", "productid", "product_id", "partnumber", "part"}
+
+// documentID returns the ID a known airgas.com URL pattern names the document by, lowercased
+// and made filename-safe, or "" when the URL follows none of them
+func documentID(parsed *url.URL) string {
+	query := parsed.Query()
+	for key, values := range query {
+		if slices.Contains(documentIDParameters, strings.ToLower(key)) && len(values) > 0 && values[0] != "" {
+			return sanitizeFilename(values[0])
+		}
+	}
+	for _, pattern := range documentIDPatterns {
+		if match := pattern.FindStringSubmatch(parsed.Path); match != nil {
+			return sanitizeFilename(match[1])
+		}
+	}
+	return ""
+}
+
+// maxExtensionLength is the longest path suffix, dot included, treated as a file extension
+const maxExtensionLength = 6
+
+// documentExtension returns the lowercased extension of a URL path, or .pdf when the path has
+// no plausible extension
+func documentExtension(urlPath string) string {
+	extension := strings.ToLower(path.Ext(urlPath)) // Extension of the last path segment
+	if len(extension) < 2 || len(extension) > maxExtensionLength {
+		return ".pdf" // No extension, or a dot that doesn't start one
+	}
+	for _, char := range extension[1:] {
+		if (char < 'a' || char > 'z') && (char < '0' || char > '9') {
+			return ".pdf" // Not a real extension, such as "v1.2-final"
+		}
+	}
+	return extension
+}
+
+// shortURLHash returns the first 8 hex characters of the SHA-256 of a URL
+func shortURLHash(rawURL string) string {
+	sum := sha256.Sum256([]byte(rawURL)) // Hash the full, unmodified URL
+	return hex.EncodeToString(sum[:4])   // 8 hex characters is plenty to tell URLs apart
+}
+
+// nameAssigner gives each URL of a run its output filename, sharded, as the URL arrives: its
+// rendered name, or that name with a short hash of the full URL appended before the extension
+// when another URL already holds it; whichever of the two an earlier run's manifest recorded
+// for the URL is kept, so names don't change with the order links are found in
+type nameAssigner struct {
+	mutex        sync.Mutex         // Guards holders
+	naming       QueryNaming        // How query strings become part of filenames
+	nameTemplate *template.Template // Renders each filename from its URL
+	prefix       *template.Template // Renders what goes before each filename, or nil
+	suffix       *template.Template // Renders what goes before each filename's extension, or nil
+	shard        string             // -shard mode
+	recorded     map[string]string  // Source URL → filename recorded by an earlier run
+	holders      map[string]string  // Filename → URL holding it
+}
+
+// newNameAssigner returns an assigner that never hands the recorded filenames of known URLs to
+// other URLs
+func newNameAssigner(config Config, recorded map[string]string) *nameAssigner {
+	assigner := &nameAssigner{
+		naming:       config.QueryNaming,
+		nameTemplate: config.NameTemplate,
+		prefix:       config.NamePrefix,
+		suffix:       config.NameSuffix,
+		shard:        config.Shard,
+		recorded:     recorded,
+		holders:      make(map[string]string, len(recorded)),
+	}
+	for rawURL, filename := range recorded {
+		assigner.holders[filename] = rawURL // Reserved for the URL already stored there
+	}
+	return assigner
+}
+
+// assign returns the filename for rawURL, relative to the output directory, or "" when the URL
+// gives no usable filename
+func (assigner *nameAssigner) assign(rawURL string) string {
+	fields, ok := filenameFields(rawURL, assigner.naming)
+	if !ok {
+		return "" // Nothing to reserve
+	}
+	name := renderFilename(rawURL, fields, assigner.nameTemplate, assigner.prefix, assigner.suffix)
+	if name == "" {
+		return "" // Nothing to reserve
+	}
+	assigner.mutex.Lock()
+	defer assigner.mutex.Unlock()
+	return assigner.claim(rawURL, name, assigner.recorded[rawURL])
+}
+
+// withExtension returns filename with its extension replaced, for a document whose content
+// turned out not to match it, reserving the new name for rawURL like assign does
+func (assigner *nameAssigner) withExtension(rawURL string, filename string, extension string) string {
+	name := strings.TrimSuffix(filepath.Base(filename), getFileExtension(filename)) + extension
+	assigner.mutex.Lock()
+	defer assigner.mutex.Unlock()
+	delete(assigner.holders, filename) // The old name is no longer used
+	return assigner.claim(rawURL, name, "")
+}
+
+// claim returns the sharded path for name, or for name with a short hash of rawURL when another
+// URL holds it, and reserves it for rawURL; a recorded name that matches either, whatever its
+// extension, and sits in its -shard directory is kept instead
+func (assigner *nameAssigner) claim(rawURL string, name string, recorded string) string {
+	extension := getFileExtension(name)
+	stem := strings.TrimSuffix(name, extension)
+	hashedStem := stem + "_" + shortURLHash(rawURL) // Disambiguated name, without the extension
+	filename := filepath.Join(shardDirectory(rawURL, name, assigner.shard), name)
+	recordedName := filepath.Base(recorded)
+	recordedStem := strings.TrimSuffix(recordedName, getFileExtension(recordedName))
+	if recorded != "" && (recordedStem == stem || recordedStem == hashedStem) &&
+		filepath.Join(shardDirectory(rawURL, recordedName, assigner.shard), recordedName) == recorded {
+		filename = recorded // Keep the name the document is already stored under, extension corrected or not
+	} else if holder, taken := assigner.holders[filename]; taken && holder != rawURL {
+		hashedName := hashedStem + extension
+		filename = filepath.Join(shardDirectory(rawURL, hashedName, assigner.shard), hashedName) // Another URL has the plain name
+	}
+	assigner.holders[filename] = rawURL
+	return filename
+}
+
+// shardModes are the accepted -shard values
+var shardModes = []string{"none", "letter", "hash"}
+
+// shardDirectory returns the output subdirectory for a document for the given -shard mode:
+// "letter" uses the first letter or digit of the document's own name (every sanitized filename
+// starts with the same host), "hash" the first two hex digits of the filename's SHA-256, and
+// "none" keeps every file at the top level
+func shardDirectory(rawURL string, filename string, mode string) string {
+	switch mode {
+	case "letter":
+		parsed, err := url.Parse(rawURL)
+		if err != nil {
+			return "_" // Unparseable URLs share one directory
+		}
+		for _, char := range strings.ToLower(path.Base(parsed.Path)) {
+			if (char >= 'a' && char <= 'z') || (char >= '0' && char <= '9') {
+				return string(char) // First letter or digit of the document's name
+			}
+		}
+		return "_" // Names without letters or digits
+	case "hash":
+		sum := sha256.Sum256([]byte(filename))
+		return hex.EncodeToString(sum[:1]) // 256 evenly filled directories
+	default:
+		return "" // Flat layout
+	}
+}
+
+// getFileExtension returns the file extension
+func getFileExtension(path string) string {
+	return filepath.Ext(path) // Use filepath to extract extension
+}
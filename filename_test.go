@@ -0,0 +1,142 @@
+package main
+
+import (
+	"os"            // For creating files with long names
+	"path/filepath" // For their paths
+	"strings"       // For long names
+	"testing"       // For the test framework
+	"text/template" // For the default name template
+	"unicode/utf8"  // For checking cut names
+)
+
+// defaultTemplate is the -name-template used when none is given
+var defaultTemplate = template.Must(parseNameTemplate(defaultNameTemplate))
+
+func TestSanitizeFilename(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"www.airgas.com_msds_001001.pdf", "www.airgas.com_msds_001001.pdf"},
+		{`A:B*C?D"E<F>G|H\I/J.pdf`, "a_b_c_d_e_f_g_h_i_j.pdf"},
+		{"tab\there\nnewline\x7f.pdf", "tab_here_newline_.pdf"},
+		{"UPPER.PDF", "upper.pdf"},
+		{"..", ""},
+		{".", ""},
+		{"", ""},
+	}
+	for _, test := range tests {
+		if got := sanitizeFilename(test.name); got != test.want {
+			t.Errorf("sanitizeFilename(%q) = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestNameAssignerDisambiguatesCollisions(t *testing.T) {
+	first := "https://www.airgas.com/msds/doc.pdf?a=1&b=2"
+	second := "https://www.airgas.com/msds/doc.pdf?a=1_b=2" // Same name once & becomes _
+	if urlToFilename(first, QueryNaming{Mode: "keep"}, defaultTemplate) != urlToFilename(second, QueryNaming{Mode: "keep"}, defaultTemplate) {
+		t.Fatal("the test URLs don't collide")
+	}
+	assigner := newNameAssigner(testConfig(t), nil)
+	firstName, secondName := assigner.assign(first), assigner.assign(second)
+	if firstName != "www.airgas.com__msds_doc_a=1_b=2.pdf" {
+		t.Errorf("first URL = %q, want the plain name", firstName)
+	}
+	if want := "www.airgas.com__msds_doc_a=1_b=2_" + shortURLHash(second) + ".pdf"; secondName != want {
+		t.Errorf("second URL = %q, want %q", secondName, want)
+	}
+	if again := assigner.assign(second); again != secondName {
+		t.Errorf("second URL assigned again = %q, want the same %q", again, secondName)
+	}
+	if again := assigner.assign(first); again != firstName {
+		t.Errorf("first URL assigned again = %q, want the same %q", again, firstName)
+	}
+}
+
+func TestNameAssignerKeepsRecordedNames(t *testing.T) {
+	first := "https://www.airgas.com/msds/doc.pdf?a=1&b=2"
+	second := "https://www.airgas.com/msds/doc.pdf?a=1_b=2"
+	hashed := "www.airgas.com__msds_doc_a=1_b=2_" + shortURLHash(first) + ".pdf"
+	recorded := map[string]string{second: "www.airgas.com__msds_doc_a=1_b=2.pdf", first: hashed} // An earlier run found them the other way round
+	assigner := newNameAssigner(testConfig(t), recorded)
+	if got := assigner.assign(first); got != recorded[first] {
+		t.Errorf("first URL = %q, want its recorded %q", got, recorded[first])
+	}
+	if got := assigner.assign(second); got != recorded[second] {
+		t.Errorf("second URL = %q, want its recorded %q", got, recorded[second])
+	}
+	if got := assigner.assign("https://www.airgas.com/msds/doc.pdf?a=1%26b=2"); got == recorded[first] || got == recorded[second] {
+		t.Errorf("a new URL was given the recorded name %q", got)
+	}
+}
+
+func TestURLToFilenameExtensions(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"https://www.airgas.com/msds/001001.pdf", "www.airgas.com__msds_001001.pdf"},
+		{"https://www.airgas.com/msds/001001.PDF", "www.airgas.com__msds_001001.pdf"},
+		{"https://www.airgas.com/msds/001001.pdf.pdf", "www.airgas.com__msds_001001.pdf"},
+		{"https://www.airgas.com/msds/001001", "www.airgas.com__msds_001001.pdf"},
+		{"https://www.airgas.com/msds/sheet.docx", "www.airgas.com__msds_sheet.docx"},
+		{"https://www.airgas.com/msds/scan.TIFF", "www.airgas.com__msds_scan.tiff"},
+		{"https://www.airgas.com/msds/v1.2-final", "www.airgas.com__msds_v1.2-final.pdf"},
+		{"https://www.airgas.com/getsds.aspx?id=7", "www.airgas.com__getsds_id=7.aspx"},
+		{"https://www.airgas.com/?id=7", "www.airgas.com___id=7.pdf"},
+		{"https://www.airgas.com?id=7", "www.airgas.com_id=7.pdf"},
+		{"https://www.airgas.com/", "www.airgas.com__.pdf"},
+		{"https://www.airgas.com/.pdf", "www.airgas.com__.pdf"},
+		{"https://bad host/a.pdf", ""},
+	}
+	for _, test := range tests {
+		if got := urlToFilename(test.url, QueryNaming{Mode: "keep"}, defaultTemplate); got != test.want {
+			t.Errorf("urlToFilename(%q) = %q, want %q", test.url, got, test.want)
+		}
+	}
+}
+
+func TestLongFilenamesAreTruncated(t *testing.T) {
+	long := "https://www.airgas.com/msds/sheet.pdf?" + strings.Repeat("component=acetylene&", 40)
+	other := long + "grade=industrial"
+	first := urlToFilename(long, QueryNaming{Mode: "keep"}, defaultTemplate)
+	second := urlToFilename(other, QueryNaming{Mode: "keep"}, defaultTemplate)
+	for _, name := range []string{first, second} {
+		if len(name) > maxFilenameBytes {
+			t.Errorf("%d-byte name %q, want at most %d", len(name), name, maxFilenameBytes)
+		}
+		if !strings.HasSuffix(name, ".pdf") {
+			t.Errorf("name %q lost its .pdf extension", name)
+		}
+		if len(name+"_"+shortURLHash(long)+partialStateSuffix) > 255 {
+			t.Errorf("name %q leaves no room for a collision hash and sidecar suffix", name)
+		}
+		if err := os.WriteFile(filepath.Join(t.TempDir(), name+partialStateSuffix), nil, 0o644); err != nil {
+			t.Errorf("creating a file named after it: %v", err)
+		}
+	}
+	if first == second {
+		t.Errorf("two long URLs share the name %q", first)
+	}
+	if !strings.HasSuffix(first, "_"+shortURLHash(long)+".pdf") {
+		t.Errorf("name %q doesn't end with the URL's hash", first)
+	}
+	if again := urlToFilename(long, QueryNaming{Mode: "keep"}, defaultTemplate); again != first {
+		t.Errorf("truncation isn't deterministic: %q, then %q", first, again)
+	}
+	if short := urlToFilename("https://www.airgas.com/msds/a.pdf", QueryNaming{Mode: "keep"}, defaultTemplate); short != "www.airgas.com__msds_a.pdf" {
+		t.Errorf("a short name was changed to %q", short)
+	}
+}
+
+func TestTruncateFilenameKeepsCharacters(t *testing.T) {
+	name := strings.Repeat("é", 150) + ".pdf" // 304 bytes of two-byte characters
+	got := truncateFilename("https://www.airgas.com/x", name, ".pdf")
+	if len(got) > maxFilenameBytes || !utf8.ValidString(got) {
+		t.Errorf("truncated to %d bytes, valid UTF-8 %v: %q", len(got), utf8.ValidString(got), got)
+	}
+	if strange := truncateFilename("https://www.airgas.com/x", strings.Repeat("a", 100)+"."+strings.Repeat("b", 150), "."+strings.Repeat("b", 150)); len(strange) > maxFilenameBytes {
+		t.Errorf("a name with an overlong extension was truncated to %d bytes", len(strange))
+	}
+}
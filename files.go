@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"       // For the copy made by moveFile
+	"errors"        // For combining error values
+	"fmt"           // For formatted I/O operations
+	"io"            // For general I/O primitives
+	"log/slog"      // For structured, levelled logging
+	"os"            // For file and system operations
+	"path/filepath" // For manipulating filename paths
+	"strings"       // For string manipulation
+	"sync"          // For handling concurrency
+	"syscall"       // For disk-full error numbers
+)
+
+// errPathEscapes is returned for names that would resolve outside their directory
+var errPathEscapes = errors.New("path escapes the output directory")
+
+// safeJoin joins dir and the relative name like filepath.Join, but returns errPathEscapes when
+// the cleaned result is not strictly inside dir, such as for names containing ".." or absolute paths
+func safeJoin(dir string, name string) (string, error) {
+	root, err := filepath.Abs(dir) // Absolute and cleaned, so the prefix check also works for "."
+	if err != nil {
+		return "", err
+	}
+	resolved := filepath.Clean(filepath.Join(root, name)) // Where the name really points
+	prefix := root
+	if !strings.HasSuffix(prefix, string(filepath.Separator)) {
+		prefix += string(filepath.Separator) // "PDFs/" must not match "PDFs-old/"
+	}
+	if filepath.IsAbs(name) || filepath.VolumeName(name) != "" || !strings.HasPrefix(resolved, prefix) {
+		return "", fmt.Errorf("%q: %w", name, errPathEscapes)
+	}
+	return filepath.Join(dir, name), nil
+}
+
+// readFileAndReturnAsString reads a file and returns its content as string
+func readFileAndReturnAsString(fsys FS, path string) (string, error) {
+	content, err := readFile(fsys, path) // Read the file contents
+	return string(content), err          // Return the content as a string
+}
+
+// readFile reads the whole file at path, like os.ReadFile
+func readFile(fsys FS, path string) ([]byte, error) {
+	file, err := fsys.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return io.ReadAll(file)
+}
+
+// writeFile creates or truncates the file at path and writes data to it, like os.WriteFile
+func writeFile(fsys FS, path string, data []byte, permission os.FileMode) error {
+	file, err := fsys.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, permission)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	return errors.Join(err, file.Close())
+}
+
+// isDiskFull reports whether err means the disk, or the user's quota on it, has no space left
+func isDiskFull(err error) bool {
+	return errors.Is(err, syscall.ENOSPC) || errors.Is(err, syscall.EDQUOT)
+}
+
+// fileExists checks whether a file exists and is not a directory
+func fileExists(fsys FS, filename string) bool {
+	info, err := fsys.Stat(filename) // Get file info
+	if err != nil {                  // If error occurs (e.g., file not found)
+		return false // Return false
+	}
+	return !info.IsDir() // Return true if it is a file, not a directory
+}
+
+// fileWriteLocks holds one mutex per filename so appends to the same file never interleave
+var fileWriteLocks sync.Map
+
+// lockForFile returns the mutex guarding writes to the given file
+func lockForFile(filename string) *sync.Mutex {
+	lock, _ := fileWriteLocks.LoadOrStore(filename, &sync.Mutex{}) // Reuse the existing lock or create a new one
+	return lock.(*sync.Mutex)                                      // Return the mutex for this file
+}
+
+// appendByteToFile appends byte data to a file (creates file if it doesn’t exist)
+func appendByteToFile(fsys FS, filename string, data []byte) error {
+	lock := lockForFile(filename) // Get the lock for this file
+	lock.Lock()                   // Serialize writers to the same file
+	defer lock.Unlock()           // Release the lock when done
+
+	file, err := fsys.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644) // Open or create file
+	if err != nil {
+		return err // Return error if file can’t be opened
+	}
+	defer file.Close()        // Ensure file is closed
+	_, err = file.Write(data) // Write data to file
+	return err                // Return error if write fails
+}
+
+// directoryExists checks whether a directory exists
+func directoryExists(fsys FS, path string) bool {
+	directory, err := fsys.Stat(path) // Get directory info
+	if err != nil {
+		return false // If error, directory doesn't exist
+	}
+	return directory.IsDir() // Return true if path is a directory
+}
+
+// createDirectory creates a directory, and any missing parents, with specified permissions
+func createDirectory(fsys FS, path string, permission os.FileMode) error {
+	return fsys.MkdirAll(path, permission) // Attempt to create directory
+}
+
+// removeFile deletes a file from the filesystem
+func removeFile(fsys FS, path string) error {
+	return fsys.Remove(path) // Try to delete file
+}
+
+// discardFile removes a temp or stale file as best-effort cleanup, only logging a failure
+func discardFile(fsys FS, path string) {
+	if err := removeFile(fsys, path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		slog.Warn("Failed to remove file", "path", path, "error", err) // Cleanup failures aren't fatal
+	}
+}
+
+// moveFile renames the file at oldPath to newPath; when they're on different filesystems, where
+// a rename fails, it copies the file to a temp file next to newPath, renames that into place and
+// removes oldPath, so newPath still never holds a partial copy
+func moveFile(fsys FS, oldPath string, newPath string) error {
+	err := fsys.Rename(oldPath, newPath)
+	if !errors.Is(err, syscall.EXDEV) {
+		return err // Done, or failed for another reason
+	}
+	source, err := fsys.Open(oldPath)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	copyPath, _, _, err := writeTempFile(context.Background(), fsys, filepath.Dir(newPath), filepath.Base(newPath), source) // Synced like a download
+	if err != nil {
+		return err
+	}
+	if err := fsys.Rename(copyPath, newPath); err != nil {
+		discardFile(fsys, copyPath)
+		return err
+	}
+	discardFile(fsys, oldPath) // The copy is the one kept
+	return nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"           // For download contexts
+	"errors"            // For inspecting error values
+	"fmt"               // For generated lines
+	"io/fs"             // For walking the output directory
+	"net/http"          // For test handlers
+	"net/http/httptest" // For test servers
+	"os"                // For reading the appended file
+	"path/filepath"     // For the test file path
+	"strings"           // For splitting the file into lines
+	"sync"              // For concurrent writers
+	"testing"           // For the test framework
+)
+
+func TestAppendByteToFileConcurrentWriters(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "links.txt")
+	want := make(map[string]bool)
+	var writers sync.WaitGroup
+	for writer := range 50 {
+		for line := range 20 {
+			text := fmt.Sprintf("writer %02d line %02d %s", writer, line, strings.Repeat("x", 200))
+			want[text] = true
+			writers.Add(1)
+			go func() {
+				defer writers.Done()
+				if err := appendByteToFile(osFS{}, path, []byte(text+"\n")); err != nil {
+					t.Errorf("appendByteToFile: %v", err)
+				}
+			}()
+		}
+	}
+	writers.Wait()
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+	if len(lines) != len(want) {
+		t.Fatalf("%d lines, want %d", len(lines), len(want))
+	}
+	for _, line := range lines {
+		if !want[line] {
+			t.Fatalf("line %q isn't one that was written whole", line)
+		}
+		delete(want, line) // Each line arrives exactly once
+	}
+}
+
+func TestSafeJoin(t *testing.T) {
+	tests := []struct {
+		dir  string
+		name string
+		want string // "" when the name escapes dir
+	}{
+		{"PDFs", "a.pdf", filepath.Join("PDFs", "a.pdf")},
+		{"PDFs", "a/b.pdf", filepath.Join("PDFs", "a", "b.pdf")},
+		{"PDFs", "a/../b.pdf", filepath.Join("PDFs", "b.pdf")},
+		{"PDFs", "..a.pdf", filepath.Join("PDFs", "..a.pdf")}, // Dots that aren't a path element
+		{".", "a.pdf", "a.pdf"},
+		{"PDFs", "../a.pdf", ""},
+		{"PDFs", "a/../../a.pdf", ""},
+		{"PDFs", "../PDFs-old/a.pdf", ""}, // Shares the prefix, not the directory
+		{"PDFs", "..", ""},
+		{"PDFs", ".", ""},
+		{"PDFs", "/etc/passwd", ""},
+		{".", "../a.pdf", ""},
+	}
+	for _, test := range tests {
+		got, err := safeJoin(test.dir, test.name)
+		if test.want == "" {
+			if !errors.Is(err, errPathEscapes) {
+				t.Errorf("safeJoin(%q, %q) = %q, %v; want errPathEscapes", test.dir, test.name, got, err)
+			}
+			continue
+		}
+		if err != nil || got != test.want {
+			t.Errorf("safeJoin(%q, %q) = %q, %v; want %q", test.dir, test.name, got, err, test.want)
+		}
+	}
+}
+
+func TestTraversalURLsStayInOutputDirectory(t *testing.T) {
+	for _, rawURL := range []string{
+		"https://www.airgas.com/../../etc/passwd.pdf",
+		"https://www.airgas.com/msds/..%2F..%2Fescape.pdf",
+		"https://www.airgas.com/msds/%2e%2e/%2e%2e/escape.pdf",
+		"https://www.airgas.com/..\\..\\windows.pdf",
+		"https://www.airgas.com/msds/x.pdf?name=../../../escape",
+		"https://../../escape.pdf",
+	} {
+		name := urlToFilename(rawURL, QueryNaming{Mode: "keep"}, defaultTemplate)
+		if strings.ContainsAny(name, `/\`) {
+			t.Errorf("urlToFilename(%q) = %q, which has a path separator", rawURL, name)
+		}
+		if name != "" {
+			if _, err := safeJoin("PDFs", name); err != nil {
+				t.Errorf("urlToFilename(%q) = %q, which escapes: %v", rawURL, name, err)
+			}
+		}
+	}
+}
+
+func TestDownloadPDFRefusesEscapingFilename(t *testing.T) {
+	root := t.TempDir()
+	outputDir := filepath.Join(root, "out")
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("Content-Type", "application/pdf")
+		writer.Write([]byte("%PDF-1.7\n%%EOF\n"))
+	}))
+	defer server.Close()
+	config := testConfig(t)
+	config.OutputDir = outputDir
+	scraper := newTestScraper(t, config, server.Client())
+	createDirectory(osFS{}, outputDir, 0o755)
+	for _, filename := range []string{"../escape.pdf", "a/../../escape.pdf", filepath.Join(root, "escape.pdf")} {
+		scraper.downloadPDF(context.Background(), server.URL+"/escape.pdf", filename)
+	}
+	var written []string
+	filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err == nil && !entry.IsDir() {
+			written = append(written, path)
+		}
+		return err
+	})
+	if len(written) != 0 {
+		t.Errorf("files written: %v", written)
+	}
+	if failed := scraper.stats.Failed.Load(); failed != 3 {
+		t.Errorf("Failed = %d, want 3", failed)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"io"            // For general I/O primitives
+	"io/fs"         // For file information and modes
+	"os"            // For file and system operations
+	"path/filepath" // For matching file names
+)
+
+// File is an open file of an FS
+type File interface {
+	io.Reader
+	io.ReaderAt
+	io.Writer
+	io.Closer
+	Name() string               // Path the file was opened with
+	Stat() (fs.FileInfo, error) // Size and modification time
+	Sync() error                // Flush written bytes to stable storage
+	Truncate(size int64) error  // Cut or extend the file to size bytes
+}
+
+// FS is the filesystem every file the scraper reads or writes lives on, so a run can work
+// against something other than the real disk
+type FS interface {
+	Create(name string) (File, error)                               // Create or truncate a file for writing
+	CreateTemp(dir string, pattern string) (File, error)            // Create a new file with a unique name, like os.CreateTemp
+	Open(name string) (File, error)                                 // Open a file for reading
+	OpenFile(name string, flag int, perm fs.FileMode) (File, error) // Open a file with os.OpenFile flags
+	Stat(name string) (fs.FileInfo, error)                          // Describe a file or directory
+	Remove(name string) error                                       // Delete a file or empty directory
+	Rename(oldPath string, newPath string) error                    // Move a file, replacing any file at newPath
+	MkdirAll(path string, perm fs.FileMode) error                   // Create a directory and any missing parents
+	Glob(pattern string) ([]string, error)                          // Names matching a filepath.Match pattern, like filepath.Glob
+	WalkDir(root string, fn fs.WalkDirFunc) error                   // Visit root and everything below it, like filepath.WalkDir
+}
+
+// osFS is the FS backed by the os package
+type osFS struct{}
+
+// Create calls os.Create
+func (osFS) Create(name string) (File, error) { return os.Create(name) }
+
+// CreateTemp calls os.CreateTemp and gives the file the permissions os.Create would
+func (osFS) CreateTemp(dir string, pattern string) (File, error) {
+	file, err := os.CreateTemp(dir, pattern)
+	if err != nil {
+		return nil, err
+	}
+	if err := file.Chmod(0o644); err != nil { // os.CreateTemp uses 0600
+		file.Close()
+		os.Remove(file.Name())
+		return nil, err
+	}
+	return file, nil
+}
+
+// Open calls os.Open
+func (osFS) Open(name string) (File, error) { return os.Open(name) }
+
+// OpenFile calls os.OpenFile
+func (osFS) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	return os.OpenFile(name, flag, perm)
+}
+
+// Stat calls os.Stat
+func (osFS) Stat(name string) (fs.FileInfo, error) { return os.Stat(name) }
+
+// Remove calls os.Remove
+func (osFS) Remove(name string) error { return os.Remove(name) }
+
+// Rename calls os.Rename
+func (osFS) Rename(oldPath string, newPath string) error { return os.Rename(oldPath, newPath) }
+
+// MkdirAll calls os.MkdirAll
+func (osFS) MkdirAll(path string, perm fs.FileMode) error { return os.MkdirAll(path, perm) }
+
+// Glob calls filepath.Glob
+func (osFS) Glob(pattern string) ([]string, error) { return filepath.Glob(pattern) }
+
+// WalkDir calls filepath.WalkDir
+func (osFS) WalkDir(root string, fn fs.WalkDirFunc) error { return filepath.WalkDir(root, fn) }
+
+// fileSystem returns config's FS, or the real disk when none is set
+func (config Config) fileSystem() FS {
+	if config.FS == nil {
+		return osFS{}
+	}
+	return config.FS
+}
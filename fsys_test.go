@@ -0,0 +1,613 @@
+package main
+
+import (
+	"bytes"         // For building file contents
+	"context"       // For the write helpers' contexts
+	"crypto/sha256" // For expected checksums
+	"encoding/hex"  // For expected checksums
+	"encoding/json" // For decoding written files
+	"errors"        // For inspecting error values
+	"fmt"           // For temp file names
+	"io"            // For general I/O primitives
+	"io/fs"         // For file information and modes
+	"net/http"      // For range request headers
+	"os"            // For open flags
+	"path/filepath" // For cleaning and matching names
+	"slices"        // For sorting globbed names
+	"strings"       // For temp file patterns
+	"sync"          // For guarding the files
+	"testing"       // For the test framework
+	"time"          // For modification times
+)
+
+// memFS is an in-memory FS; paths are cleaned, and files and directories live in flat maps
+type memFS struct {
+	mutex      sync.Mutex
+	files      map[string]*memNode // File contents by path
+	dirs       map[string]bool     // Directories that exist
+	temps      int                 // Temp files created, for unique names
+	failWrites error               // Returned by every Write when set, like a failing disk
+}
+
+// memNode is the content of one memFS file
+type memNode struct {
+	data    []byte
+	modTime time.Time
+	mode    fs.FileMode
+}
+
+// newMemFS returns an empty memFS, holding only the current and root directories
+func newMemFS() *memFS {
+	return &memFS{files: make(map[string]*memNode), dirs: map[string]bool{".": true, "/": true}}
+}
+
+// pathError builds the *fs.PathError the os package would return
+func pathError(op string, name string, err error) error {
+	return &fs.PathError{Op: op, Path: name, Err: err}
+}
+
+// parentExists reports whether the directory holding name exists; the caller holds the mutex
+func (memory *memFS) parentExists(name string) bool {
+	return memory.dirs[filepath.Dir(name)]
+}
+
+// Create creates or truncates name
+func (memory *memFS) Create(name string) (File, error) {
+	return memory.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o666)
+}
+
+// CreateTemp creates a new file in dir, replacing the last * of pattern with a unique number
+func (memory *memFS) CreateTemp(dir string, pattern string) (File, error) {
+	memory.mutex.Lock()
+	memory.temps++
+	number := memory.temps
+	memory.mutex.Unlock()
+	name := pattern + fmt.Sprint(number)
+	if index := strings.LastIndex(pattern, "*"); index >= 0 {
+		name = pattern[:index] + fmt.Sprint(number) + pattern[index+1:]
+	}
+	return memory.OpenFile(filepath.Join(dir, name), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o644)
+}
+
+// Open opens name for reading
+func (memory *memFS) Open(name string) (File, error) {
+	return memory.OpenFile(name, os.O_RDONLY, 0)
+}
+
+// OpenFile opens name with os.OpenFile flags
+func (memory *memFS) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+	clean := filepath.Clean(name)
+	node, exists := memory.files[clean]
+	switch {
+	case memory.dirs[clean]:
+		return nil, pathError("open", name, errors.New("is a directory"))
+	case exists && flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0:
+		return nil, pathError("open", name, fs.ErrExist)
+	case !exists && flag&os.O_CREATE == 0:
+		return nil, pathError("open", name, fs.ErrNotExist)
+	case !exists && !memory.parentExists(clean):
+		return nil, pathError("open", name, fs.ErrNotExist)
+	case !exists:
+		node = &memNode{modTime: time.Now(), mode: perm}
+		memory.files[clean] = node
+	}
+	writable := flag&(os.O_WRONLY|os.O_RDWR) != 0
+	if writable && flag&os.O_TRUNC != 0 {
+		node.data, node.modTime = nil, time.Now()
+	}
+	return &memFile{memory: memory, name: name, node: node, readable: flag&os.O_WRONLY == 0, writable: writable, append: flag&os.O_APPEND != 0}, nil
+}
+
+// Stat describes name
+func (memory *memFS) Stat(name string) (fs.FileInfo, error) {
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+	clean := filepath.Clean(name)
+	if memory.dirs[clean] {
+		return memInfo{name: filepath.Base(clean), mode: fs.ModeDir | 0o755}, nil
+	}
+	node, ok := memory.files[clean]
+	if !ok {
+		return nil, pathError("stat", name, fs.ErrNotExist)
+	}
+	return memInfo{name: filepath.Base(clean), size: int64(len(node.data)), mode: node.mode, modTime: node.modTime}, nil
+}
+
+// Remove deletes a file or an empty directory
+func (memory *memFS) Remove(name string) error {
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+	clean := filepath.Clean(name)
+	if _, ok := memory.files[clean]; ok {
+		delete(memory.files, clean)
+		return nil
+	}
+	if !memory.dirs[clean] {
+		return pathError("remove", name, fs.ErrNotExist)
+	}
+	for other := range memory.files {
+		if filepath.Dir(other) == clean {
+			return pathError("remove", name, errors.New("directory not empty"))
+		}
+	}
+	for other := range memory.dirs {
+		if other != clean && filepath.Dir(other) == clean {
+			return pathError("remove", name, errors.New("directory not empty"))
+		}
+	}
+	delete(memory.dirs, clean)
+	return nil
+}
+
+// Rename moves a file, replacing any file at newPath
+func (memory *memFS) Rename(oldPath string, newPath string) error {
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+	oldClean, newClean := filepath.Clean(oldPath), filepath.Clean(newPath)
+	node, ok := memory.files[oldClean]
+	if !ok {
+		return &os.LinkError{Op: "rename", Old: oldPath, New: newPath, Err: fs.ErrNotExist}
+	}
+	if !memory.parentExists(newClean) || memory.dirs[newClean] {
+		return &os.LinkError{Op: "rename", Old: oldPath, New: newPath, Err: fs.ErrNotExist}
+	}
+	delete(memory.files, oldClean)
+	memory.files[newClean] = node
+	return nil
+}
+
+// MkdirAll creates path and any missing parents
+func (memory *memFS) MkdirAll(path string, perm fs.FileMode) error {
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+	for dir := filepath.Clean(path); !memory.dirs[dir]; dir = filepath.Dir(dir) {
+		if _, isFile := memory.files[dir]; isFile {
+			return pathError("mkdir", dir, errors.New("not a directory"))
+		}
+		memory.dirs[dir] = true
+	}
+	return nil
+}
+
+// Glob returns the sorted file and directory names matching pattern
+func (memory *memFS) Glob(pattern string) ([]string, error) {
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+	var matches []string
+	for name := range memory.files {
+		if matched, _ := filepath.Match(pattern, name); matched {
+			matches = append(matches, name)
+		}
+	}
+	for name := range memory.dirs {
+		if matched, _ := filepath.Match(pattern, name); matched {
+			matches = append(matches, name)
+		}
+	}
+	slices.Sort(matches)
+	return matches, nil
+}
+
+// WalkDir visits root and everything below it in lexical order, like filepath.WalkDir
+func (memory *memFS) WalkDir(root string, fn fs.WalkDirFunc) error {
+	info, err := memory.Stat(root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		err = memory.walk(root, fs.FileInfoToDirEntry(info), fn)
+	}
+	if errors.Is(err, fs.SkipDir) || errors.Is(err, fs.SkipAll) {
+		return nil
+	}
+	return err
+}
+
+// walk calls fn for path, then for everything below it when it's a directory
+func (memory *memFS) walk(path string, entry fs.DirEntry, fn fs.WalkDirFunc) error {
+	if err := fn(path, entry, nil); err != nil || !entry.IsDir() {
+		if errors.Is(err, fs.SkipDir) && entry.IsDir() {
+			return nil // Skip this directory only
+		}
+		return err
+	}
+	memory.mutex.Lock()
+	clean := filepath.Clean(path)
+	var children []string
+	for name := range memory.files {
+		if filepath.Dir(name) == clean {
+			children = append(children, filepath.Base(name))
+		}
+	}
+	for name := range memory.dirs {
+		if name != clean && filepath.Dir(name) == clean {
+			children = append(children, filepath.Base(name))
+		}
+	}
+	memory.mutex.Unlock()
+	slices.Sort(children)
+	for _, child := range children {
+		childPath := filepath.Join(path, child)
+		info, err := memory.Stat(childPath)
+		if err != nil {
+			continue // Removed while walking
+		}
+		if err := memory.walk(childPath, fs.FileInfoToDirEntry(info), fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// contents returns the content of the file at name, and whether it exists
+func (memory *memFS) contents(name string) ([]byte, bool) {
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+	node, ok := memory.files[filepath.Clean(name)]
+	if !ok {
+		return nil, false
+	}
+	return slices.Clone(node.data), true
+}
+
+// names returns the sorted paths of every file
+func (memory *memFS) names() []string {
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+	var names []string
+	for name := range memory.files {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// memFile is an open memFS file
+type memFile struct {
+	memory   *memFS
+	name     string
+	node     *memNode
+	offset   int64 // Position of the next Read or Write
+	readable bool
+	writable bool
+	append   bool // Writes go to the end, as with os.O_APPEND
+	closed   bool
+}
+
+// Name returns the path the file was opened with
+func (file *memFile) Name() string { return file.name }
+
+// Read reads from the current position
+func (file *memFile) Read(p []byte) (int, error) {
+	n, err := file.ReadAt(p, file.offset)
+	file.offset += int64(n)
+	return n, err
+}
+
+// ReadAt reads from offset without moving the position
+func (file *memFile) ReadAt(p []byte, offset int64) (int, error) {
+	file.memory.mutex.Lock()
+	defer file.memory.mutex.Unlock()
+	if file.closed || !file.readable {
+		return 0, pathError("read", file.name, fs.ErrPermission)
+	}
+	if offset >= int64(len(file.node.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, file.node.data[offset:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Write writes at the current position, or at the end in append mode
+func (file *memFile) Write(p []byte) (int, error) {
+	file.memory.mutex.Lock()
+	defer file.memory.mutex.Unlock()
+	if file.closed || !file.writable {
+		return 0, pathError("write", file.name, fs.ErrPermission)
+	}
+	if file.memory.failWrites != nil {
+		return 0, pathError("write", file.name, file.memory.failWrites)
+	}
+	if file.append {
+		file.offset = int64(len(file.node.data))
+	}
+	if end := file.offset + int64(len(p)); end > int64(len(file.node.data)) {
+		file.node.data = append(file.node.data, make([]byte, end-int64(len(file.node.data)))...)
+	}
+	copy(file.node.data[file.offset:], p)
+	file.offset += int64(len(p))
+	file.node.modTime = time.Now()
+	return len(p), nil
+}
+
+// Close closes the file
+func (file *memFile) Close() error {
+	file.memory.mutex.Lock()
+	defer file.memory.mutex.Unlock()
+	if file.closed {
+		return pathError("close", file.name, fs.ErrClosed)
+	}
+	file.closed = true
+	return nil
+}
+
+// Stat describes the file
+func (file *memFile) Stat() (fs.FileInfo, error) {
+	file.memory.mutex.Lock()
+	defer file.memory.mutex.Unlock()
+	return memInfo{name: filepath.Base(file.name), size: int64(len(file.node.data)), mode: file.node.mode, modTime: file.node.modTime}, nil
+}
+
+// Sync does nothing; memory is as stable as it gets
+func (file *memFile) Sync() error { return nil }
+
+// Truncate cuts or zero-extends the file to size bytes
+func (file *memFile) Truncate(size int64) error {
+	file.memory.mutex.Lock()
+	defer file.memory.mutex.Unlock()
+	if file.closed || !file.writable {
+		return pathError("truncate", file.name, fs.ErrPermission)
+	}
+	if size < int64(len(file.node.data)) {
+		file.node.data = file.node.data[:size]
+	} else {
+		file.node.data = append(file.node.data, make([]byte, size-int64(len(file.node.data)))...)
+	}
+	file.node.modTime = time.Now()
+	return nil
+}
+
+// memInfo is the fs.FileInfo of a memFS file or directory
+type memInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func (info memInfo) Name() string       { return info.name }
+func (info memInfo) Size() int64        { return info.size }
+func (info memInfo) Mode() fs.FileMode  { return info.mode }
+func (info memInfo) ModTime() time.Time { return info.modTime }
+func (info memInfo) IsDir() bool        { return info.mode.IsDir() }
+func (info memInfo) Sys() any           { return nil }
+
+// checksumOf returns the hex SHA-256 of data
+func checksumOf(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// failingReader returns its data, then err
+type failingReader struct {
+	data []byte
+	err  error
+}
+
+// Read returns the data once, then the error
+func (reader *failingReader) Read(p []byte) (int, error) {
+	if len(reader.data) == 0 {
+		return 0, reader.err
+	}
+	n := copy(p, reader.data)
+	reader.data = reader.data[n:]
+	return n, nil
+}
+
+func TestMemFSFileHelpers(t *testing.T) {
+	memory := newMemFS()
+	if err := createDirectory(memory, "out/ab", 0o755); err != nil {
+		t.Fatalf("createDirectory: %v", err)
+	}
+	if !directoryExists(memory, "out") || !directoryExists(memory, "out/ab") {
+		t.Fatal("directories missing after createDirectory")
+	}
+	if err := writeFile(memory, "out/ab/a.txt", []byte("one"), 0o644); err != nil {
+		t.Fatalf("writeFile: %v", err)
+	}
+	if err := appendByteToFile(memory, "out/ab/a.txt", []byte(" two")); err != nil {
+		t.Fatalf("appendByteToFile: %v", err)
+	}
+	if content, err := readFileAndReturnAsString(memory, "out/ab/a.txt"); err != nil || content != "one two" {
+		t.Fatalf("read back %q, %v", content, err)
+	}
+	if err := moveFile(memory, "out/ab/a.txt", "out/b.txt"); err != nil {
+		t.Fatalf("moveFile: %v", err)
+	}
+	if fileExists(memory, "out/ab/a.txt") || !fileExists(memory, "out/b.txt") {
+		t.Fatalf("files after move: %v", memory.names())
+	}
+	if err := writeFile(memory, "missing/c.txt", nil, 0o644); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("writing into a missing directory: %v, want fs.ErrNotExist", err)
+	}
+}
+
+func TestWriteTempFileAndStaleCleanup(t *testing.T) {
+	memory := newMemFS()
+	createDirectory(memory, "out/ab", 0o755)
+	body := []byte("%PDF-1.4 body")
+	tempPath, written, checksum, err := writeTempFile(context.Background(), memory, "out/ab", "doc.pdf", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("writeTempFile: %v", err)
+	}
+	if written != int64(len(body)) || checksum != checksumOf(body) || !strings.HasSuffix(tempPath, tempFileSuffix) {
+		t.Fatalf("writeTempFile = %s, %d, %s", tempPath, written, checksum)
+	}
+	writeFile(memory, "out/stale"+tempFileSuffix, []byte("x"), 0o644)
+	writeFile(memory, "out/keep.pdf", []byte("%PDF-"), 0o644)
+	removeStaleTempFiles(memory, "out")
+	if names := memory.names(); !slices.Equal(names, []string{"out/keep.pdf"}) {
+		t.Fatalf("files after removeStaleTempFiles: %v", names)
+	}
+}
+
+func TestWriteTempFileRemovesFailedWrite(t *testing.T) {
+	memory := newMemFS()
+	createDirectory(memory, "out", 0o755)
+	reader := &failingReader{data: []byte("%PDF-1.4 half"), err: io.ErrUnexpectedEOF}
+	if _, _, _, err := writeTempFile(context.Background(), memory, "out", "doc.pdf", reader); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("err = %v, want io.ErrUnexpectedEOF", err)
+	}
+	if names := memory.names(); len(names) != 0 {
+		t.Fatalf("a failed write left %v behind", names)
+	}
+}
+
+func TestResumePartialDownload(t *testing.T) {
+	memory := newMemFS()
+	createDirectory(memory, "out", 0o755)
+	ctx := context.Background()
+	document := []byte("%PDF-1.4 the first half, and the second half of the document")
+	state := partialState{ETag: `"v1"`, LastModified: "Fri, 01 Mar 2024 12:00:00 GMT"}
+
+	interrupted := &failingReader{data: document[:20], err: io.ErrUnexpectedEOF}
+	if _, _, _, err := writePartialFile(ctx, memory, "out/doc.pdf", 0, state, interrupted); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("interrupted write: %v, want io.ErrUnexpectedEOF", err)
+	}
+	if kept, ok := memory.contents("out/doc.pdf" + partialSuffix); !ok || !bytes.Equal(kept, document[:20]) {
+		t.Fatalf(".part file = %q, want the first 20 bytes", kept)
+	}
+
+	header := make(http.Header)
+	offset := resumeOffset(memory, "out/doc.pdf", header)
+	if offset != 20 || header.Get("Range") != "bytes=20-" || header.Get("If-Range") != `"v1"` {
+		t.Fatalf("resumeOffset = %d, Range %q, If-Range %q", offset, header.Get("Range"), header.Get("If-Range"))
+	}
+
+	partPath, size, checksum, err := writePartialFile(ctx, memory, "out/doc.pdf", offset, state, bytes.NewReader(document[20:]))
+	if err != nil {
+		t.Fatalf("resumed write: %v", err)
+	}
+	if size != int64(len(document)) || checksum != checksumOf(document) {
+		t.Fatalf("resumed write = %d bytes, %s; want %d, %s", size, checksum, len(document), checksumOf(document))
+	}
+	if content, _ := memory.contents(partPath); !bytes.Equal(content, document) {
+		t.Fatalf("resumed file = %q", content)
+	}
+}
+
+func TestResumeOffsetDiscardsUnsafePartials(t *testing.T) {
+	tests := []struct {
+		name  string
+		part  string
+		state string // "" for no state sidecar
+	}{
+		{"no state sidecar", "%PDF-1.4 half", ""},
+		{"weak etag only", "%PDF-1.4 half", `{"etag":"W/\"v1\""}`},
+		{"not a pdf", "<html>error</html>", `{"etag":"\"v1\""}`},
+		{"empty part", "", `{"etag":"\"v1\""}`},
+		{"unreadable state", "%PDF-1.4 half", "{"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			memory := newMemFS()
+			createDirectory(memory, "out", 0o755)
+			writeFile(memory, "out/doc.pdf"+partialSuffix, []byte(test.part), 0o644)
+			if test.state != "" {
+				writeFile(memory, "out/doc.pdf"+partialStateSuffix, []byte(test.state), 0o644)
+			}
+			header := make(http.Header)
+			if offset := resumeOffset(memory, "out/doc.pdf", header); offset != 0 || len(header) != 0 {
+				t.Fatalf("resumeOffset = %d with headers %v, want 0 and none", offset, header)
+			}
+			if names := memory.names(); len(names) != 0 {
+				t.Fatalf("partial files left behind: %v", names)
+			}
+		})
+	}
+}
+
+func TestResumeOffsetFallsBackToLastModified(t *testing.T) {
+	memory := newMemFS()
+	createDirectory(memory, "out", 0o755)
+	writeFile(memory, "out/doc.pdf"+partialSuffix, []byte("%PDF-1.4 half"), 0o644)
+	writeFile(memory, "out/doc.pdf"+partialStateSuffix, []byte(`{"etag":"W/\"v1\"","last_modified":"Fri, 01 Mar 2024 12:00:00 GMT"}`), 0o644)
+	header := make(http.Header)
+	if offset := resumeOffset(memory, "out/doc.pdf", header); offset != 13 || header.Get("If-Range") != "Fri, 01 Mar 2024 12:00:00 GMT" {
+		t.Fatalf("resumeOffset = %d, If-Range %q", offset, header.Get("If-Range"))
+	}
+}
+
+func TestManifestWriteFile(t *testing.T) {
+	memory := newMemFS()
+	createDirectory(memory, "out", 0o755)
+	recorder, err := newManifestRecorder(memory, "out/"+manifestFileName, &localStorage{fs: memory, dir: "out"}, trackingParams)
+	if err != nil {
+		t.Fatalf("newManifestRecorder: %v", err)
+	}
+	downloaded := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	recorder.Record(ManifestEntry{SourceURL: "https://www.airgas.com/msds/b.pdf", FinalURL: "https://www.airgas.com/msds/b.pdf", Filename: "b.pdf", Size: 10, SHA256: "bb", DownloadedAt: downloaded})
+	recorder.Record(ManifestEntry{SourceURL: "https://www.airgas.com/msds/a.pdf", FinalURL: "https://www.airgas.com/msds/a.pdf", Filename: "a.pdf", Size: 20, SHA256: "aa", DownloadedAt: downloaded, ETag: `"e"`})
+	if err := recorder.WriteFile("out/" + manifestFileName); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	content, ok := memory.contents("out/" + manifestFileName)
+	if !ok {
+		t.Fatal("manifest wasn't written")
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(content, &manifest); err != nil {
+		t.Fatalf("decoding manifest: %v", err)
+	}
+	if len(manifest.Entries) != 2 || manifest.Entries[0].Filename != "a.pdf" || manifest.Entries[1].Filename != "b.pdf" {
+		t.Fatalf("manifest entries = %+v, want a.pdf then b.pdf", manifest.Entries)
+	}
+	if entry := manifest.Entries[0]; entry.ETag != `"e"` || entry.Size != 20 || !entry.DownloadedAt.Equal(downloaded) {
+		t.Fatalf("a.pdf entry = %+v", entry)
+	}
+}
+
+func TestMirrorChecksUseTheGivenFS(t *testing.T) {
+	memory := newMemFS()
+	createDirectory(memory, "out/b", 0o755)
+	writeFile(memory, "out/a.pdf", []byte("%PDF-a"), 0o644)
+	writeFile(memory, "out/b/copy.pdf", []byte("%PDF-a"), 0o644)
+	writeFile(memory, "out/broken.pdf", []byte("<html>"), 0o644)
+	manifest, _ := json.Marshal(Manifest{Entries: []ManifestEntry{{Filename: "a.pdf", Size: 6, SHA256: checksumOf([]byte("%PDF-a"))}}})
+	writeFile(memory, "out/"+manifestFileName, manifest, 0o644)
+	ignored := []string{manifestFileName}
+
+	report, err := verifyManifest(memory, "out/"+manifestFileName, "out", ignored)
+	if err != nil || report.Checked != 1 || !slices.Equal(report.Extra, []string{"b/copy.pdf", "broken.pdf"}) {
+		t.Fatalf("verifyManifest = %+v, %v", report, err)
+	}
+	clusters, err := findDuplicates(memory, "out/"+manifestFileName, "out", ignored)
+	if err != nil || len(clusters) != 1 || len(clusters[0].Files) != 2 {
+		t.Fatalf("findDuplicates = %+v, %v", clusters, err)
+	}
+	validated, err := validateExisting(memory, "out/"+manifestFileName, "out", ignored, 0, true, false)
+	if err != nil || validated.Checked != 2 || validated.Repaired != 1 || fileExists(memory, "out/broken.pdf") {
+		t.Fatalf("validateExisting = %+v, %v; files %v", validated, err, memory.names())
+	}
+
+	lock, err := lockDirectory(memory, "out")
+	if err != nil {
+		t.Fatalf("lockDirectory: %v", err)
+	}
+	if holder := lockHolder(memory, "out/"+lockFileName); holder != os.Getpid() {
+		t.Errorf("lock holder = %d, want %d", holder, os.Getpid())
+	}
+	lock.Release()
+
+	logFile, err := newRotatingFile(memory, "logs/run.log", 10, 0, 1)
+	if err != nil {
+		t.Fatalf("newRotatingFile: %v", err)
+	}
+	for _, line := range []string{"first\n", "second\n", "third\n"} {
+		logFile.Write([]byte(line))
+	}
+	logFile.Close()
+	if content, _ := memory.contents("logs/run.log"); string(content) != "third\n" {
+		t.Errorf("current log = %q, want the last line", content)
+	}
+	if rotated, _ := memory.Glob("logs/run.log.*"); len(rotated) != 1 {
+		t.Errorf("rotated logs = %v, want only the newest kept", rotated)
+	}
+}
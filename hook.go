@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"       // For cancelling in-flight work
+	"errors"        // For creating error values
+	"fmt"           // For formatted I/O operations
+	"log/slog"      // For structured, levelled logging
+	"os/exec"       // For running the hook command
+	"strings"       // For string manipulation
+	"text/template" // For substituting the hook's arguments
+	"time"          // For time-related operations
+)
+
+// HookFields are the values a -on-download command can use, such as {{.Path}}
+type HookFields struct {
+	Path     string // Path of the saved file
+	URL      string // URL the document was downloaded from
+	Filename string // Path of the file relative to the output directory
+	Size     int64  // Size of the file in bytes
+	SHA256   string // Hex-encoded SHA-256 checksum of the file
+}
+
+// maxHookOutput is how much of a hook's output is logged
+const maxHookOutput = 4 << 10
+
+// parseHookCommand splits a -on-download value on whitespace into the program and its
+// arguments, each a template, so a substituted path containing spaces stays one argument; no
+// shell is involved
+func parseHookCommand(text string) ([]*template.Template, error) {
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return nil, errors.New("invalid -on-download: empty command")
+	}
+	args := make([]*template.Template, len(fields))
+	for i, field := range fields {
+		arg, err := template.New("hook").Option("missingkey=error").Parse(field)
+		if err == nil {
+			err = arg.Execute(&strings.Builder{}, HookFields{}) // Such as an unknown field
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid -on-download: %w", err)
+		}
+		args[i] = arg
+	}
+	return args, nil
+}
+
+// runHook runs the -on-download command for a saved file, waiting for one of the
+// config.HookConcurrency slots first, logs its output and returns an error if it couldn't be run
+// or exited non-zero
+func (scraper *Scraper) runHook(ctx context.Context, fields HookFields) error {
+	args := make([]string, len(scraper.config.OnDownload))
+	for i, arg := range scraper.config.OnDownload {
+		var rendered strings.Builder
+		if err := arg.Execute(&rendered, fields); err != nil {
+			return err
+		}
+		args[i] = rendered.String()
+	}
+	select {
+	case scraper.hookSlots <- struct{}{}: // Bound the number of hook processes
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-scraper.hookSlots }()
+
+	start := time.Now()
+	output, err := exec.CommandContext(ctx, args[0], args[1:]...).CombinedOutput() // Killed if the run is cancelled
+	text := strings.TrimSpace(string(output))
+	if len(text) > maxHookOutput {
+		text = text[:maxHookOutput] + "…" // Keep the log readable
+	}
+	if err != nil {
+		slog.Warn("Download hook failed", "path", fields.Path, "command", args, "error", err, "output", text)
+		return err
+	}
+	slog.Info("Download hook finished", "path", fields.Path, "command", args, "duration", time.Since(start), "output", text)
+	return nil
+}
@@ -0,0 +1,506 @@
+package main
+
+import (
+	"bufio"          // For peeking at response bodies
+	"bytes"          // For request bodies
+	"compress/flate" // For raw deflate response bodies
+	"compress/gzip"  // For gzip response bodies
+	"compress/zlib"  // For zlib-wrapped deflate response bodies
+	"context"        // For cancelling in-flight work
+	"crypto/tls"     // For TLS client settings
+	"errors"         // For combining error values
+	"fmt"            // For formatted I/O operations
+	"io"             // For general I/O primitives
+	"log/slog"       // For structured, levelled logging
+	"math"           // For rounding logged rates
+	"math/rand"      // For adding jitter to retry delays
+	"net"            // For dialing connections
+	"net/http"       // For making HTTP requests
+	"slices"         // For searching slices
+	"strconv"        // For parsing numeric header values
+	"strings"        // For string manipulation
+	"sync"           // For handling concurrency
+	"time"           // For time-related operations
+)
+
+// rateLimiter spaces requests evenly so they never exceed a rate; with adaptive set the rate
+// halves when the server pushes back and creeps back up after sustained success. It is safe
+// for concurrent use
+type rateLimiter struct {
+	clock     Clock         // Time source for the slots
+	mutex     sync.Mutex    // Guards next, interval, successes and slowedAt
+	interval  time.Duration // Minimum gap between two requests
+	next      time.Time     // Earliest time the next request may start
+	adaptive  bool          // Whether 429/503 responses slow the limiter down
+	base      time.Duration // Configured gap, which recovery never goes below
+	successes int           // Successful responses since the last adjustment
+	slowedAt  time.Time     // When the rate was last decreased
+}
+
+// Adaptive throttle tuning: the gap doubles on pushback, at most once per throttleCooldown and up
+// to throttleMaxFactor times the configured one, and after throttleRecoverAfter successes the
+// rate grows by a tenth of the configured rate
+const (
+	throttleCooldown     = time.Second
+	throttleMaxFactor    = 64
+	throttleRecoverAfter = 20
+)
+
+// newRateLimiter creates a limiter allowing requestsPerSecond requests, timed by clock; it returns nil (unlimited) for rates <= 0
+func newRateLimiter(requestsPerSecond float64, clock Clock) *rateLimiter {
+	if requestsPerSecond <= 0 {
+		return nil // No limit requested
+	}
+	interval := time.Duration(float64(time.Second) / requestsPerSecond) // Gap between requests
+	return &rateLimiter{clock: clock, interval: interval, base: interval}
+}
+
+// rps converts a gap between requests to requests per second
+func rps(interval time.Duration) float64 {
+	return float64(time.Second) / float64(interval)
+}
+
+// roundRPS returns the rate of a gap between requests rounded for logging
+func roundRPS(interval time.Duration) float64 {
+	return math.Round(rps(interval)*100) / 100
+}
+
+// observe adjusts an adaptive limiter to a response status: 429 and 503 halve the rate, and a
+// run of successes adds back a tenth of the configured rate
+func (limiter *rateLimiter) observe(statusCode int) {
+	if limiter == nil || !limiter.adaptive {
+		return
+	}
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	switch {
+	case statusCode == http.StatusTooManyRequests || statusCode == http.StatusServiceUnavailable:
+		limiter.successes = 0
+		now := limiter.clock.Now()
+		if now.Sub(limiter.slowedAt) < throttleCooldown || limiter.interval >= limiter.base*throttleMaxFactor {
+			return // Concurrent requests report the same pushback; slow down once
+		}
+		previous := limiter.interval
+		limiter.interval = min(limiter.interval*2, limiter.base*throttleMaxFactor)
+		limiter.slowedAt = now
+		slog.Warn("Server is pushing back, lowering the request rate", "status", statusCode, "from_rps", roundRPS(previous), "to_rps", roundRPS(limiter.interval))
+	case statusCode < 400:
+		if limiter.interval <= limiter.base {
+			return // Already at the configured rate
+		}
+		if limiter.successes++; limiter.successes < throttleRecoverAfter {
+			return
+		}
+		limiter.successes = 0
+		previous := limiter.interval
+		limiter.interval = max(time.Duration(float64(time.Second)/(rps(previous)+rps(limiter.base)/10)), limiter.base)
+		slog.Info("Requests are succeeding, raising the request rate", "from_rps", roundRPS(previous), "to_rps", roundRPS(limiter.interval))
+	}
+}
+
+// Wait blocks until the caller may send its next request or ctx is cancelled
+func (limiter *rateLimiter) Wait(ctx context.Context) error {
+	if limiter == nil {
+		return ctx.Err() // Unlimited: only honor cancellation
+	}
+	limiter.mutex.Lock()
+	now := limiter.clock.Now()
+	if limiter.next.Before(now) {
+		limiter.next = now // Limiter was idle, the slot is free right away
+	}
+	wait := limiter.next.Sub(now)                     // Time until our reserved slot
+	limiter.next = limiter.next.Add(limiter.interval) // Reserve the following slot for the next caller
+	limiter.mutex.Unlock()
+
+	if wait <= 0 {
+		return ctx.Err() // Slot available immediately
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err() // Run cancelled while waiting
+	case <-limiter.clock.After(wait): // Sleep until the reserved slot
+		return nil // Slot reached
+	}
+}
+
+// retryBaseDelay is the wait before the first retry; it doubles on every further attempt
+const retryBaseDelay = 500 * time.Millisecond
+
+// maxRetryDelay caps the backoff before jitter, however many retries -retries allows
+const maxRetryDelay = time.Minute
+
+// isRetryableStatus reports whether a response status is worth retrying
+func isRetryableStatus(statusCode int) bool {
+	return statusCode == http.StatusTooManyRequests || statusCode >= 500 // Rate limited or server error
+}
+
+// parseRetryAfter reads a Retry-After header given either in seconds or as an HTTP date, which is
+// measured from now
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false // Header not present
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true // Delay given in seconds
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(date.Sub(now), 0), true // Delay given as an absolute date
+	}
+	return 0, false // Unparseable header
+}
+
+// retryDelay returns how long to wait, from now, before the given retry attempt
+func retryDelay(attempt int, response *http.Response, now time.Time) time.Duration {
+	if response != nil && response.StatusCode == http.StatusTooManyRequests {
+		if delay, ok := parseRetryAfter(response.Header.Get("Retry-After"), now); ok {
+			return delay // Honor the server's requested delay
+		}
+	}
+	backoff := min(retryBaseDelay<<min(attempt, 20), maxRetryDelay) // Exponential backoff, shifted little enough not to overflow
+	jitter := time.Duration(rand.Int63n(int64(backoff)/2 + 1))      // Random jitter of up to half the backoff
+	return backoff + jitter
+}
+
+// Connection set-up timeouts shared by every request
+const (
+	dialTimeout         = 30 * time.Second // Time to open a TCP connection
+	tlsHandshakeTimeout = 10 * time.Second // Time to complete a TLS handshake
+)
+
+// newHTTPClient creates the single HTTP client shared by every request of a run; its transport
+// keeps idle connections to airgas.com alive so workers reuse them instead of redoing TLS handshakes.
+// The transport bounds each connection phase; the wait for headers and stalled bodies are bounded
+// per request by httpDoWithRetry, since pages and PDFs use different timeouts, and there is no
+// overall deadline so slow but steady downloads can finish
+func newHTTPClient(config Config) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()             // Start from Go's tuned defaults
+	dialer := &net.Dialer{Timeout: dialTimeout, KeepAlive: 30 * time.Second} // Give up on unreachable hosts
+	if config.DNSServer != "" {
+		dialer.Resolver = &net.Resolver{
+			PreferGo: true, // Only Go's resolver can be pointed at another server
+			Dial: func(ctx context.Context, network string, _ string) (net.Conn, error) {
+				return (&net.Dialer{Timeout: dialTimeout}).DialContext(ctx, network, config.DNSServer) // -dns-server instead of resolv.conf
+			},
+		}
+	}
+	transport.DialContext = dialContext(dialer, config.Resolve)
+	transport.TLSHandshakeTimeout = tlsHandshakeTimeout                                                      // Give up on stuck handshakes
+	transport.TLSClientConfig = &tls.Config{RootCAs: config.RootCAs, InsecureSkipVerify: config.InsecureTLS} // -ca-file and -insecure-skip-verify
+	if config.InsecureTLS {
+		slog.Warn("TLS certificate verification is DISABLED: any server, or anyone in between, can impersonate airgas.com") // Never silently insecure
+	}
+	transport.ResponseHeaderTimeout = max(config.RequestTimeout, config.DownloadTimeout) // Backstop for the per-request header timeout
+	transport.Proxy = http.ProxyFromEnvironment                                          // Honor HTTP_PROXY/HTTPS_PROXY/NO_PROXY
+	if config.Proxy != nil {
+		transport.Proxy = http.ProxyURL(config.Proxy) // -proxy overrides the environment
+	}
+	transport.DisableKeepAlives = false                              // Keep connections open between requests
+	transport.DisableCompression = true                              // Bodies are decoded by decodeResponse, whatever -header asks for
+	workers := max(config.scrapeWorkers(), config.downloadWorkers()) // Requests of the busier phase
+	transport.MaxIdleConns = max(100, workers)                       // Idle pool across all hosts
+	transport.MaxIdleConnsPerHost = max(2, workers)                  // One idle connection per worker to the same host
+	if config.MaxIdleConns > 0 {
+		transport.MaxIdleConns = config.MaxIdleConns // -max-idle-conns overrides the default pool size
+		transport.MaxIdleConnsPerHost = min(transport.MaxIdleConnsPerHost, config.MaxIdleConns)
+	}
+	transport.MaxConnsPerHost = config.MaxConnsPerHost // 0 leaves connections per host unlimited
+	if config.MaxConnsPerHost > 0 {
+		transport.MaxIdleConnsPerHost = min(transport.MaxIdleConnsPerHost, config.MaxConnsPerHost) // Never more idle than open
+	}
+	transport.IdleConnTimeout = 90 * time.Second // Drop connections idle for too long
+	transport.ForceAttemptHTTP2 = config.HTTP2   // Negotiate HTTP/2 despite the custom TLS settings
+	if !config.HTTP2 {
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{} // HTTP/1.1 only
+	}
+	return &http.Client{Transport: transport, CheckRedirect: checkRedirect(config.SameHost, config.MaxRedirects)}
+}
+
+// dialContext returns a DialContext that connects through dialer, sending connections to hosts
+// listed in overrides to the pinned address; TLS still verifies the original host name
+func dialContext(dialer *net.Dialer, overrides map[string]string) func(ctx context.Context, network string, address string) (net.Conn, error) {
+	return func(ctx context.Context, network string, address string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(address)
+		if err == nil {
+			if pinned, ok := overrides[strings.ToLower(host)]; ok {
+				slog.Debug("Connecting to pinned address", "host", host, "address", pinned)
+				address = net.JoinHostPort(pinned, port) // -resolve
+			}
+		}
+		return dialer.DialContext(ctx, network, address)
+	}
+}
+
+// defaultMaxRedirects is the longest redirect chain followed without -max-redirects, matching
+// net/http's default
+const defaultMaxRedirects = 10
+
+// errTooManyRedirects is returned for redirect chains longer than -max-redirects
+var errTooManyRedirects = errors.New("too many redirects")
+
+// siteDomain is the domain whose hosts -same-host redirects may move between
+const siteDomain = "airgas.com"
+
+// errOffSiteRedirect is returned for redirects that -same-host refuses
+var errOffSiteRedirect = errors.New("redirect leaves " + siteDomain)
+
+// onSite reports whether host is siteDomain, one of its subdomains or originalHost
+func onSite(host string, originalHost string) bool {
+	host = strings.ToLower(host)
+	return host == siteDomain || strings.HasSuffix(host, "."+siteDomain) || host == strings.ToLower(originalHost)
+}
+
+// checkRedirect returns the client's redirect policy: every hop of the chain is logged, chains
+// longer than maxRedirects are refused and, when sameHost is set, so are hops that leave
+// airgas.com and the host originally requested
+func checkRedirect(sameHost bool, maxRedirects int) func(request *http.Request, via []*http.Request) error {
+	return func(request *http.Request, via []*http.Request) error {
+		chain := make([]string, 0, len(via)+1)
+		for _, previous := range via {
+			chain = append(chain, previous.URL.String()) // Every URL visited so far
+		}
+		chain = append(chain, request.URL.String())
+		if len(via) > maxRedirects {
+			slog.Warn("Refusing redirect beyond -max-redirects", "url", via[0].URL.String(), "target", request.URL.String(), "max_redirects", maxRedirects, "chain", chain)
+			return fmt.Errorf("%w: %s still redirected after %d hops (raise -max-redirects to follow more)", errTooManyRedirects, via[0].URL, maxRedirects)
+		}
+		if sameHost && !onSite(request.URL.Hostname(), via[0].URL.Hostname()) {
+			slog.Warn("Refusing off-site redirect", "url", via[0].URL.String(), "target", request.URL.String(), "chain", chain)
+			return errOffSiteRedirect
+		}
+		slog.Debug("Following redirect", "url", via[0].URL.String(), "target", request.URL.String(), "chain", chain)
+		return nil
+	}
+}
+
+// errHeaderTimeout and errStalled say why httpDoWithRetry aborted a request
+var (
+	errHeaderTimeout = errors.New("timed out waiting for response headers")
+	errStalled       = errors.New("response body stalled")
+)
+
+// stallBody aborts a response body once no bytes have arrived for the stall timeout, however
+// long the whole transfer takes, and releases the request's context once the body is closed
+type stallBody struct {
+	io.ReadCloser                         // The response body
+	ctx           context.Context         // The request's context
+	cancel        context.CancelCauseFunc // Cancels the request's context
+	timer         Timer                   // Fires after stall without progress; nil disables the check
+	stall         time.Duration           // Longest allowed gap between bytes
+}
+
+// newStallBody wraps body so it is aborted after stall without progress, timed by clock (0 never aborts)
+func newStallBody(body io.ReadCloser, ctx context.Context, cancel context.CancelCauseFunc, stall time.Duration, clock Clock) *stallBody {
+	wrapped := &stallBody{ReadCloser: body, ctx: ctx, cancel: cancel, stall: stall}
+	if stall > 0 {
+		wrapped.timer = clock.AfterFunc(stall, func() { cancel(errStalled) }) // Abort if nothing arrives
+	}
+	return wrapped
+}
+
+// Read reads from the body, restarting the stall timer whenever bytes arrive
+func (body *stallBody) Read(buffer []byte) (int, error) {
+	count, err := body.ReadCloser.Read(buffer)
+	if count > 0 && body.timer != nil {
+		body.timer.Reset(body.stall) // Progress: restart the stall clock
+	}
+	if err != nil && errors.Is(context.Cause(body.ctx), errStalled) {
+		err = fmt.Errorf("%w: no data for %s", errStalled, body.stall) // Say why the read failed
+	}
+	return count, err
+}
+
+// Close closes the body and then releases the request's context
+func (body *stallBody) Close() error {
+	if body.timer != nil {
+		body.timer.Stop() // No more reads to watch
+	}
+	err := body.ReadCloser.Close() // Close the real body
+	body.cancel(nil)               // Release the context
+	return err
+}
+
+// acceptEncoding lists the content encodings decodeResponse can undo
+const acceptEncoding = "gzip, deflate"
+
+// decodedBody is a decompressing response body that closes the encoded body with it
+type decodedBody struct {
+	io.Reader               // Decompressing reader over raw
+	raw       io.ReadCloser // The encoded response body
+}
+
+// Close closes the decompressor, if it needs closing, and the encoded body
+func (body *decodedBody) Close() error {
+	var err error
+	if closer, ok := body.Reader.(io.Closer); ok {
+		err = closer.Close() // Release the decompressor
+	}
+	return errors.Join(err, body.raw.Close())
+}
+
+// newDeflateReader reads a deflate body, which servers send either zlib-wrapped, as the spec
+// says, or as raw deflate data
+func newDeflateReader(body io.Reader) (io.Reader, error) {
+	buffered := bufio.NewReader(body)
+	header, err := buffered.Peek(2) // zlib streams start with a two-byte header
+	if err != nil {
+		return nil, err
+	}
+	if header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+		return zlib.NewReader(buffered) // Valid zlib header
+	}
+	return flate.NewReader(buffered), nil // Raw deflate
+}
+
+// decodeResponse replaces a gzip or deflate encoded response body with a decompressing one, so
+// callers always read the plain content
+func decodeResponse(response *http.Response) error {
+	encoding := strings.ToLower(strings.TrimSpace(response.Header.Get("Content-Encoding")))
+	if encoding == "" || encoding == "identity" || response.Request.Method == http.MethodHead ||
+		response.StatusCode == http.StatusNoContent || response.StatusCode == http.StatusNotModified {
+		return nil // Nothing to decode
+	}
+	var reader io.Reader
+	var err error
+	switch encoding {
+	case "gzip", "x-gzip":
+		reader, err = gzip.NewReader(response.Body)
+	case "deflate":
+		reader, err = newDeflateReader(response.Body)
+	default:
+		return fmt.Errorf("unsupported Content-Encoding %q", encoding) // We never asked for it
+	}
+	if err != nil {
+		return fmt.Errorf("decoding %s body: %w", encoding, err)
+	}
+	response.Body = &decodedBody{Reader: reader, raw: response.Body}
+	response.Header.Del("Content-Encoding") // The body is now plain
+	response.Header.Del("Content-Length")   // The encoded length no longer applies
+	response.ContentLength = -1
+	response.Uncompressed = true
+	return nil
+}
+
+// sensitiveHeaders are headers whose values are never logged
+var sensitiveHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "X-Api-Key"}
+
+// redactHeaders returns a copy of header that is safe to log, with the values of
+// sensitiveHeaders replaced
+func redactHeaders(header http.Header) http.Header {
+	redacted := header.Clone()
+	for _, key := range sensitiveHeaders {
+		if values := redacted.Values(key); len(values) > 0 {
+			redacted[http.CanonicalHeaderKey(key)] = slices.Repeat([]string{"[REDACTED]"}, len(values)) // Keep the count, hide the secrets
+		}
+	}
+	return redacted
+}
+
+// newRequest builds a method request carrying the configured User-Agent and custom headers,
+// followed by the per-request headers in header
+func newRequest(ctx context.Context, method string, uri string, header http.Header, body []byte, config Config) (*http.Request, error) {
+	var reader io.Reader // No body
+	if body != nil {
+		reader = bytes.NewReader(body) // Fresh for every attempt, and rewindable for redirects
+	}
+	request, err := http.NewRequestWithContext(ctx, method, uri, reader) // Build a cancellable request
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("User-Agent", config.UserAgent)    // Identify the scraper
+	request.Header.Set("Accept-Encoding", acceptEncoding) // Encodings decodeResponse understands
+	if config.BasicAuth != "" {
+		username, password, _ := strings.Cut(config.BasicAuth, ":")
+		request.SetBasicAuth(username, password) // -basic-auth credentials
+	} else if config.BearerToken != "" {
+		request.Header.Set("Authorization", "Bearer "+config.BearerToken) // -bearer-token credentials
+	}
+	for key, values := range config.Headers {
+		request.Header[key] = slices.Clone(values) // Add headers given with -header
+	}
+	for key, values := range header {
+		request.Header[key] = slices.Clone(values) // Add caller-supplied headers
+	}
+	return request, nil
+}
+
+// httpGetWithRetry sends a GET request through httpDoWithRetry
+func httpGetWithRetry(ctx context.Context, client *http.Client, limiter *rateLimiter, uri string, header http.Header, timeout time.Duration, config Config) (*http.Response, error) {
+	return httpDoWithRetry(ctx, client, limiter, http.MethodGet, uri, header, nil, timeout, config)
+}
+
+// httpDoWithRetry sends a method request with the given extra headers and body, which may be nil, retrying network errors and
+// 5xx/429 responses with backoff; every attempt waits for the shared rate limiter first and must
+// receive its response headers within timeout, after which the body is only aborted if no bytes
+// arrive for config.StallTimeout
+func httpDoWithRetry(ctx context.Context, client *http.Client, limiter *rateLimiter, method string, uri string, header http.Header, body []byte, timeout time.Duration, config Config) (*http.Response, error) {
+	maxRetries := config.MaxRetries // Number of retries allowed
+	clock := config.clock()         // Times the timeouts and the backoff between attempts
+	for attempt := 0; ; attempt++ {
+		if err := limiter.Wait(ctx); err != nil {
+			return nil, err // Run cancelled while waiting for a slot
+		}
+		attemptCtx, cancel := context.WithCancelCause(ctx)                           // Cancelled on timeout, stall or close
+		headerTimer := clock.AfterFunc(timeout, func() { cancel(errHeaderTimeout) }) // Timeout for the headers of this attempt only
+		request, err := newRequest(attemptCtx, method, uri, header, body, config)    // Build the request
+		if err != nil {
+			headerTimer.Stop()
+			cancel(nil)
+			return nil, err // Malformed request can't be retried
+		}
+		slog.Debug("Sending request", "method", method, "url", uri, "attempt", attempt+1, "headers", redactHeaders(request.Header))
+		response, err := client.Do(request) // Send the request
+		headerTimer.Stop()                  // Headers are in; the body has its own stall check
+		if errors.Is(err, errDisallowedByRobots) || errors.Is(err, errOffSiteRedirect) || errors.Is(err, errTooManyRedirects) {
+			cancel(nil)
+			return nil, err // Retrying won't change robots.txt or the redirect chain
+		}
+		if err != nil {
+			if cause := context.Cause(attemptCtx); errors.Is(cause, errHeaderTimeout) {
+				err = fmt.Errorf("%w after %s: %w", cause, timeout, err) // Say which timeout fired
+			}
+			cancel(nil) // No body to hold the context open
+		} else {
+			response.Body = newStallBody(response.Body, attemptCtx, cancel, config.StallTimeout, clock) // Keep the context alive while the body is read
+		}
+		if err == nil {
+			limiter.observe(response.StatusCode) // Slow the whole run down on pushback
+		}
+		if ctx.Err() != nil {
+			if err == nil {
+				response.Body.Close() // Discard the response of a cancelled run
+			}
+			return nil, ctx.Err() // Stop retrying once the run is cancelled
+		}
+		if err == nil && !isRetryableStatus(response.StatusCode) {
+			if attempt > 0 {
+				slog.Info("Request succeeded after retries", "url", uri, "retries", attempt) // Log recovery
+			}
+			if err := decodeResponse(response); err != nil {
+				response.Body.Close()
+				return nil, err // Body can't be read as plain content
+			}
+			return response, nil // Success or a non-retryable status
+		}
+		if attempt >= maxRetries {
+			if err != nil {
+				slog.Error("Giving up after retries", "url", uri, "retries", attempt, "error", err) // Log final failure
+				return nil, err
+			}
+			slog.Error("Giving up after retries", "url", uri, "retries", attempt, "status", response.StatusCode) // Log final failure
+			return response, nil                                                                                 // Let the caller handle the last response
+		}
+		delay := retryDelay(attempt, response, clock.Now()) // Work out how long to wait
+		if err != nil {
+			slog.Warn("Retrying request", "url", uri, "attempt", attempt+1, "max_retries", maxRetries, "delay", delay, "error", err) // Log the retry
+		} else {
+			slog.Warn("Retrying request", "url", uri, "attempt", attempt+1, "max_retries", maxRetries, "delay", delay, "status", response.StatusCode) // Log the retry
+			io.Copy(io.Discard, response.Body)                                                                                                        // Drain body so the connection can be reused
+			response.Body.Close()                                                                                                                     // Close the discarded response
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err() // Run cancelled while waiting
+		case <-clock.After(delay): // Wait before retrying
+		}
+	}
+}
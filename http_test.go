@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"             // For building encoded bodies
+	"compress/flate"    // For raw deflate bodies
+	"compress/gzip"     // For gzip bodies
+	"compress/zlib"     // For zlib-wrapped deflate bodies
+	"context"           // For request contexts
+	"crypto/x509"       // For certificate errors
+	"encoding/base64"   // For Basic credentials
+	"encoding/pem"      // For writing the CA bundle
+	"errors"            // For inspecting error values
+	"log/slog"          // For capturing logs
+	"net/http"          // For test handlers
+	"net/http/httptest" // For test servers
+	"net/url"           // For base URLs
+	"os"                // For writing the CA bundle
+	"path/filepath"     // For the CA bundle's path
+	"slices"            // For comparing link lists
+	"strings"           // For searching logs
+	"testing"           // For the test framework
+	"time"              // For the request timeout
+)
+
+// searchPage is a search results page listing one SDS
+const searchPage = `<html><body><table><tr><td><a href="/msds/001001.pdf">Acetylene</a></td></tr></table></body></html>`
+
+// testPageConfig returns the settings of a crawl caching its search pages in a new temp directory
+func testPageConfig(t *testing.T) Config {
+	return Config{HTMLCacheDir: t.TempDir(), RequestTimeout: 10 * time.Second}
+}
+
+// encodeBody returns data compressed with encoding, as a server would send it
+func encodeBody(t *testing.T, encoding string, data []byte) []byte {
+	t.Helper()
+	var buffer bytes.Buffer
+	var writer interface {
+		Write([]byte) (int, error)
+		Close() error
+	}
+	switch encoding {
+	case "gzip":
+		writer = gzip.NewWriter(&buffer)
+	case "zlib":
+		writer = zlib.NewWriter(&buffer)
+	case "flate":
+		writer, _ = flate.NewWriter(&buffer, flate.DefaultCompression)
+	default:
+		return data
+	}
+	writer.Write(data)
+	writer.Close()
+	return buffer.Bytes()
+}
+
+func TestGetDataFromURLDecodesCompressedPages(t *testing.T) {
+	tests := []struct {
+		name            string
+		contentEncoding string // Content-Encoding header sent
+		encoding        string // How the body is really encoded
+	}{
+		{"plain", "", ""},
+		{"identity", "identity", ""},
+		{"gzip", "gzip", "gzip"},
+		{"x-gzip", "x-gzip", "gzip"},
+		{"deflate as zlib", "deflate", "zlib"},
+		{"raw deflate", "deflate", "flate"},
+		{"uppercase gzip", "GZIP", "gzip"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+				if sent := request.Header.Get("Accept-Encoding"); sent != acceptEncoding {
+					http.Error(writer, "Accept-Encoding "+sent, http.StatusBadRequest) // The encodings weren't negotiated
+					return
+				}
+				writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+				if test.contentEncoding != "" {
+					writer.Header().Set("Content-Encoding", test.contentEncoding)
+				}
+				writer.Write(encodeBody(t, test.encoding, []byte(searchPage)))
+			}))
+			defer server.Close()
+			config := testPageConfig(t)
+			scraper := newScraper(config, newHTTPClient(config), nil)
+
+			body, extractor := scraper.getDataFromURL(context.Background(), getRequest(server.URL+"/sds-search?page=0"))
+			if string(body) != searchPage {
+				t.Fatalf("body = %q, want the plain page", body)
+			}
+			base, _ := url.Parse(server.URL)
+			if links := linkURLs(extractor.Extract(body, base)); !slices.Equal(links, []string{server.URL + "/msds/001001.pdf"}) {
+				t.Errorf("links = %q", links)
+			}
+			if cached, _ := scraper.readCachedPage(server.URL + "/sds-search?page=0"); string(cached) != searchPage {
+				t.Errorf("cached page = %q, want it decoded", cached)
+			}
+		})
+	}
+}
+
+func TestGetDataFromURLRejectsCorruptEncoding(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("Content-Encoding", "gzip")
+		writer.Write([]byte(searchPage)) // Not gzip at all
+	}))
+	defer server.Close()
+	config := testPageConfig(t)
+	scraper := newScraper(config, newHTTPClient(config), nil)
+	if body, _ := scraper.getDataFromURL(context.Background(), getRequest(server.URL+"/sds-search")); body != nil {
+		t.Fatalf("body = %q, want the page rejected", body)
+	}
+	if failed := scraper.stats.PagesFailed.Load(); failed != 1 {
+		t.Errorf("PagesFailed = %d, want 1", failed)
+	}
+}
+
+// captureLogs sends the default logger's records, debug ones included, to the returned buffer
+// for the rest of the test
+func captureLogs(t *testing.T) *bytes.Buffer {
+	var logs bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+	return &logs
+}
+
+func TestAuthorizationHeaders(t *testing.T) {
+	tests := []struct {
+		name   string
+		config func(*Config)
+		want   string
+		secret string
+	}{
+		{"basic", func(config *Config) { config.BasicAuth = "sds-user:s3cret:with-colon" }, "Basic " + base64.StdEncoding.EncodeToString([]byte("sds-user:s3cret:with-colon")), "s3cret"},
+		{"bearer", func(config *Config) { config.BearerToken = "tok-123" }, "Bearer tok-123", "tok-123"},
+		{"none", func(config *Config) {}, "", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			logs := captureLogs(t)
+			server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+				if got := request.Header.Get("Authorization"); got != test.want {
+					http.Error(writer, "Authorization "+got, http.StatusUnauthorized)
+				}
+			}))
+			defer server.Close()
+			config := Config{UserAgent: "test"}
+			test.config(&config)
+			response, err := httpGetWithRetry(context.Background(), server.Client(), nil, server.URL+"/gated.pdf", nil, time.Minute, config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			response.Body.Close()
+			if response.StatusCode != http.StatusOK {
+				t.Fatalf("status %d, want the credentials accepted", response.StatusCode)
+			}
+			if test.secret != "" && strings.Contains(logs.String(), test.secret) {
+				t.Errorf("credentials logged:\n%s", logs.String())
+			}
+			if test.secret != "" && !strings.Contains(logs.String(), "[REDACTED]") {
+				t.Errorf("request headers not logged redacted:\n%s", logs.String())
+			}
+		})
+	}
+}
+
+func TestRedactHeaders(t *testing.T) {
+	header := http.Header{
+		"Authorization": {"Bearer tok"},
+		"Cookie":        {"a=1", "b=2"},
+		"X-Api-Key":     {"key"},
+		"Accept":        {"application/pdf"},
+	}
+	redacted := redactHeaders(header)
+	for _, key := range []string{"Authorization", "Cookie", "X-Api-Key"} {
+		for _, value := range redacted.Values(key) {
+			if value != "[REDACTED]" {
+				t.Errorf("%s = %q, want it redacted", key, value)
+			}
+		}
+	}
+	if len(redacted.Values("Cookie")) != 2 || redacted.Get("Accept") != "application/pdf" {
+		t.Errorf("redacted headers = %v", redacted)
+	}
+	if header.Get("Authorization") != "Bearer tok" {
+		t.Error("the original headers were changed")
+	}
+}
+func TestHTTPClientTLSConfig(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.Write([]byte("%PDF-1.7"))
+	}))
+	defer server.Close()
+	caPath := filepath.Join(t.TempDir(), "proxy-ca.pem")
+	certificate := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(caPath, certificate, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	pool, err := loadCAFile(caPath)
+	if err != nil {
+		t.Fatalf("loadCAFile: %v", err)
+	}
+
+	get := func(config Config) error {
+		response, err := newHTTPClient(config).Get(server.URL + "/doc.pdf")
+		if err == nil {
+			response.Body.Close()
+		}
+		return err
+	}
+	config := testConfig(t)
+	var unknownAuthority x509.UnknownAuthorityError
+	if err := get(config); !errors.As(err, &unknownAuthority) {
+		t.Errorf("without -ca-file: err = %v, want an unknown authority", err)
+	}
+	config.RootCAs = pool
+	if err := get(config); err != nil {
+		t.Errorf("with -ca-file: %v", err)
+	}
+
+	logs := captureLogs(t)
+	insecure := testConfig(t)
+	insecure.InsecureTLS = true
+	if err := get(insecure); err != nil {
+		t.Errorf("with -insecure-skip-verify: %v", err)
+	}
+	if !strings.Contains(logs.String(), "TLS certificate verification is DISABLED") {
+		t.Errorf("no warning for -insecure-skip-verify in:\n%s", logs.String())
+	}
+}
+
+func TestLoadCAFileErrors(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := loadCAFile(filepath.Join(dir, "missing.pem")); err == nil || !strings.Contains(err.Error(), "reading -ca-file") {
+		t.Errorf("missing file: err = %v", err)
+	}
+	empty := filepath.Join(dir, "empty.pem")
+	os.WriteFile(empty, []byte("not a certificate\n"), 0o644)
+	if _, err := loadCAFile(empty); err == nil || !strings.Contains(err.Error(), "no PEM certificates found") {
+		t.Errorf("file without certificates: err = %v", err)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"   // For cancelling in-flight work
+	"math/rand" // For shuffling the crawl and picking random delays
+	"sync"      // For handling concurrency
+	"time"      // For time-related operations
+)
+
+// jitter randomizes the crawl with -shuffle: letters are crawled in a random order and every
+// search page request waits a random delay first; it is safe for concurrent use, and a nil
+// jitter leaves the crawl in order and undelayed
+type jitter struct {
+	clock  Clock         // Times the delays
+	mutex  sync.Mutex    // Guards random, which isn't safe for concurrent use
+	random *rand.Rand    // Seeded source, so -seed reproduces a run's order and delays
+	min    time.Duration // Shortest delay before a search page request
+	max    time.Duration // Longest delay before a search page request
+}
+
+// newJitter returns a jitter drawing from seed, with delays between min and max timed by clock
+func newJitter(seed int64, min time.Duration, max time.Duration, clock Clock) *jitter {
+	return &jitter{clock: clock, random: rand.New(rand.NewSource(seed)), min: min, max: max}
+}
+
+// shuffle puts letters in a random order; with a nil jitter it leaves them alone
+func (jitter *jitter) shuffle(letters []rune) {
+	if jitter == nil {
+		return
+	}
+	jitter.mutex.Lock()
+	defer jitter.mutex.Unlock()
+	jitter.random.Shuffle(len(letters), func(i, j int) { letters[i], letters[j] = letters[j], letters[i] })
+}
+
+// Wait sleeps for a random delay between min and max, or until ctx is cancelled
+func (jitter *jitter) Wait(ctx context.Context) error {
+	if jitter == nil || jitter.max <= 0 {
+		return ctx.Err() // No delay requested
+	}
+	jitter.mutex.Lock()
+	delay := jitter.min + time.Duration(jitter.random.Int63n(int64(jitter.max-jitter.min)+1)) // Anywhere in [min, max]
+	jitter.mutex.Unlock()
+	select {
+	case <-ctx.Done():
+		return ctx.Err() // Run cancelled while waiting
+	case <-jitter.clock.After(delay):
+		return nil
+	}
+}
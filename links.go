@@ -0,0 +1,226 @@
+package main
+
+import (
+	"fmt"      // For formatted I/O operations
+	"log/slog" // For structured, levelled logging
+	"net"      // For host and port handling
+	"net/url"  // For parsing and manipulating URLs
+	"path"     // For extensions of URL paths
+	"regexp"   // For document endpoint patterns
+	"slices"   // For searching slices
+	"strings"  // For string manipulation
+	"time"     // For dates listed with links
+
+	"golang.org/x/net/html" // For parsing HTML documents
+)
+
+// trackingParams are the query parameters -ignore-params defaults to, which only identify where
+// a click came from
+var trackingParams = []string{"utm_*", "fbclid", "gclid", "msclkid", "mc_cid", "mc_eid", "_ga", "ref"}
+
+// parseIgnoredParams splits a comma-separated -ignore-params value into lowercased names
+func parseIgnoredParams(value string) ([]string, error) {
+	var names []string
+	for _, name := range strings.Split(value, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue // "" ignores no parameters
+		}
+		if strings.Contains(strings.TrimSuffix(name, "*"), "*") || name == "*" {
+			return nil, fmt.Errorf("invalid -ignore-params %q: %q can only end in *, after a prefix", value, name)
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// isTrackingParam reports whether a query parameter is one of the ignored names, matched
+// case-insensitively; a name ending in * matches every parameter starting with the rest
+func isTrackingParam(name string, ignored []string) bool {
+	name = strings.ToLower(name)
+	return slices.ContainsFunc(ignored, func(ignoredName string) bool {
+		if prefix, ok := strings.CutSuffix(ignoredName, "*"); ok {
+			return strings.HasPrefix(name, prefix) // utm_source, utm_medium, ...
+		}
+		return name == ignoredName
+	})
+}
+
+// canonicalizeURL returns a normalized form of raw used to decide whether two URLs point to the
+// same document: http is treated as https, the host is lowercased, default ports, fragments and
+// the ignored parameters are removed, and the remaining query is sorted (an empty query is dropped)
+func canonicalizeURL(raw string, ignored []string) string {
+	parsed, err := url.Parse(strings.TrimSpace(raw)) // Parse the URL
+	if err != nil {
+		return raw // Unparseable URLs are only equal to themselves
+	}
+	parsed.Scheme = strings.ToLower(parsed.Scheme)
+	if parsed.Scheme == "http" {
+		parsed.Scheme = "https" // Same document over either scheme
+	}
+	host := strings.ToLower(parsed.Hostname())
+	if port := parsed.Port(); port != "" && port != "80" && port != "443" {
+		host = net.JoinHostPort(host, port) // Keep non-default ports
+	} else if strings.Contains(host, ":") {
+		host = "[" + host + "]" // Re-bracket IPv6 literals
+	}
+	parsed.Host = host
+	parsed.Fragment = "" // Fragments never reach the server
+	parsed.RawFragment = ""
+	query := parsed.Query()
+	for name := range query {
+		if isTrackingParam(name, ignored) {
+			query.Del(name) // Drop tracking parameters
+		}
+	}
+	parsed.RawQuery = query.Encode() // Sorted by key; empty when nothing is left
+	parsed.ForceQuery = false        // Collapse a trailing "?"
+	return parsed.String()
+}
+
+// isUrlValid checks whether a URL is syntactically valid
+func isUrlValid(uri string) bool {
+	_, err := url.ParseRequestURI(uri) // Try to parse the URL
+	return err == nil                  // Return true if no error (i.e., valid URL)
+}
+
+// PDFLink is a document link found on a search page
+type PDFLink struct {
+	URL      string    // Absolute URL of the document
+	Modified time.Time // Last-updated date listed with the link (zero if the page shows none)
+}
+
+// listedBefore reports whether the link is listed as last updated before since; links without a
+// date never are, and no link is when since is zero
+func (link PDFLink) listedBefore(since time.Time) bool {
+	return !since.IsZero() && !link.Modified.IsZero() && link.Modified.Before(since)
+}
+
+// dateAttributes are attributes that may carry a document's last-updated date
+var dateAttributes = []string{"datetime", "data-modified", "data-last-modified", "data-updated", "data-date"}
+
+// dateLayouts are the date formats recognized in search results
+var dateLayouts = []string{time.RFC3339, "2006-01-02", "01/02/2006", "Jan 2, 2006", "January 2, 2006"}
+
+// resultContainers are elements that hold a single search result
+var resultContainers = []string{"tr", "li", "article"}
+
+// maxDateAncestors is how many levels above a link are searched for its date
+const maxDateAncestors = 4
+
+// parseDocumentDate parses a date in one of dateLayouts
+func parseDocumentDate(value string) (time.Time, bool) {
+	value = strings.TrimSpace(value)
+	for _, layout := range dateLayouts {
+		if date, err := time.Parse(layout, value); err == nil {
+			return date, true
+		}
+	}
+	return time.Time{}, false // Not a date we understand
+}
+
+// elementDate returns the date carried by one of node's dateAttributes, or by the text of a
+// <time> element
+func elementDate(node *html.Node) (time.Time, bool) {
+	for _, attribute := range node.Attr {
+		if slices.Contains(dateAttributes, attribute.Key) {
+			if date, ok := parseDocumentDate(attribute.Val); ok {
+				return date, true
+			}
+		}
+	}
+	if node.Data == "time" && node.FirstChild != nil && node.FirstChild.Type == html.TextNode {
+		return parseDocumentDate(node.FirstChild.Data) // <time>2024-03-01</time>
+	}
+	return time.Time{}, false
+}
+
+// linkModified returns the date listed next to a link element: one on the link or its nearest
+// ancestors, or anywhere inside the search result (table row, list item or article) holding it
+func linkModified(link *html.Node) time.Time {
+	node := link
+	for level := 0; level <= maxDateAncestors && node != nil && node.Type == html.ElementNode; level++ {
+		if date, ok := elementDate(node); ok {
+			return date // Date on the link or an enclosing element
+		}
+		if slices.Contains(resultContainers, node.Data) {
+			for child := range node.Descendants() {
+				if child.Type != html.ElementNode {
+					continue
+				}
+				if date, ok := elementDate(child); ok {
+					return date // Date shown elsewhere in the same result
+				}
+			}
+			break // Don't borrow a date from a neighbouring result
+		}
+		node = node.Parent
+	}
+	return time.Time{} // The page doesn't list a date for this link
+}
+
+// knownDocumentEndpoints match SDS download endpoints that serve PDFs from URLs without a .pdf
+// extension, enabled with -sds-endpoints
+var knownDocumentEndpoints = []string{
+	`(?i)/getsds\.aspx\b`,        // airgas.com's own SDS handler
+	`(?i)/document/download\b`,   // /document/download?id=123
+	`(?i)/sds[-_]?download\b`,    // /sds-download/123
+	`(?i)/msds/[^/?#]+\?.*\bid=`, // /msds/view?id=123
+}
+
+// resolvePDFLink resolves a link found in a page against baseURL and returns it if it is an
+// http(s) link to a .pdf file or matches one of patterns; downloadPDF confirms by its content
+// that a pattern match is really a PDF
+func resolvePDFLink(raw string, baseURL *url.URL, patterns []*regexp.Regexp) (string, bool) {
+	reference, err := url.Parse(strings.TrimSpace(raw)) // Parse the link
+	if err != nil {
+		return "", false // Skip malformed links
+	}
+	resolved := baseURL.ResolveReference(reference) // Resolve relative links
+	if resolved.Scheme != "http" && resolved.Scheme != "https" {
+		return "", false // Skip mailto:, javascript: and similar
+	}
+	link := resolved.String()
+	if !strings.EqualFold(path.Ext(resolved.Path), ".pdf") &&
+		!slices.ContainsFunc(patterns, func(pattern *regexp.Regexp) bool { return pattern.MatchString(link) }) {
+		return "", false // Only keep PDF targets
+	}
+	return link, true
+}
+
+// extractPDFLinks parses HTML and extracts all unique .pdf links, and links matching patterns,
+// from href and src attributes, resolving relative links against baseURL and capturing any date
+// listed with each link
+func extractPDFLinks(htmlContent string, baseURL *url.URL, patterns []*regexp.Regexp) []PDFLink {
+	document, err := html.Parse(strings.NewReader(htmlContent)) // Parse the HTML into a DOM
+	if err != nil {
+		slog.Error("Failed to parse HTML", "error", err) // Log parsing error
+		return nil
+	}
+	seen := make(map[string]int) // Index of each link already kept
+	var links []PDFLink
+
+	for node := range document.Descendants() { // Walk every node in the DOM
+		if node.Type != html.ElementNode {
+			continue // Only elements carry attributes
+		}
+		for _, attribute := range node.Attr {
+			if attribute.Key != "href" && attribute.Key != "src" {
+				continue // Only link-bearing attributes
+			}
+			link, ok := resolvePDFLink(attribute.Val, baseURL, patterns)
+			if !ok {
+				continue // Not a link to a PDF
+			}
+			index, ok := seen[link]
+			if !ok { // If link is new
+				seen[link] = len(links)                                                 // Mark as seen
+				links = append(links, PDFLink{URL: link, Modified: linkModified(node)}) // Add to list
+			} else if links[index].Modified.IsZero() {
+				links[index].Modified = linkModified(node) // A later copy of the link may show the date
+			}
+		}
+	}
+
+	return links // Return list of links
+}
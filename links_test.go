@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"           // For the page request's context
+	"fmt"               // For the fixture page
+	"net/http"          // For test handlers
+	"net/http/httptest" // For test servers
+	"net/url"           // For base URLs
+	"path/filepath"     // For the output files
+	"regexp"            // For document patterns
+	"slices"            // For comparing link lists
+	"sync"              // For guarding downloaded URLs
+	"testing"           // For the test framework
+	"text/template"     // For a custom name template
+)
+
+// linkURLs returns the URLs of links
+func linkURLs(links []PDFLink) []string {
+	var urls []string
+	for _, link := range links {
+		urls = append(urls, link.URL)
+	}
+	return urls
+}
+
+func TestExtractPDFLinks(t *testing.T) {
+	base, _ := url.Parse("https://www.airgas.com/sds-search?searchKeyWord=a&page=0")
+	tests := []struct {
+		name string
+		html string
+		want []string
+	}{
+		{"absolute", `<a href="https://www.airgas.com/msds/001001.pdf">SDS</a>`, []string{"https://www.airgas.com/msds/001001.pdf"}},
+		{"root-relative", `<a href="/msds/001001.pdf">SDS</a>`, []string{"https://www.airgas.com/msds/001001.pdf"}},
+		{"relative", `<a href="msds/001001.pdf">SDS</a>`, []string{"https://www.airgas.com/msds/001001.pdf"}},
+		{"parent-relative", `<a href="../files/a.pdf">SDS</a>`, []string{"https://www.airgas.com/files/a.pdf"}},
+		{"protocol-relative", `<a href="//cdn.airgas.com/a.pdf">SDS</a>`, []string{"https://cdn.airgas.com/a.pdf"}},
+		{"query string", `<a href="/msds/001001.pdf?v=2&amp;lang=en">SDS</a>`, []string{"https://www.airgas.com/msds/001001.pdf?v=2&lang=en"}},
+		{"uppercase extension", `<a href="/msds/A.PDF">SDS</a>`, []string{"https://www.airgas.com/msds/A.PDF"}},
+		{"single quotes", `<a href='/msds/a.pdf'>SDS</a>`, []string{"https://www.airgas.com/msds/a.pdf"}},
+		{"unquoted", `<a href=/msds/a.pdf>SDS</a>`, []string{"https://www.airgas.com/msds/a.pdf"}},
+		{"padded", `<a href="  /msds/a.pdf  ">SDS</a>`, []string{"https://www.airgas.com/msds/a.pdf"}},
+		{"src attribute", `<iframe src="/viewer/a.pdf"></iframe>`, []string{"https://www.airgas.com/viewer/a.pdf"}},
+		{"repeated", `<a href="/msds/a.pdf">SDS</a><a href="https://www.airgas.com/msds/a.pdf">again</a>`, []string{"https://www.airgas.com/msds/a.pdf"}},
+		{"in order", `<a href="/b.pdf">B</a><p><a href="/a.pdf">A</a></p>`, []string{"https://www.airgas.com/b.pdf", "https://www.airgas.com/a.pdf"}},
+		{"html page", `<a href="/msds/001001.html">SDS</a>`, nil},
+		{"pdf in the query only", `<a href="/viewer?file=a.pdf">SDS</a>`, nil},
+		{"pdf in the path only", `<a href="/pdf/list">SDS</a>`, nil},
+		{"mailto", `<a href="mailto:sds@airgas.com?subject=a.pdf">mail</a>`, nil},
+		{"javascript", `<a href="javascript:open('a.pdf')">open</a>`, nil},
+		{"text mention", `<p>Download a.pdf from the portal</p>`, nil},
+		{"data attribute", `<div data-href="/msds/a.pdf"></div>`, nil},
+	}
+	for _, test := range tests {
+		if got := linkURLs(extractPDFLinks(test.html, base, nil)); !slices.Equal(got, test.want) {
+			t.Errorf("%s: extractPDFLinks(%s) = %q, want %q", test.name, test.html, got, test.want)
+		}
+	}
+}
+
+func TestExtractPDFLinksDocumentPatterns(t *testing.T) {
+	base, _ := url.Parse("https://www.airgas.com/sds-search")
+	page := `<a href="/getsds.aspx?id=7">SDS</a><a href="/document/download?id=8">SDS</a><a href="/products/7">product</a>`
+	if got := linkURLs(extractPDFLinks(page, base, nil)); len(got) != 0 {
+		t.Errorf("without -sds-endpoints = %q, want none", got)
+	}
+	var patterns []*regexp.Regexp // As -sds-endpoints adds them
+	for _, expression := range knownDocumentEndpoints {
+		patterns = append(patterns, regexp.MustCompile(expression))
+	}
+	want := []string{"https://www.airgas.com/getsds.aspx?id=7", "https://www.airgas.com/document/download?id=8"}
+	if got := linkURLs(extractPDFLinks(page, base, patterns)); !slices.Equal(got, want) {
+		t.Errorf("with -sds-endpoints = %q, want %q", got, want)
+	}
+}
+
+func TestExtractAndDedupeServedPage(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprintf(writer, `<html><body><table>
+			<tr><td><a href="/msds/a.pdf">A</a></td></tr>
+			<tr><td><a href="http://%s/msds/a.pdf?utm_source=search">A again</a></td></tr>
+			<tr><td><a href="/msds/a.pdf?">A, empty query</a></td></tr>
+			<tr><td><a href="/msds/b.pdf#page=2">B</a></td></tr>
+			<tr><td><a href="/msds/b.pdf">B again</a></td></tr>
+			<tr><td><a href="/msds/c.PDF">C</a></td></tr>
+			<tr><td><a href="/about.html">About</a></td></tr>
+		</table></body></html>`, request.Host)
+	}))
+	defer server.Close()
+	config := testConfig(t)
+	config.HTMLCacheDir = t.TempDir()
+	scraper := newTestScraper(t, config, server.Client())
+
+	source := func(ctx context.Context, emit func(PDFLink)) error {
+		body, extractor := scraper.getDataFromURL(ctx, getRequest(server.URL+"/sds-search?page=0"))
+		if body == nil {
+			return fmt.Errorf("page not fetched")
+		}
+		base, _ := url.Parse(server.URL + "/sds-search")
+		for _, link := range extractor.Extract(body, base) {
+			emit(link)
+		}
+		return nil
+	}
+	var mutex sync.Mutex
+	var downloaded []string
+	downloader := DownloaderFunc(func(ctx context.Context, url string, dest string) (DownloadResult, error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		downloaded = append(downloaded, url)
+		return DownloadResult{Outcome: outcomeDownloaded, Filename: dest}, nil
+	})
+	if err := scraper.runPipeline(context.Background(), source, downloader); err != nil {
+		t.Fatalf("runPipeline: %v", err)
+	}
+	want := []string{server.URL + "/msds/a.pdf", server.URL + "/msds/b.pdf#page=2", server.URL + "/msds/c.PDF"}
+	slices.Sort(downloaded)
+	if !slices.Equal(downloaded, want) {
+		t.Errorf("downloaded %q, want one of each document: %q", downloaded, want)
+	}
+	if found := scraper.stats.LinksFound.Load(); found != 3 {
+		t.Errorf("LinksFound = %d, want 3", found)
+	}
+}
+
+func TestCanonicalizeURL(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want string
+	}{
+		{"https://www.airgas.com/msds/a.pdf", "https://www.airgas.com/msds/a.pdf"},
+		{"http://www.airgas.com/msds/a.pdf", "https://www.airgas.com/msds/a.pdf"},
+		{"HTTPS://WWW.Airgas.COM/msds/a.pdf", "https://www.airgas.com/msds/a.pdf"},
+		{"https://www.airgas.com:443/msds/a.pdf", "https://www.airgas.com/msds/a.pdf"},
+		{"http://www.airgas.com:80/msds/a.pdf", "https://www.airgas.com/msds/a.pdf"},
+		{"https://www.airgas.com:8443/msds/a.pdf", "https://www.airgas.com:8443/msds/a.pdf"},
+		{"https://www.airgas.com/msds/a.pdf?", "https://www.airgas.com/msds/a.pdf"},
+		{"https://www.airgas.com/msds/a.pdf#page=2", "https://www.airgas.com/msds/a.pdf"},
+		{"  https://www.airgas.com/msds/a.pdf  ", "https://www.airgas.com/msds/a.pdf"},
+		{"https://www.airgas.com/msds/a.pdf?utm_source=mail&utm_campaign=x", "https://www.airgas.com/msds/a.pdf"},
+		{"https://www.airgas.com/msds/a.pdf?UTM_Source=mail&gclid=1&fbclid=2&ref=nav", "https://www.airgas.com/msds/a.pdf"},
+		{"https://www.airgas.com/getsds.aspx?id=7&utm_medium=x&lang=en", "https://www.airgas.com/getsds.aspx?id=7&lang=en"},
+		{"https://www.airgas.com/getsds.aspx?lang=en&id=7", "https://www.airgas.com/getsds.aspx?id=7&lang=en"},
+		{"https://www.airgas.com/MSDS/A.pdf", "https://www.airgas.com/MSDS/A.pdf"}, // Paths are case-sensitive
+		{"http://[::1]:80/a.pdf", "https://[::1]/a.pdf"},
+		{"http://[::1]:8080/a.pdf", "https://[::1]:8080/a.pdf"},
+		{"http://bad host/a.pdf", "http://bad host/a.pdf"}, // Unparseable URLs are left alone
+	}
+	for _, test := range tests {
+		if got := canonicalizeURL(test.raw, trackingParams); got != test.want {
+			t.Errorf("canonicalizeURL(%q) = %q, want %q", test.raw, got, test.want)
+		}
+	}
+}
+
+func TestCanonicalizeURLIgnoredParams(t *testing.T) {
+	const raw = "https://www.airgas.com/getsds.aspx?id=7&session=abc&sessionid=1&utm_source=x"
+	tests := []struct {
+		value string // -ignore-params
+		want  string
+	}{
+		{"", "https://www.airgas.com/getsds.aspx?id=7&session=abc&sessionid=1&utm_source=x"},
+		{"session", "https://www.airgas.com/getsds.aspx?id=7&sessionid=1&utm_source=x"},
+		{"session*", "https://www.airgas.com/getsds.aspx?id=7&utm_source=x"},
+		{" Session , UTM_* ", "https://www.airgas.com/getsds.aspx?id=7&sessionid=1"},
+	}
+	for _, test := range tests {
+		ignored, err := parseIgnoredParams(test.value)
+		if err != nil {
+			t.Fatalf("parseIgnoredParams(%q): %v", test.value, err)
+		}
+		if got := canonicalizeURL(raw, ignored); got != test.want {
+			t.Errorf("-ignore-params %q: canonicalizeURL = %q, want %q", test.value, got, test.want)
+		}
+	}
+	for _, invalid := range []string{"*", "a*b", "*utm"} {
+		if _, err := parseIgnoredParams(invalid); err == nil {
+			t.Errorf("parseIgnoredParams(%q) accepted a misplaced *", invalid)
+		}
+	}
+}
+
+func TestMalformedURLsAreSkipped(t *testing.T) {
+	base, _ := url.Parse("https://www.airgas.com/sds-search")
+	page := `<a href="http://[::1/bad.pdf">bad host</a><a href="/msds/%zz.pdf">bad escape</a><a href="/msds/good.pdf">good</a>`
+	if got := linkURLs(extractPDFLinks(page, base, nil)); !slices.Equal(got, []string{"https://www.airgas.com/msds/good.pdf"}) {
+		t.Errorf("extractPDFLinks = %q, want only the well-formed link", got)
+	}
+
+	server := serveBody(t, "application/pdf", []byte("%PDF-1.7\n%%EOF\n"))
+	config := testConfig(t)
+	config.NameTemplate = template.Must(parseNameTemplate("{{.Base}}{{.Ext}}")) // Only the last path segment, which can be empty
+	scraper := newTestScraper(t, config, server.Client())
+	source := func(ctx context.Context, emit func(PDFLink)) error {
+		for _, path := range []string{"/.pdf", "/msds/.pdf", "/msds/%zz.pdf", "/msds/good.pdf"} {
+			emit(PDFLink{URL: server.URL + path})
+		}
+		return nil
+	}
+	if err := scraper.runPipeline(context.Background(), source, DownloaderFunc(scraper.downloadPDF)); err != nil {
+		t.Fatalf("runPipeline: %v", err)
+	}
+	if names, _ := filepath.Glob(filepath.Join(config.OutputDir, "*")); !slices.Equal(names, []string{filepath.Join(config.OutputDir, "good.pdf")}) {
+		t.Errorf("files = %q, want only good.pdf", names)
+	}
+	if skipped := scraper.stats.SkippedInvalid.Load(); skipped != 3 {
+		t.Errorf("SkippedInvalid = %d, want 3", skipped)
+	}
+}
+
+// pipelineDownloads runs urls through the scraper's pipeline and returns the URLs handed to the
+// downloader, sorted
+func pipelineDownloads(t *testing.T, scraper *Scraper, urls []string) []string {
+	t.Helper()
+	var mutex sync.Mutex
+	var downloaded []string
+	downloader := DownloaderFunc(func(ctx context.Context, url string, dest string) (DownloadResult, error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		downloaded = append(downloaded, url)
+		return DownloadResult{Outcome: outcomeDownloaded, Filename: dest}, nil
+	})
+	source := func(ctx context.Context, emit func(PDFLink)) error {
+		for _, link := range urls {
+			emit(PDFLink{URL: link})
+		}
+		return nil
+	}
+	if err := scraper.runPipeline(context.Background(), source, downloader); err != nil {
+		t.Fatalf("runPipeline: %v", err)
+	}
+	slices.Sort(downloaded)
+	return downloaded
+}
+
+func TestQueryVariantsCollapse(t *testing.T) {
+	variants := []string{
+		"https://www.airgas.com/msds/x.pdf",
+		"https://www.airgas.com/msds/x.pdf?ref=a",
+		"https://www.airgas.com/msds/x.pdf?ref=b",
+		"https://www.airgas.com/msds/x.pdf?utm_source=mail&ref=c",
+		"https://www.airgas.com/msds/x.pdf?session=1",
+		"https://www.airgas.com/msds/x.pdf?version=2",
+	}
+	tests := []struct {
+		ignoreParams string // -ignore-params, or "default"
+		want         []string
+	}{
+		{"default", []string{variants[0], variants[4], variants[5]}},
+		{"ref,utm_*,session", []string{variants[0], variants[5]}},
+		{"", variants}, // Every query tells documents apart
+	}
+	for _, test := range tests {
+		t.Run(test.ignoreParams, func(t *testing.T) {
+			config := testConfig(t)
+			if test.ignoreParams != "default" {
+				config.IgnoreParams, _ = parseIgnoredParams(test.ignoreParams)
+			}
+			want := slices.Sorted(slices.Values(test.want))
+			if got := pipelineDownloads(t, newTestScraper(t, config, http.DefaultClient), variants); !slices.Equal(got, want) {
+				t.Errorf("downloaded %q, want %q", got, want)
+			}
+		})
+	}
+}
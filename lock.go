@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"        // For creating error values
+	"fmt"           // For formatted I/O operations
+	"os"            // For file and system operations
+	"path/filepath" // For manipulating filename paths
+	"strconv"       // For reading the holder's PID
+	"strings"       // For string manipulation
+)
+
+// lockFileName is the lock file kept in the output directory while a run is using it
+const lockFileName = ".lock"
+
+// errLocked is returned when another instance holds the output directory's lock
+var errLocked = errors.New("output directory is in use by another instance")
+
+// dirLock is a held lock on an output directory
+type dirLock struct {
+	fs   FS     // Filesystem holding the lock file
+	file File   // Open lock file; closing it releases the lock
+	path string // Path of the lock file
+}
+
+// lockDirectory takes the lock on dir on fsys, failing with errLocked and the holder's PID when
+// another instance has it
+func lockDirectory(fsys FS, dir string) (*dirLock, error) {
+	path := filepath.Join(dir, lockFileName)
+	file, err := acquireLockFile(fsys, path)
+	if errors.Is(err, errLocked) {
+		if holder := lockHolder(fsys, path); holder != 0 {
+			return nil, fmt.Errorf("%w (PID %d holds %s; use -force to run anyway)", errLocked, holder, path)
+		}
+		return nil, fmt.Errorf("%w (%s is held; use -force to run anyway)", errLocked, path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("locking %s: %w", path, err)
+	}
+	if err := file.Truncate(0); err == nil {
+		file.Write([]byte(strconv.Itoa(os.Getpid()) + "\n")) // Tell a second instance who holds the lock
+	}
+	return &dirLock{fs: fsys, file: file, path: path}, nil
+}
+
+// lockHolder returns the PID recorded in the lock file at path on fsys, or 0 if it can't be read
+func lockHolder(fsys FS, path string) int {
+	content, err := readFile(fsys, path)
+	if err != nil {
+		return 0
+	}
+	pid, _ := strconv.Atoi(strings.TrimSpace(string(content)))
+	return pid
+}
+
+// Release gives the lock up
+func (lock *dirLock) Release() {
+	releaseLockFile(lock.fs, lock.file, lock.path)
+}
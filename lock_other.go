@@ -0,0 +1,21 @@
+//go:build !unix
+
+package main
+
+import "os" // For file and system operations
+
+// acquireLockFile creates the lock file at path on fsys, failing if it already exists; a run
+// that is killed leaves it behind, to be removed by hand or overridden with -force
+func acquireLockFile(fsys FS, path string) (File, error) {
+	file, err := fsys.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o644)
+	if os.IsExist(err) {
+		return nil, errLocked
+	}
+	return file, err
+}
+
+// releaseLockFile closes and removes the lock file
+func releaseLockFile(fsys FS, file File, path string) {
+	file.Close()
+	fsys.Remove(path)
+}
@@ -0,0 +1,40 @@
+//go:build unix
+
+package main
+
+import (
+	"errors"  // For inspecting error values
+	"os"      // For file and system operations
+	"syscall" // For flock
+)
+
+// acquireLockFile opens the lock file at path on fsys and takes an exclusive flock on it without
+// waiting; the kernel releases it if the process dies, so a killed run never leaves the directory
+// locked. Files not backed by a descriptor aren't shared with other processes, so aren't flocked
+func acquireLockFile(fsys FS, path string) (File, error) {
+	file, err := fsys.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	descriptor, ok := file.(interface{ Fd() uintptr })
+	if !ok {
+		return file, nil
+	}
+	if err := syscall.Flock(int(descriptor.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		file.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, errLocked
+		}
+		return nil, err
+	}
+	return file, nil
+}
+
+// releaseLockFile unlocks and closes the lock file; the file itself stays, as removing it could
+// race with an instance that just opened it
+func releaseLockFile(fsys FS, file File, path string) {
+	if descriptor, ok := file.(interface{ Fd() uintptr }); ok {
+		syscall.Flock(int(descriptor.Fd()), syscall.LOCK_UN)
+	}
+	file.Close()
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"       // For slog handler calls
+	"errors"        // For combining error values
+	"fmt"           // For formatted I/O operations
+	"log/slog"      // For structured, levelled logging
+	"os"            // For file and system operations
+	"path/filepath" // For manipulating filename paths
+	"slices"        // For sorting rotated files
+	"sync"          // For handling concurrency
+	"time"          // For time-related operations
+)
+
+// rotatedTimeLayout stamps the name of a rotated log file; it sorts in time order
+const rotatedTimeLayout = "20060102T150405.000000000"
+
+// rotatingFile is a log file that, once it would grow past maxBytes or has been open for
+// longer than every, is renamed to path.<time> and replaced by a new file; only the newest keep
+// rotated files are kept. Every Write lands whole in one file, so as slog writes one record per
+// call each file stays valid JSON lines. It is safe for concurrent use
+type rotatingFile struct {
+	fs       FS            // Filesystem the log files are written to
+	path     string        // Path of the current log file
+	maxBytes int64         // Rotate before the file grows past this size (0 never rotates on size)
+	every    time.Duration // Rotate once the file is this old (0 never rotates on age)
+	keep     int           // Rotated files kept; older ones are removed
+	mutex    sync.Mutex    // Guards the fields below
+	file     File          // Current log file
+	size     int64         // Bytes in the current file
+	opened   time.Time     // When the current file was started
+}
+
+// newRotatingFile opens the log file at path on fsys for appending
+func newRotatingFile(fsys FS, path string, maxBytes int64, every time.Duration, keep int) (*rotatingFile, error) {
+	if dir := filepath.Dir(path); !directoryExists(fsys, dir) {
+		if err := createDirectory(fsys, dir, 0o755); err != nil {
+			return nil, err
+		}
+	}
+	logFile := &rotatingFile{fs: fsys, path: path, maxBytes: maxBytes, every: every, keep: keep}
+	if err := logFile.open(); err != nil {
+		return nil, err
+	}
+	return logFile, nil
+}
+
+// open starts appending to the file at path, continuing one left by an earlier run
+func (logFile *rotatingFile) open() error {
+	file, err := logFile.fs.OpenFile(logFile.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	logFile.file, logFile.size, logFile.opened = file, info.Size(), time.Now()
+	return nil
+}
+
+// Write appends p to the current file, rotating first if p would make it too large or it is too old
+func (logFile *rotatingFile) Write(p []byte) (int, error) {
+	logFile.mutex.Lock()
+	defer logFile.mutex.Unlock()
+	tooBig := logFile.maxBytes > 0 && logFile.size > 0 && logFile.size+int64(len(p)) > logFile.maxBytes
+	tooOld := logFile.every > 0 && time.Since(logFile.opened) >= logFile.every
+	if tooBig || tooOld {
+		if err := logFile.rotate(); err != nil {
+			fmt.Fprintf(os.Stderr, "rotating log file %s: %v\n", logFile.path, err) // Keep writing to the current file
+		}
+	}
+	written, err := logFile.file.Write(p)
+	logFile.size += int64(written)
+	return written, err
+}
+
+// rotate renames the current file out of the way, starts a new one and prunes old rotations
+func (logFile *rotatingFile) rotate() error {
+	rotated := logFile.path + "." + time.Now().Format(rotatedTimeLayout)
+	if err := logFile.file.Close(); err != nil {
+		return err
+	}
+	renameErr := logFile.fs.Rename(logFile.path, rotated)
+	if err := logFile.open(); err != nil {
+		return errors.Join(renameErr, err)
+	}
+	if renameErr != nil {
+		return renameErr
+	}
+	previous, err := logFile.fs.Glob(logFile.path + ".*") // Rotated files, oldest first once sorted
+	if err != nil {
+		return err
+	}
+	slices.Sort(previous)
+	for len(previous) > logFile.keep {
+		discardFile(logFile.fs, previous[0])
+		previous = previous[1:]
+	}
+	return nil
+}
+
+// Close closes the current file
+func (logFile *rotatingFile) Close() error {
+	logFile.mutex.Lock()
+	defer logFile.mutex.Unlock()
+	return logFile.file.Close()
+}
+
+// teeHandler sends every record to several handlers, such as the console and a log file
+type teeHandler []slog.Handler
+
+// Enabled reports whether any handler wants records at level
+func (handlers teeHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return slices.ContainsFunc(handlers, func(handler slog.Handler) bool { return handler.Enabled(ctx, level) })
+}
+
+// Handle passes the record to every handler that wants it
+func (handlers teeHandler) Handle(ctx context.Context, record slog.Record) error {
+	var err error
+	for _, handler := range handlers {
+		if handler.Enabled(ctx, record.Level) {
+			err = errors.Join(err, handler.Handle(ctx, record.Clone()))
+		}
+	}
+	return err
+}
+
+// WithAttrs returns a tee of every handler with attrs added
+func (handlers teeHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	tee := make(teeHandler, len(handlers))
+	for i, handler := range handlers {
+		tee[i] = handler.WithAttrs(attrs)
+	}
+	return tee
+}
+
+// WithGroup returns a tee of every handler with the group opened
+func (handlers teeHandler) WithGroup(name string) slog.Handler {
+	tee := make(teeHandler, len(handlers))
+	for i, handler := range handlers {
+		tee[i] = handler.WithGroup(name)
+	}
+	return tee
+}
@@ -2,275 +2,388 @@ package main // Declare the main package
 
 // Import required standard library packages
 import (
-	"bytes"         // Provides buffer for reading/writing data
+	"context"       // For cancelling in-flight work
+	"errors"        // For creating error values
 	"fmt"           // For formatted I/O operations
 	"io"            // For general I/O primitives
-	"log"           // For logging errors or info
-	"net/http"      // For making HTTP requests
-	"net/url"       // For parsing and manipulating URLs
+	"log/slog"      // For structured, levelled logging
 	"os"            // For file and system operations
+	"os/signal"     // For catching interrupt signals
 	"path/filepath" // For manipulating filename paths
-	"regexp"        // For using regular expressions
-	"strings"       // For string manipulation
-	"sync"          // For handling concurrency
+	"syscall"       // For the SIGTERM signal value
 	"time"          // For time-related operations
 )
 
-// removeDuplicatesFromSlice removes duplicate strings from a slice
-func removeDuplicatesFromSlice(slice []string) []string {
-	check := make(map[string]bool)  // Map to keep track of seen strings
-	var newReturnSlice []string     // Result slice for unique values
-	for _, content := range slice { // Iterate through each string in the input slice
-		if !check[content] { // If string not already seen
-			check[content] = true                            // Mark string as seen
-			newReturnSlice = append(newReturnSlice, content) // Add it to result slice
+// run performs a whole scraping run with config and returns an error if it failed or was
+// cancelled, after logging it
+func run(config Config) (err error) {
+	fsys := config.fileSystem() // Where the run's files live
+	var logFile io.Writer       // Set with -log-file
+	var rotating *rotatingFile  // The -log-file, closed once the outcome is logged to it
+	defer func() {
+		if err != nil {
+			slog.Error("Run failed", "error", err) // Explain the exit status
+		}
+		if rotating != nil {
+			rotating.Close()
+		}
+	}()
+	if config.LogFile != "" {
+		rotating, err = newRotatingFile(fsys, config.LogFile, config.LogMaxSize<<20, config.LogRotateEvery, config.LogKeep)
+		if err != nil {
+			return fmt.Errorf("opening log file: %w", err)
 		}
+		logFile = rotating // Writes aren't buffered, so nothing is lost if the run dies
 	}
-	return newReturnSlice // Return the new slice with duplicates removed
-}
-
-// isUrlValid checks whether a URL is syntactically valid
-func isUrlValid(uri string) bool {
-	_, err := url.ParseRequestURI(uri) // Try to parse the URL
-	return err == nil                  // Return true if no error (i.e., valid URL)
-}
-
-// readFileAndReturnAsString reads a file and returns its content as string
-func readFileAndReturnAsString(path string) string {
-	content, err := os.ReadFile(path) // Read the file contents
-	if err != nil {                   // If an error occurs during reading
-		log.Println(err) // Log the error
+	if err := setupLogging(config.LogFormat, config.LogLevel, logFile); err != nil {
+		return err // Logger isn't ready
 	}
-	return string(content) // Return the content as a string
-}
-
-// fileExists checks whether a file exists and is not a directory
-func fileExists(filename string) bool {
-	info, err := os.Stat(filename) // Get file info
-	if err != nil {                // If error occurs (e.g., file not found)
-		return false // Return false
+	if config.CheckpointFile != "" {
+		slog.Warn("-checkpoint is deprecated and ignored: -html-cache is now a directory whose cached pages mark the finished ones", "checkpoint", config.CheckpointFile, "html_cache", config.HTMLCacheDir)
 	}
-	return !info.IsDir() // Return true if it is a file, not a directory
-}
-
-// getDataFromURL sends an HTTP GET request and writes response data to a file
-func getDataFromURL(uri string, fileName string, wg *sync.WaitGroup) {
-	defer wg.Done() // Mark goroutine as done when function finishes
 
-	var httpClient = &http.Client{
-		Timeout: 90 * time.Second, // Set timeout for request
+	if config.BasicAuth != "" && config.BearerToken != "" {
+		return errors.New("-basic-auth and -bearer-token can't be used together") // Only one Authorization header
 	}
-
-	response, err := httpClient.Get(uri) // Send HTTP GET request
-	if err != nil {
-		log.Printf("HTTP GET failed for %s: %v", uri, err) // Log error
-		return
+	if config.Archive != "" && config.Storage != "" {
+		return errors.New("-archive and -storage can't be used together") // Only one place to store PDFs
 	}
-	defer func() {
-		if err := response.Body.Close(); err != nil {
-			log.Printf("Error closing response body for %s: %v", uri, err) // Log error on closing
+	if config.OutDB != "" && (config.Storage != "" || config.Archive != "") {
+		return errors.New("-out-db can't be used with -storage or -archive") // Only one place to store PDFs
+	}
+	if config.ValidateExisting && !config.localFiles() {
+		return errors.New("-validate-existing checks the output directory, not -storage, -archive or -out-db blobs") // Nothing local to check
+	}
+	if len(config.OnDownload) > 0 && !config.localFiles() {
+		return errors.New("-on-download needs PDFs in the output directory, not -storage, -archive or -out-db blobs") // Nothing local to run it on
+	}
+	if config.HeadOnly && config.DryRun {
+		return errors.New("-head-only and -dry-run can't be used together") // Both replace the downloads
+	}
+	if config.ListOnly && (config.HeadOnly || config.DryRun) {
+		return errors.New("-list-only can't be used with -head-only or -dry-run") // All three replace the downloads
+	}
+	if config.EventsJSON == "-" && config.ListOnly && config.URLsOut == "" {
+		return errors.New("-events-json - and -list-only can't both write to stdout") // The streams would interleave
+	}
+	if config.URLsOut != "" && !config.ListOnly {
+		return errors.New("-urls-out needs -list-only") // Nothing else writes the list
+	}
+	if config.URLFile != "" && config.Sitemap != "" {
+		return errors.New("-url-file and -sitemap can't be used together") // Only one source of links
+	}
+	if config.ConcurrencyAuto && (config.DownloadWorkers > 0 || config.Deterministic) {
+		return errors.New("-concurrency-auto can't be used with -download-workers or -deterministic") // They fix the number of downloads
+	}
+	if config.ConcurrencyAuto && (config.ConcurrencyMin < 1 || config.ConcurrencyMax < config.ConcurrencyMin) {
+		return errors.New("-concurrency-min must be at least 1 and no more than -concurrency-max") // Empty range to tune in
+	}
+	if config.ScrapeWorkers < 0 || config.DownloadWorkers < 0 || config.QueueSize < 0 {
+		return errors.New("-scrape-workers, -download-workers and -queue-size can't be negative") // 0 already means the default
+	}
+	if config.MaxIdleConns < 0 || config.MaxConnsPerHost < 0 {
+		return errors.New("-max-idle-conns and -max-conns-per-host can't be negative") // 0 already means the default
+	}
+	if config.MaxRedirects < 0 {
+		return errors.New("-max-redirects can't be negative") // 0 already refuses every redirect
+	}
+	if config.AdaptiveRPS && config.RequestsPerSec <= 0 {
+		return errors.New("-adaptive-rps needs a -rps rate to adjust") // Nothing to slow down from
+	}
+	if config.JitterMin < 0 || config.JitterMax < config.JitterMin {
+		return errors.New("-jitter-min must be at least 0 and no more than -jitter-max") // Empty delay range
+	}
+	if config.ListOnly {
+		config.DryRun = true // A dry run whose listing is the URLs alone
+	}
+	if config.Deterministic {
+		config.Concurrency, config.ScrapeWorkers, config.DownloadWorkers = 1, 1, 1 // One request at a time, so logs come out in the same order
+	}
+	if config.Shuffle {
+		if config.Seed == 0 {
+			config.Seed = time.Now().UnixNano() // Logged below so the run can be repeated
 		}
-	}()
-
-	finalURL := response.Request.URL.String() // Get final URL after redirects
-	log.Printf("Final URL after redirects: %s", finalURL)
-
-	if response.StatusCode != http.StatusOK { // Check if status is not 200 OK
-		log.Printf("Non-OK HTTP status %d for URL %s", response.StatusCode, finalURL)
-		return
+		slog.Info("Shuffling the crawl", "seed", config.Seed, "jitter_min", config.JitterMin, "jitter_max", config.JitterMax)
 	}
-
-	body, err := io.ReadAll(response.Body) // Read the response body
-	if err != nil {
-		log.Printf("Failed to read body for %s: %v", finalURL, err)
-		return
+	if config.FailuresFile == "" {
+		config.FailuresFile = filepath.Join(config.OutputDir, failuresFileName) // Dead letters live next to the PDFs
 	}
-
-	if err := appendByteToFile(fileName, body); err != nil { // Append response data to file
-		log.Printf("Failed to write body to file for %s: %v", finalURL, err)
-		return
+	if config.Verify != "" {
+		return verify(config) // Check an existing mirror; nothing is fetched
+	}
+	if config.ReportDupes {
+		return reportDupes(config) // Analyze an existing mirror; nothing is fetched
 	}
 
-	log.Println("Completed Scraping URL:", finalURL) // Log successful scrape
-}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM) // Cancel on Ctrl-C or SIGTERM
+	defer stop()                                                                           // Stop catching signals on exit
+	if config.Deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, config.Deadline, fmt.Errorf("%w of %s", errDeadline, config.Deadline)) // Hard cap on the run
+		defer cancel()
+	}
+	ctx, abort := context.WithCancelCause(ctx) // Cancel on fatal errors such as a full disk
+	defer abort(nil)
 
-// urlToFilename converts a URL into a filesystem-safe filename
-func urlToFilename(rawURL string) string {
-	parsed, err := url.Parse(rawURL) // Parse the URL
-	if err != nil {
-		log.Println(err) // Log parsing error
-		return ""        // Return empty string if parsing fails
+	client := newHTTPClient(config) // One client, and connection pool, for the whole run
+	if config.Trace {
+		if !slog.Default().Enabled(ctx, slog.LevelDebug) {
+			slog.Warn("-trace logs at debug level; add -log-level debug to see the timings")
+		}
+		client.Transport = &tracingTransport{base: client.Transport} // Log the phase durations of every request sent
 	}
-	filename := parsed.Host // Start with the host part of the URL
-	if parsed.Path != "" {
-		filename += "_" + strings.ReplaceAll(parsed.Path, "/", "_") // Replace slashes with underscores
+	if !config.IgnoreRobots {
+		robots, err := loadRobots(ctx, client, config) // Be a good citizen
+		if err != nil {
+			slog.Warn("Could not load robots.txt, continuing without it", "error", err)
+		}
+		client.Transport = &robotsTransport{base: client.Transport, host: searchBaseURL.Host, robots: robots} // Skip disallowed URLs
+		if delay := robots.crawlDelay; delay > 0 && (config.RequestsPerSec <= 0 || time.Duration(float64(time.Second)/config.RequestsPerSec) < delay) {
+			config.RequestsPerSec = float64(time.Second) / float64(delay) // Slow down to the requested crawl-delay
+			slog.Info("Honoring robots.txt crawl-delay", "crawl_delay", delay, "rps", config.RequestsPerSec)
+		}
 	}
-	if parsed.RawQuery != "" {
-		filename += "_" + strings.ReplaceAll(parsed.RawQuery, "&", "_") // Replace & in query with underscore
+	scraper := newScraper(config, client, abort) // Shared limiter and counters for the run
+	summary := os.Stdout                         // Where the end-of-run report goes
+	if (config.ListOnly && config.URLsOut == "") || config.EventsJSON == "-" {
+		summary = os.Stderr // Keep stdout to the URL list or events, for piping into other tools
 	}
-	invalidChars := []string{`"`, `\`, `/`, `:`, `*`, `?`, `<`, `>`, `|`} // Characters not allowed in filenames
-	for _, char := range invalidChars {
-		filename = strings.ReplaceAll(filename, char, "_") // Replace invalid characters
+	defer scraper.stats.printSlowest(summary, config.Slowest) // Runs after the summary
+	defer scraper.stats.printSummary(summary)                 // Report what the run did on exit
+	if config.EventsJSON != "" {
+		events, err := openEventStream(fsys, config.EventsJSON)
+		if err != nil {
+			return fmt.Errorf("opening event stream: %w", err)
+		}
+		defer events.Close()
+		defer func() { events.emit(Event{Event: eventRunSummary, Summary: scraper.stats.summary()}) }() // Always the last event
+		scraper.events = events
 	}
-	if getFileExtension(filename) != ".pdf" {
-		filename = filename + ".pdf" // Ensure file ends with .pdf
+	client.Transport = &countingTransport{base: client.Transport, requests: &scraper.stats.Requests} // Count requests for the summary and metrics
+	scraper.pause = newPauseGate(&scraper.stats.Paused)                                              // SIGUSR1 pauses, SIGUSR2 resumes
+	defer scraper.pause.close()                                                                      // Count a pause still going on in the summary
+	go scraper.pause.watchPauseSignals(ctx)
+	if config.PauseFile != "" {
+		go scraper.pause.watchPauseFile(ctx, fsys, config.PauseFile) // Paused while the file exists
 	}
-	return strings.ToLower(filename) // Return sanitized and lowercased filename
-}
-
-// getFileExtension returns the file extension
-func getFileExtension(path string) string {
-	return filepath.Ext(path) // Use filepath to extract extension
-}
-
-// appendByteToFile appends byte data to a file (creates file if it doesn’t exist)
-func appendByteToFile(filename string, data []byte) error {
-	file, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644) // Open or create file
-	if err != nil {
-		return err // Return error if file can’t be opened
+	if scraper.concurrency != nil {
+		client.Transport = &observingTransport{base: client.Transport, controller: scraper.concurrency} // Every response informs the download level
 	}
-	defer file.Close()        // Ensure file is closed
-	_, err = file.Write(data) // Write data to file
-	return err                // Return error if write fails
-}
-
-// downloadPDF downloads a PDF from a URL and saves it to outputDir
-func downloadPDF(finalURL, outputDir string, waitGroup *sync.WaitGroup) {
-	defer waitGroup.Done()
-	filename := strings.ToLower(urlToFilename(finalURL)) // Create sanitized filename
-	filePath := filepath.Join(outputDir, filename)       // Combine with output directory
-
-	if fileExists(filePath) {
-		log.Printf("file already exists, skipping: %s", filePath)
-		return
+	if config.MetricsAddr != "" {
+		stopMetrics, err := startMetricsServer(ctx, config.MetricsAddr, scraper.stats) // Exposes the summary's counters while running
+		if err != nil {
+			return err
+		}
+		defer stopMetrics()
 	}
 
-	client := &http.Client{Timeout: 30 * time.Second} // HTTP client with timeout
-	resp, err := client.Get(finalURL)                 // Send HTTP GET
-	if err != nil {
-		log.Printf("failed to download %s: %v", finalURL, err)
-		return
+	source := scraper.crawlSearchLinks // Crawl the search pages
+	if config.Sitemap != "" {
+		source = scraper.crawlSitemap // Follow the sitemap instead
 	}
-	defer resp.Body.Close() // Ensure response body is closed
-
-	if resp.StatusCode != http.StatusOK {
-		log.Printf("download failed for %s: %s", finalURL, resp.Status)
-		return
+	if config.URLFile != "" {
+		urls, err := readURLFile(fsys, config.URLFile) // Use the given list instead of crawling
+		if err != nil {
+			return fmt.Errorf("reading URL file: %w", err)
+		}
+		source = func(ctx context.Context, emit func(PDFLink)) error {
+			for _, uri := range urls {
+				emit(PDFLink{URL: uri}) // Dates are unknown
+			}
+			return nil
+		}
 	}
-
-	contentType := resp.Header.Get("Content-Type") // Get content-type header
-	if !strings.Contains(contentType, "application/pdf") {
-		log.Printf("invalid content type for %s: %s (expected application/pdf)", finalURL, contentType)
-		return
+	if config.Deterministic {
+		source = sortedSource(source) // Same download order however the crawl interleaved
 	}
 
-	var buf bytes.Buffer                     // Create buffer
-	written, err := io.Copy(&buf, resp.Body) // Copy response body to buffer
-	if err != nil {
-		log.Printf("failed to read PDF data from %s: %v", finalURL, err)
-		return
+	outputDir := config.OutputDir                              // Directory to save PDFs
+	manifestPath := filepath.Join(outputDir, manifestFileName) // Manifest lives next to the PDFs
+	var downloader Downloader = DownloaderFunc(scraper.downloadPDF)
+	if config.ResumePartial {
+		downloader = resumeDownloader{next: downloader} // Keep and continue interrupted downloads
 	}
-	if written == 0 {
-		log.Printf("downloaded 0 bytes for %s; not creating file", finalURL)
-		return
+	if config.Precheck {
+		downloader = precheckDownloader{next: downloader, scraper: scraper} // HEAD first, to skip PDFs over the size cap
 	}
-
-	out, err := os.Create(filePath) // Create output file
-	if err != nil {
-		log.Printf("failed to create file for %s: %v", finalURL, err)
-		return
+	if config.HeadOnly {
+		downloader = DownloaderFunc(scraper.catalogPDF) // Record what the documents are without their bodies
 	}
-	defer out.Close() // Close file
-
-	_, err = buf.WriteTo(out) // Write buffer to file
+	var archive *archiveStorage // Set with -archive
+	if config.NewList != "" && fileExists(fsys, config.NewList) {
+		if err := removeFile(fsys, config.NewList); err != nil { // Only list this run's new documents
+			return fmt.Errorf("clearing new document list: %w", err)
+		}
+	}
+	if config.DryRun {
+		scraper.storage = &localStorage{fs: fsys, dir: outputDir} // Only read, for the manifest's filenames
+		downloader = dryRunDownloader{dir: outputDir}             // URL and would-be path, instead of downloading
+		if config.ListOnly {
+			lister, err := newURLLister(fsys, config.URLsOut, config.ListFormat) // Stdout or -urls-out
+			if err != nil {
+				return fmt.Errorf("opening URL list: %w", err)
+			}
+			defer func() {
+				if err := lister.Close(); err != nil {
+					slog.Error("Failed to write URL list", "error", err)
+				}
+			}()
+			downloader = lister
+		}
+	} else {
+		if !directoryExists(fsys, outputDir) {
+			if err := createDirectory(fsys, outputDir, 0o755); err != nil { // Create directory if not exists
+				return fmt.Errorf("creating output directory: %w", err) // Nowhere to save PDFs
+			}
+		}
+		lock, err := lockDirectory(fsys, outputDir) // Two instances would race on the same files
+		if err != nil {
+			if !config.Force {
+				return err
+			}
+			slog.Warn("Ignoring the output directory lock because of -force", "error", err)
+		} else {
+			defer lock.Release()
+		}
+		removeStaleTempFiles(fsys, outputDir) // Clean up after an earlier killed run
+		if config.TempDir != "" {
+			if err := createDirectory(fsys, config.TempDir, 0o755); err != nil {
+				return fmt.Errorf("creating temp directory: %w", err)
+			}
+			removeStaleTempFiles(fsys, config.TempDir) // Downloads of an earlier killed run staged there
+		}
+		if fileExists(fsys, config.FailuresFile) {
+			if err := removeFile(fsys, config.FailuresFile); err != nil { // Only list this run's failures; -url-file was read already
+				return fmt.Errorf("clearing failures file: %w", err)
+			}
+		}
+		if config.Archive != "" {
+			archive, err = newArchiveStorage(fsys, config.Archive) // One file holding this run's PDFs
+			scraper.storage = archive
+		} else if config.OutDB != "" {
+			var files Storage // Content kept in the database
+			if !config.DBBlobs {
+				files = &localStorage{fs: fsys, dir: outputDir} // Content kept in the output directory, listed in the database
+			}
+			var database *dbStorage
+			if database, err = newDBStorage(ctx, config.OutDB, files); err == nil {
+				defer database.Close()
+				scraper.storage = database
+			}
+		} else {
+			scraper.storage, err = newStorage(ctx, fsys, config.Storage, outputDir) // Local directory or S3 bucket
+		}
+		if err != nil {
+			return fmt.Errorf("opening storage: %w", err)
+		}
+	}
+	if config.ValidateExisting && !config.DryRun {
+		report, err := validateExisting(fsys, manifestPath, outputDir, ownFiles(config), config.MinBytes, config.ValidateChecksums, config.ValidateKeep)
+		if err != nil {
+			return fmt.Errorf("validating existing files: %w", err)
+		}
+		printValidateReport(os.Stdout, report) // Before the download phase refetches the removed ones
+	}
+	previousManifest := manifestPath
+	if archive != nil {
+		previousManifest = "" // A new archive only lists what this run stores in it
+	}
+	scraper.recorder, err = newManifestRecorder(fsys, previousManifest, scraper.storage, config.IgnoreParams) // Keep entries from earlier runs
 	if err != nil {
-		log.Printf("failed to write PDF to file for %s: %v", finalURL, err)
-		return
+		return fmt.Errorf("reading manifest: %w", err)
 	}
-}
 
-// directoryExists checks whether a directory exists
-func directoryExists(path string) bool {
-	directory, err := os.Stat(path) // Get directory info
-	if err != nil {
-		return false // If error, directory doesn't exist
+	scraper.names = newNameAssigner(config, scraper.recorder.SourceFilenames()) // Collision-free output names, stable across runs
+	sourceErr := scraper.runPipeline(ctx, source, downloader)                   // Download PDFs while the crawl finds them
+	if archive != nil {
+		manifest, err := scraper.recorder.Encode()
+		if err == nil {
+			err = archive.Finish(manifest) // The manifest is the archive's last entry
+		}
+		if err != nil {
+			return fmt.Errorf("writing manifest: %w", err)
+		}
+	} else if !config.DryRun {
+		if err := scraper.recorder.WriteFile(manifestPath); err != nil {
+			return fmt.Errorf("writing manifest: %w", err)
+		}
+	}
+	if sourceErr != nil {
+		return fmt.Errorf("collecting PDF links: %w", sourceErr)
+	}
+	if ctx.Err() != nil {
+		return cancelled(ctx)
+	}
+	if !config.KeepHTML && config.URLFile == "" && !scraper.stats.LimitReached.Load() && scraper.stats.PagesFailed.Load() == 0 {
+		if err := clearPageCache(fsys, config.HTMLCacheDir); err != nil { // The crawl finished, so the cache did its job
+			return fmt.Errorf("removing HTML cache: %w", err)
+		}
 	}
-	return directory.IsDir() // Return true if path is a directory
+	if failed := scraper.stats.Failed.Load(); config.MaxFailures >= 0 && failed > int64(config.MaxFailures) {
+		return fmt.Errorf("%d downloads failed (allowed: %d)", failed, config.MaxFailures) // Too many failures
+	}
+	return nil
 }
 
-// createDirectory creates a directory with specified permissions
-func createDirectory(path string, permission os.FileMode) {
-	err := os.Mkdir(path, permission) // Attempt to create directory
-	if err != nil {
-		log.Println(err) // Log any error
+// errDeadline is the cause a run is cancelled with once -deadline has passed
+var errDeadline = errors.New("reached -deadline")
+
+// cancelled explains why ctx was cancelled: a fatal error such as a full disk, the -deadline,
+// or a signal
+func cancelled(ctx context.Context) error {
+	cause := context.Cause(ctx)
+	if errors.Is(cause, errDiskFull) {
+		return fmt.Errorf("run aborted: %w", cause) // Every further write would fail too
+	}
+	if errors.Is(cause, errDeadline) {
+		return fmt.Errorf("run stopped: %w; the summary covers the partial run", cause)
 	}
+	return fmt.Errorf("run cancelled: %w", cause)
 }
 
-// extractPDFLinks scans HTML and extracts all unique .pdf links
-func extractPDFLinks(htmlContent string) []string {
-	pdfRegex := regexp.MustCompile(`https?://[^\s"'<>]+?\.pdf(?:\?[^\s"'<>]*)?`) // Regex to find .pdf URLs
-	seen := make(map[string]struct{})                                            // Track seen links
-	var links []string
-
-	for _, line := range strings.Split(htmlContent, "\n") { // Process each line
-		for _, match := range pdfRegex.FindAllString(line, -1) { // Find matches
-			if _, ok := seen[match]; !ok { // If link is new
-				seen[match] = struct{}{}     // Mark as seen
-				links = append(links, match) // Add to list
-			}
+// ownFiles returns the names, relative to the output directory, of the scraper's own files that
+// may be kept in it, such as the manifest and the failures file
+func ownFiles(config Config) []string {
+	var ignored []string
+	paths := []string{config.Verify, config.FailuresFile, config.NewList, filepath.Join(config.OutputDir, manifestFileName)}
+	if config.OutDB != "" {
+		paths = append(paths, config.OutDB, config.OutDB+"-wal", config.OutDB+"-shm") // Database and its WAL files
+	}
+	for _, path := range paths {
+		if name, err := filepath.Rel(config.OutputDir, path); err == nil && path != "" {
+			ignored = append(ignored, name)
 		}
 	}
-
-	return links // Return list of links
+	return ignored
 }
 
-// removeFile deletes a file from the filesystem
-func removeFile(path string) {
-	err := os.Remove(path) // Try to delete file
+// reportDupes prints the groups of byte-identical documents in the output directory
+func reportDupes(config Config) error {
+	clusters, err := findDuplicates(config.fileSystem(), filepath.Join(config.OutputDir, manifestFileName), config.OutputDir, ownFiles(config))
 	if err != nil {
-		log.Println(err) // Log error if deletion fails
+		return fmt.Errorf("finding duplicates: %w", err)
 	}
+	printDuplicateReport(os.Stdout, clusters)
+	return nil
 }
 
-// main is the entry point of the program
-func main() {
-	filename := "index.html" // Filename to save scraped HTML
-
-	if fileExists(filename) {
-		// removeFile(filename) // Remove old version of file
-		log.Println("Skipping the removing the html file.")
-	}
-
-	if !fileExists(filename) {
-		var htmlDownloadWaitGroup sync.WaitGroup // WaitGroup to manage goroutines
-		letters := "abcdefghijklmnopqrstuvwxyz"  // Loop over each letter
-		for _, letter := range letters {
-			for i := 0; i <= 300; i++ {
-				url := fmt.Sprintf("https://www.airgas.com/sds-search?searchKeyWord=%c&sortOrder=&searchPureGases=false&searchMixedGases=false&searchHardGoods=false&maintainType=true&page=%d", letter, i)
-				if isUrlValid(url) {
-					// time.Sleep(100 * time.Millisecond) // Wait to avoid overwhelming server
-					htmlDownloadWaitGroup.Add(1)                             // Add to WaitGroup
-					go getDataFromURL(url, filename, &htmlDownloadWaitGroup) // Download in goroutine
-				}
-			}
-		}
-		htmlDownloadWaitGroup.Wait() // Wait for all downloads to complete
+// verify checks the output directory against the manifest named by -verify, prints every
+// difference and returns an error if there were any
+func verify(config Config) error {
+	report, err := verifyManifest(config.fileSystem(), config.Verify, config.OutputDir, ownFiles(config))
+	if err != nil {
+		return fmt.Errorf("verifying %s: %w", config.Verify, err)
 	}
-
-	var extractedURL []string                              // Store extracted PDF URLs
-	fileContent := readFileAndReturnAsString(filename)     // Read saved HTML
-	extractedURL = extractPDFLinks(fileContent)            // Extract .pdf links
-	extractedURL = removeDuplicatesFromSlice(extractedURL) // Remove duplicate links
-	var downloadPDFWaitGroup sync.WaitGroup
-	outputDir := "PDFs/" // Directory to save PDFs
-	if !directoryExists(outputDir) {
-		createDirectory(outputDir, 0o755) // Create directory if not exists
+	printVerifyReport(os.Stdout, report)
+	if count := report.Discrepancies(); count > 0 {
+		return fmt.Errorf("%d files differ from %s", count, config.Verify) // Fail the sync gate
 	}
+	return nil
+}
 
-	for _, url := range extractedURL {
-		// time.Sleep(100 * time.Millisecond) // Wait to avoid overwhelming server
-		downloadPDFWaitGroup.Add(1)
-		go downloadPDF(url, outputDir, &downloadPDFWaitGroup) // Try to download PDF
+// main is the entry point of the program
+func main() {
+	if err := run(parseFlags()); err != nil {
+		os.Exit(1) // Signal failure to cron jobs and CI; run logged why
 	}
-	downloadPDFWaitGroup.Wait()
 }
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json" // For reading and writing the manifest
+	"log/slog"      // For structured, levelled logging
+	"slices"        // For searching slices
+	"sort"          // For ordering manifest entries
+	"sync"          // For handling concurrency
+	"time"          // For time-related operations
+)
+
+// manifestFileName is the name of the manifest written into the output directory
+const manifestFileName = "manifest.json"
+
+// ManifestEntry records one downloaded PDF
+type ManifestEntry struct {
+	SourceURL     string    `json:"source_url"`               // URL the download was requested from
+	FinalURL      string    `json:"final_url"`                // URL after following redirects
+	Filename      string    `json:"filename"`                 // Path of the file relative to the storage root
+	Size          int64     `json:"size"`                     // Size of the file in bytes
+	ContentLength int64     `json:"content_length,omitempty"` // Complete size the server declared, by Content-Length or Content-Range, when it did
+	SHA256        string    `json:"sha256"`                   // Hex-encoded SHA-256 checksum of the file
+	DownloadedAt  time.Time `json:"downloaded_at"`            // When the file was downloaded
+	AlternateURLs []string  `json:"alternate_urls,omitempty"` // Other URLs that served byte-identical content
+	ETag          string    `json:"etag,omitempty"`           // ETag header returned with the file
+	LastModified  string    `json:"last_modified,omitempty"`  // Last-Modified header returned with the file
+	ContentType   string    `json:"content_type,omitempty"`   // Content-Type header, recorded by -head-only
+	DurationMS    int64     `json:"duration_ms,omitempty"`    // How long the download took, in milliseconds
+}
+
+// Manifest lists every PDF in the output directory
+type Manifest struct {
+	GeneratedAt time.Time       `json:"generated_at"` // When the manifest was written
+	Entries     []ManifestEntry `json:"entries"`      // One entry per downloaded PDF, sorted by filename
+}
+
+// ManifestRecorder collects manifest entries from many goroutines
+type ManifestRecorder struct {
+	mutex      sync.Mutex               // Guards entries, seenHashes and byURL
+	entries    map[string]ManifestEntry // Entries keyed by filename
+	seenHashes map[string]string        // SHA-256 checksum → filename of the file holding that content
+	byURL      map[string]string        // Canonical source, final or alternate URL → filename of its entry
+	ignored    []string                 // Query parameters the canonical URLs leave out
+	fs         FS                       // Filesystem the manifest is read from and written to
+}
+
+// newManifestRecorder creates a recorder seeded with the entries of an existing manifest on
+// fsys, if one exists; a manifest that can't be parsed is ignored, one that can't be read is an error; URLs
+// are looked up ignoring the given query parameters
+func newManifestRecorder(fsys FS, path string, storage Storage, ignored []string) (*ManifestRecorder, error) {
+	recorder := &ManifestRecorder{entries: make(map[string]ManifestEntry), seenHashes: make(map[string]string), byURL: make(map[string]string), ignored: ignored, fs: fsys}
+	if !fileExists(fsys, path) {
+		return recorder, nil // Nothing recorded yet
+	}
+	content, err := readFileAndReturnAsString(fsys, path) // Read the previous manifest
+	if err != nil {
+		return nil, err
+	}
+	var manifest Manifest
+	if err := json.Unmarshal([]byte(content), &manifest); err != nil {
+		slog.Warn("Ignoring unreadable manifest", "path", path, "error", err) // Start over with a fresh manifest
+		return recorder, nil
+	}
+	for _, entry := range manifest.Entries {
+		recorder.entries[entry.Filename] = entry // Keep entries from previous runs
+		recorder.index(entry)
+		if entry.SHA256 != "" && storage.Exists(entry.Filename) { // -head-only entries have no checksum
+			recorder.seenHashes[entry.SHA256] = entry.Filename // Content already on disk
+		}
+	}
+	return recorder, nil
+}
+
+// SourceFilenames returns the filename recorded for each source URL
+func (recorder *ManifestRecorder) SourceFilenames() map[string]string {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	filenames := make(map[string]string, len(recorder.entries))
+	for filename, entry := range recorder.entries {
+		filenames[entry.SourceURL] = filename
+	}
+	return filenames
+}
+
+// FilenameForURL returns the filename of the entry downloaded from uri, whether as its source,
+// final or an alternate URL, however the URL is spelled
+func (recorder *ManifestRecorder) FilenameForURL(uri string) (string, bool) {
+	canonical := canonicalizeURL(uri, recorder.ignored)
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	filename, ok := recorder.byURL[canonical]
+	return filename, ok
+}
+
+// index maps the canonical form of each of entry's URLs to its filename; the caller holds the mutex
+func (recorder *ManifestRecorder) index(entry ManifestEntry) {
+	for _, uri := range append([]string{entry.SourceURL, entry.FinalURL}, entry.AlternateURLs...) {
+		if uri != "" {
+			recorder.byURL[canonicalizeURL(uri, recorder.ignored)] = entry.Filename
+		}
+	}
+}
+
+// unindex removes the URLs of entry that still map to its filename; the caller holds the mutex
+func (recorder *ManifestRecorder) unindex(entry ManifestEntry) {
+	for _, uri := range append([]string{entry.SourceURL, entry.FinalURL}, entry.AlternateURLs...) {
+		if canonical := canonicalizeURL(uri, recorder.ignored); recorder.byURL[canonical] == entry.Filename {
+			delete(recorder.byURL, canonical)
+		}
+	}
+}
+
+// Lookup returns the recorded entry for filename, if any
+func (recorder *ManifestRecorder) Lookup(filename string) (ManifestEntry, bool) {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	entry, ok := recorder.entries[filename] // Entry from this or an earlier run
+	return entry, ok
+}
+
+// ClaimContent registers filename as the holder of checksum; if another file already
+// holds that content, its filename is returned with true and nothing is registered
+func (recorder *ManifestRecorder) ClaimContent(checksum string, filename string) (string, bool) {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	if existing, ok := recorder.seenHashes[checksum]; ok {
+		return existing, true // Same bytes already saved under another name
+	}
+	recorder.seenHashes[checksum] = filename // First file with this content
+	return "", false
+}
+
+// ReleaseContent forgets the claim on checksum after its file failed to be written
+func (recorder *ManifestRecorder) ReleaseContent(checksum string) {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	delete(recorder.seenHashes, checksum) // Let another URL with this content try again
+}
+
+// AddAlternateURL notes that uri served the same content as the file with the given filename
+func (recorder *ManifestRecorder) AddAlternateURL(filename string, uri string) {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	entry, ok := recorder.entries[filename]
+	if !ok || entry.SourceURL == uri || slices.Contains(entry.AlternateURLs, uri) {
+		return // Unknown file or URL already recorded
+	}
+	entry.AlternateURLs = append(entry.AlternateURLs, uri) // Remember the duplicate source
+	recorder.entries[filename] = entry
+	recorder.byURL[canonicalizeURL(uri, recorder.ignored)] = filename
+}
+
+// Record adds or replaces the entry for a downloaded file
+func (recorder *ManifestRecorder) Record(entry ManifestEntry) {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	if previous, ok := recorder.entries[entry.Filename]; ok {
+		recorder.unindex(previous) // URLs the file no longer comes from
+	}
+	recorder.entries[entry.Filename] = entry // Latest download wins
+	recorder.index(entry)
+}
+
+// WriteFile writes all recorded entries to path as indented JSON
+func (recorder *ManifestRecorder) WriteFile(path string) error {
+	data, err := recorder.Encode()
+	if err != nil {
+		return err
+	}
+	return writeFile(recorder.fs, path, data, 0o644) // Write manifest to disk
+}
+
+// Encode returns all recorded entries as indented JSON, sorted by filename
+func (recorder *ManifestRecorder) Encode() ([]byte, error) {
+	recorder.mutex.Lock()
+	manifest := Manifest{GeneratedAt: time.Now().UTC()}
+	for _, entry := range recorder.entries {
+		manifest.Entries = append(manifest.Entries, entry) // Collect entries
+	}
+	recorder.mutex.Unlock()
+
+	sort.Slice(manifest.Entries, func(i, j int) bool {
+		return manifest.Entries[i].Filename < manifest.Entries[j].Filename // Stable order for diffing runs
+	})
+	data, err := json.MarshalIndent(manifest, "", "  ") // Encode manifest
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
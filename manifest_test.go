@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"     // For generated URLs
+	"testing" // For the test framework
+	"time"    // For download times
+)
+
+// testEntry returns a manifest entry for filename downloaded from source
+func testEntry(filename string, source string) ManifestEntry {
+	return ManifestEntry{SourceURL: source, FinalURL: source, Filename: filename, Size: 100, SHA256: "sum-" + filename, DownloadedAt: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)}
+}
+
+func TestManifestFilenameForURL(t *testing.T) {
+	memory := newMemFS()
+	recorder, err := newManifestRecorder(memory, "missing.json", &localStorage{fs: memory, dir: "out"}, trackingParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	redirected := testEntry("b.pdf", "https://www.airgas.com/msds/b")
+	redirected.FinalURL = "https://cdn.airgas.com/files/b.pdf"
+	recorder.Record(testEntry("a.pdf", "https://www.airgas.com/msds/a.pdf"))
+	recorder.Record(redirected)
+	recorder.AddAlternateURL("a.pdf", "https://www.airgas.com/other/a-copy.pdf")
+
+	tests := []struct {
+		uri  string
+		want string // "" when the URL isn't in the manifest
+	}{
+		{"https://www.airgas.com/msds/a.pdf", "a.pdf"},
+		{"https://WWW.Airgas.com:443/msds/a.pdf?utm_source=mail", "a.pdf"},
+		{"https://www.airgas.com/other/a-copy.pdf", "a.pdf"},
+		{"https://www.airgas.com/msds/b", "b.pdf"},
+		{"https://cdn.airgas.com/files/b.pdf", "b.pdf"},
+		{"https://www.airgas.com/msds/c.pdf", ""},
+		{"https://www.airgas.com/msds/a.pdf?version=2", ""},
+	}
+	for _, test := range tests {
+		got, ok := recorder.FilenameForURL(test.uri)
+		if got != test.want || ok != (test.want != "") {
+			t.Errorf("FilenameForURL(%q) = %q, %v; want %q", test.uri, got, ok, test.want)
+		}
+	}
+}
+
+func TestManifestRecordReplacesURLs(t *testing.T) {
+	memory := newMemFS()
+	recorder, _ := newManifestRecorder(memory, "missing.json", &localStorage{fs: memory, dir: "out"}, nil)
+	recorder.Record(testEntry("a.pdf", "https://www.airgas.com/old/a.pdf"))
+	recorder.Record(testEntry("a.pdf", "https://www.airgas.com/new/a.pdf"))
+	if _, ok := recorder.FilenameForURL("https://www.airgas.com/old/a.pdf"); ok {
+		t.Error("the replaced entry's URL still maps to a.pdf")
+	}
+	if got, ok := recorder.FilenameForURL("https://www.airgas.com/new/a.pdf"); !ok || got != "a.pdf" {
+		t.Errorf("FilenameForURL(new) = %q, %v", got, ok)
+	}
+}
+
+func TestManifestRoundTrip(t *testing.T) {
+	memory := newMemFS()
+	createDirectory(memory, "out", 0o755)
+	writeFile(memory, "out/a.pdf", []byte("%PDF-a"), 0o644) // Only a.pdf is still stored
+	path := "out/" + manifestFileName
+	recorder, _ := newManifestRecorder(memory, path, &localStorage{fs: memory, dir: "out"}, trackingParams)
+	first := testEntry("a.pdf", "https://www.airgas.com/msds/a.pdf")
+	first.ETag, first.LastModified, first.ContentLength = `"v1"`, "Fri, 01 Mar 2024 12:00:00 GMT", 100
+	recorder.Record(first)
+	recorder.Record(testEntry("gone.pdf", "https://www.airgas.com/msds/gone.pdf"))
+	recorder.AddAlternateURL("a.pdf", "https://www.airgas.com/copy/a.pdf")
+	if err := recorder.WriteFile(path); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	reloaded, err := newManifestRecorder(memory, path, &localStorage{fs: memory, dir: "out"}, trackingParams)
+	if err != nil {
+		t.Fatalf("reloading: %v", err)
+	}
+	entry, ok := reloaded.Lookup("a.pdf")
+	first.AlternateURLs = []string{"https://www.airgas.com/copy/a.pdf"}
+	if !ok || fmt.Sprint(entry) != fmt.Sprint(first) {
+		t.Fatalf("reloaded entry = %+v, want %+v", entry, first)
+	}
+	if got, ok := reloaded.FilenameForURL("https://www.airgas.com/copy/a.pdf?utm_medium=x"); !ok || got != "a.pdf" {
+		t.Errorf("alternate URL after reload = %q, %v", got, ok)
+	}
+	if existing, duplicate := reloaded.ClaimContent("sum-a.pdf", "other.pdf"); !duplicate || existing != "a.pdf" {
+		t.Errorf("content of a stored file isn't known after reload: %q, %v", existing, duplicate)
+	}
+	if _, duplicate := reloaded.ClaimContent("sum-gone.pdf", "gone-again.pdf"); duplicate {
+		t.Error("content of a file no longer stored was claimed after reload")
+	}
+}
+
+func TestManifestIgnoresUnreadableManifest(t *testing.T) {
+	memory := newMemFS()
+	writeFile(memory, manifestFileName, []byte("{not json"), 0o644)
+	recorder, err := newManifestRecorder(memory, manifestFileName, &localStorage{fs: memory, dir: "."}, nil)
+	if err != nil {
+		t.Fatalf("newManifestRecorder: %v", err)
+	}
+	if filenames := recorder.SourceFilenames(); len(filenames) != 0 {
+		t.Fatalf("entries from an unreadable manifest: %v", filenames)
+	}
+}
+
+func BenchmarkManifestFilenameForURL(b *testing.B) {
+	recorder := &ManifestRecorder{entries: make(map[string]ManifestEntry), seenHashes: make(map[string]string), byURL: make(map[string]string), ignored: trackingParams}
+	for number := range 5000 {
+		recorder.Record(testEntry(fmt.Sprintf("%d.pdf", number), fmt.Sprintf("https://www.airgas.com/msds/%d.pdf", number)))
+	}
+	for b.Loop() {
+		recorder.FilenameForURL("https://www.airgas.com/msds/4999.pdf?utm_source=x")
+	}
+}
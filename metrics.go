@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"     // For cancelling in-flight work
+	"errors"      // For inspecting error values
+	"fmt"         // For formatted I/O operations
+	"log/slog"    // For structured, levelled logging
+	"net"         // For listening on the metrics address
+	"net/http"    // For serving metrics
+	"sync/atomic" // For counters shared between goroutines
+	"time"        // For time-related operations
+)
+
+// countingTransport counts every request it sends, retries and redirects included, in requests;
+// requests refused by robots.txt never reach the network and aren't counted
+type countingTransport struct {
+	base     http.RoundTripper // Transport that sends the requests
+	requests *atomic.Int64     // Counter of requests sent
+}
+
+// RoundTrip sends the request and counts it
+func (transport *countingTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	response, err := transport.base.RoundTrip(request)
+	if !errors.Is(err, errDisallowedByRobots) {
+		transport.requests.Add(1)
+	}
+	return response, err
+}
+
+// metric is one value exposed to Prometheus, read from the run's summary counters
+type metric struct {
+	name  string                   // Metric name, prefixed with airgas_scraper_
+	kind  string                   // Prometheus type: counter or gauge
+	help  string                   // Description shown by Prometheus
+	value func(stats *Stats) int64 // Current value
+}
+
+// metrics lists everything served on -metrics-addr
+var metrics = []metric{
+	{"requests_total", "counter", "HTTP requests sent, including retries and redirects.", func(stats *Stats) int64 { return stats.Requests.Load() }},
+	{"pages_fetched_total", "counter", "Search pages fetched and saved to the HTML cache.", func(stats *Stats) int64 { return stats.PagesFetched.Load() }},
+	{"links_found_total", "counter", "Unique PDF links found.", func(stats *Stats) int64 { return stats.LinksFound.Load() }},
+	{"downloads_total", "counter", "PDFs downloaded and saved.", func(stats *Stats) int64 { return stats.Downloaded.Load() }},
+	{"bytes_total", "counter", "Bytes of saved PDFs.", func(stats *Stats) int64 { return stats.Bytes.Load() }},
+	{"failures_total", "counter", "PDFs that could not be downloaded or saved.", func(stats *Stats) int64 { return stats.Failed.Load() }},
+	{"downloads_in_flight", "gauge", "PDF downloads currently in progress.", func(stats *Stats) int64 { return stats.InFlight.Load() }},
+}
+
+// writeMetrics writes stats in the Prometheus text exposition format
+func writeMetrics(w http.ResponseWriter, stats *Stats) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	for _, metric := range metrics {
+		name := "airgas_scraper_" + metric.name
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %d\n", name, metric.help, name, metric.kind, name, metric.value(stats))
+	}
+}
+
+// metricsShutdownTimeout is how long a scrape in progress may take to finish when the server stops
+const metricsShutdownTimeout = 5 * time.Second
+
+// startMetricsServer serves stats on addr at /metrics until ctx is cancelled or the returned
+// function is called, which waits for the server to shut down
+func startMetricsServer(ctx context.Context, addr string, stats *Stats) (stop func(), err error) {
+	listener, err := net.Listen("tcp", addr) // Fail the run now if the address is taken
+	if err != nil {
+		return nil, fmt.Errorf("listening on -metrics-addr: %w", err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /metrics", func(w http.ResponseWriter, request *http.Request) { writeMetrics(w, stats) })
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Metrics server failed", "addr", addr, "error", err)
+		}
+	}()
+	slog.Info("Serving metrics", "url", "http://"+listener.Addr().String()+"/metrics")
+
+	done := make(chan struct{})     // Closed by stop
+	finished := make(chan struct{}) // Closed once the server has shut down
+	go func() {
+		defer close(finished)
+		select {
+		case <-ctx.Done():
+		case <-done:
+		}
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), metricsShutdownTimeout) // ctx may already be cancelled
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			slog.Warn("Error shutting down the metrics server", "error", err)
+		}
+	}()
+	return func() {
+		close(done)
+		<-finished
+	}, nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json" // For reading next links of JSON responses
+	"net/url"       // For parsing and manipulating URLs
+	"slices"        // For searching slices
+	"strings"       // For string manipulation
+
+	"golang.org/x/net/html" // For parsing HTML documents
+)
+
+// nextLinkTexts are the visible texts, lowercased, of a pagination link to the following page
+var nextLinkTexts = []string{"next", "next page", "next »", "next ›", "next >", "»", "›", ">"}
+
+// findNextPageURL returns the URL of the page following an HTML search results page: the
+// target of a rel="next" link, or of a link whose class, aria-label or text says "next";
+// disabled links and links that stay on the same page are ignored
+func findNextPageURL(htmlContent string, base *url.URL) (string, bool) {
+	document, err := html.Parse(strings.NewReader(htmlContent)) // Parse the HTML into a DOM
+	if err != nil {
+		return "", false
+	}
+	var candidate string // First link that only looks like a next link
+	for node := range document.Descendants() {
+		if node.Type != html.ElementNode || (node.Data != "a" && node.Data != "link") {
+			continue // Only links can point at the next page
+		}
+		href, ok := resolvePageLink(attributeValue(node, "href"), base)
+		if !ok || isDisabledLink(node) {
+			continue // No usable target
+		}
+		if slices.Contains(strings.Fields(strings.ToLower(attributeValue(node, "rel"))), "next") {
+			return href, true // Explicit rel="next" wins
+		}
+		if candidate == "" && node.Data == "a" && looksLikeNextLink(node) {
+			candidate = href
+		}
+	}
+	return candidate, candidate != ""
+}
+
+// attributeValue returns the value of the node's attribute key, or "" without one
+func attributeValue(node *html.Node, key string) string {
+	for _, attribute := range node.Attr {
+		if attribute.Key == key {
+			return attribute.Val
+		}
+	}
+	return ""
+}
+
+// resolvePageLink resolves a pagination link against base, accepting only http(s) links to
+// another page of the site
+func resolvePageLink(raw string, base *url.URL) (string, bool) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" || strings.HasPrefix(raw, "#") {
+		return "", false // Anchors and script-driven links
+	}
+	reference, err := url.Parse(raw)
+	if err != nil {
+		return "", false
+	}
+	resolved := base.ResolveReference(reference)
+	resolved.Fragment = ""
+	if (resolved.Scheme != "http" && resolved.Scheme != "https") || !onSite(resolved.Host, base.Host) || resolved.String() == base.String() {
+		return "", false // javascript:, off-site or the current page
+	}
+	return resolved.String(), true
+}
+
+// isDisabledLink reports whether a pagination link is marked as disabled, as on the last page
+func isDisabledLink(node *html.Node) bool {
+	if strings.EqualFold(attributeValue(node, "aria-disabled"), "true") {
+		return true
+	}
+	for parent := node; parent != nil && parent.Type == html.ElementNode; parent = parent.Parent {
+		if slices.Contains(strings.Fields(strings.ToLower(attributeValue(parent, "class"))), "disabled") {
+			return true // Bootstrap-style <li class="disabled"><a>
+		}
+	}
+	return false
+}
+
+// looksLikeNextLink reports whether an <a> element reads as a link to the next page
+func looksLikeNextLink(node *html.Node) bool {
+	for _, class := range strings.Fields(strings.ToLower(attributeValue(node, "class"))) {
+		if class == "next" || strings.HasSuffix(class, "-next") || strings.HasSuffix(class, "__next") {
+			return true
+		}
+	}
+	label := strings.ToLower(attributeValue(node, "aria-label"))
+	if label == "next" || strings.HasPrefix(label, "next page") {
+		return true
+	}
+	var text strings.Builder
+	for child := range node.Descendants() {
+		if child.Type == html.TextNode {
+			text.WriteString(child.Data)
+		}
+	}
+	return slices.Contains(nextLinkTexts, strings.ToLower(strings.Join(strings.Fields(text.String()), " ")))
+}
+
+// jsonNextKeys are the top-level keys, and keys of a top-level "links" or "pagination" object,
+// that may hold the URL of a JSON response's next page
+var jsonNextKeys = []string{"next", "nextPage", "next_page", "nextUrl", "next_url"}
+
+// findNextJSONPageURL returns the URL of the page following a JSON search response
+func findNextJSONPageURL(body []byte, base *url.URL) (string, bool) {
+	var document map[string]any
+	if json.Unmarshal(body, &document) != nil {
+		return "", false // Not an object, so no next link
+	}
+	objects := []map[string]any{document}
+	for _, key := range []string{"links", "pagination"} {
+		if nested, ok := document[key].(map[string]any); ok {
+			objects = append(objects, nested)
+		}
+	}
+	for _, object := range objects {
+		for _, key := range jsonNextKeys {
+			if raw, ok := object[key].(string); ok {
+				if next, ok := resolvePageLink(raw, base); ok {
+					return next, true
+				}
+			}
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"           // For crawl contexts
+	"fmt"               // For generated pages
+	"net/http"          // For test handlers
+	"net/http/httptest" // For test servers
+	"net/url"           // For base URLs
+	"slices"            // For comparing crawled pages
+	"strconv"           // For page numbers
+	"sync"              // For guarding crawled pages
+	"testing"           // For the test framework
+)
+
+func TestFindNextPageURL(t *testing.T) {
+	base, _ := url.Parse("https://www.airgas.com/sds-search?searchKeyWord=a&page=1")
+	tests := []struct {
+		name string
+		html string
+		want string // "" when there is no next page
+	}{
+		{"rel next", `<a href="?searchKeyWord=a&amp;page=2" rel="next">2</a>`, "https://www.airgas.com/sds-search?searchKeyWord=a&page=2"},
+		{"head link", `<head><link rel="next" href="/sds-search?page=2"></head>`, "https://www.airgas.com/sds-search?page=2"},
+		{"rel among others", `<a rel="nofollow next" href="/sds-search?page=2">2</a>`, "https://www.airgas.com/sds-search?page=2"},
+		{"rel wins over text", `<a href="/wrong">Next</a><a rel="next" href="/right">2</a>`, "https://www.airgas.com/right"},
+		{"text", `<ul><li><a href="/sds-search?page=0">Prev</a></li><li><a href="/sds-search?page=2"> Next </a></li></ul>`, "https://www.airgas.com/sds-search?page=2"},
+		{"arrow text", `<a href="/sds-search?page=2">»</a>`, "https://www.airgas.com/sds-search?page=2"},
+		{"nested text", `<a href="/sds-search?page=2"><span>Next</span> <span>page</span></a>`, "https://www.airgas.com/sds-search?page=2"},
+		{"class", `<a class="pager__next" href="/sds-search?page=2"><img src="arrow.png"></a>`, "https://www.airgas.com/sds-search?page=2"},
+		{"aria label", `<a aria-label="Next page" href="/sds-search?page=2"></a>`, "https://www.airgas.com/sds-search?page=2"},
+		{"disabled", `<a aria-disabled="true" href="/sds-search?page=2">Next</a>`, ""},
+		{"disabled item", `<li class="page-item disabled"><a href="/sds-search?page=2">Next</a></li>`, ""},
+		{"anchor only", `<a href="#" rel="next">Next</a>`, ""},
+		{"javascript", `<a href="javascript:next()">Next</a>`, ""},
+		{"off site", `<a href="https://example.com/page2" rel="next">Next</a>`, ""},
+		{"same page", `<a href="/sds-search?searchKeyWord=a&amp;page=1#results" rel="next">Next</a>`, ""},
+		{"other subdomain", `<a href="https://shop.airgas.com/sds?page=2" rel="next">Next</a>`, "https://shop.airgas.com/sds?page=2"},
+		{"no pagination", `<a href="/msds/001001.pdf">Nextel SDS</a>`, ""},
+	}
+	for _, test := range tests {
+		got, ok := findNextPageURL(test.html, base)
+		if got != test.want || ok != (test.want != "") {
+			t.Errorf("%s: findNextPageURL = %q, %v; want %q", test.name, got, ok, test.want)
+		}
+	}
+}
+
+func TestFindNextJSONPageURL(t *testing.T) {
+	base, _ := url.Parse("https://www.airgas.com/api/sds?page=1")
+	tests := []struct {
+		json string
+		want string
+	}{
+		{`{"next": "/api/sds?page=2"}`, "https://www.airgas.com/api/sds?page=2"},
+		{`{"links": {"next_url": "https://www.airgas.com/api/sds?page=2"}}`, "https://www.airgas.com/api/sds?page=2"},
+		{`{"pagination": {"nextPage": "?page=2"}}`, "https://www.airgas.com/api/sds?page=2"},
+		{`{"next": null, "results": []}`, ""},
+		{`{"next": "https://example.com/?page=2"}`, ""},
+		{`[{"next": "/api/sds?page=2"}]`, ""},
+		{`not json`, ""},
+	}
+	for _, test := range tests {
+		got, ok := findNextJSONPageURL([]byte(test.json), base)
+		if got != test.want || ok != (test.want != "") {
+			t.Errorf("findNextJSONPageURL(%s) = %q, %v; want %q", test.json, got, ok, test.want)
+		}
+	}
+}
+
+// rewriteTransport sends every request to target instead, keeping its path and query, so
+// crawls of www.airgas.com reach a test server
+type rewriteTransport struct {
+	target *url.URL
+}
+
+// RoundTrip sends a copy of request to target
+func (transport rewriteTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	rewritten := request.Clone(request.Context())
+	rewritten.URL.Scheme, rewritten.URL.Host = transport.target.Scheme, transport.target.Host
+	return http.DefaultTransport.RoundTrip(rewritten)
+}
+
+// crawlServedLetter crawls letter a of the search pages pageHTML renders, by page number, and
+// returns the page numbers fetched and the links emitted
+func crawlServedLetter(t *testing.T, maxPage int, pageHTML func(page int) string) ([]int, []string) {
+	t.Helper()
+	var mutex sync.Mutex
+	var fetched []int
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		page, _ := strconv.Atoi(request.URL.Query().Get("page"))
+		mutex.Lock()
+		fetched = append(fetched, page)
+		mutex.Unlock()
+		writer.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(writer, pageHTML(page))
+	}))
+	t.Cleanup(server.Close)
+	target, _ := url.Parse(server.URL)
+	config := testConfig(t)
+	config.HTMLCacheDir = t.TempDir()
+	config.MaxPage = maxPage
+	scraper := newTestScraper(t, config, &http.Client{Transport: rewriteTransport{target: target}})
+	var links []string
+	scraper.crawlLetter(context.Background(), 'a', func(link PDFLink) { links = append(links, link.URL) })
+	return fetched, links
+}
+
+// resultsPage renders a search results page listing one document, with a next link to next
+// unless it's negative
+func resultsPage(page int, next int) string {
+	html := fmt.Sprintf(`<table><tr><td><a href="/msds/%d.pdf">SDS %d</a></td></tr></table>`, page, page)
+	if next >= 0 {
+		html += fmt.Sprintf(`<a rel="next" href="/sds-search?searchKeyWord=a&amp;page=%d">Next</a>`, next)
+	}
+	return html
+}
+
+func TestCrawlLetterFollowsNextLinks(t *testing.T) {
+	fetched, links := crawlServedLetter(t, 300, func(page int) string {
+		switch page {
+		case 0:
+			return resultsPage(0, 1)
+		case 1:
+			return resultsPage(1, 5) // Page numbers needn't be consecutive
+		case 5:
+			return resultsPage(5, -1) // Last page with results
+		}
+		return "<p>No results found</p>" // Page 6, asked for by number after page 5
+	})
+	if want := []int{0, 1, 5, 6}; !slices.Equal(fetched, want) {
+
+		t.Errorf("fetched pages %v, want %v", fetched, want)
+	}
+	want := []string{"https://www.airgas.com/msds/0.pdf", "https://www.airgas.com/msds/1.pdf", "https://www.airgas.com/msds/5.pdf"}
+	if !slices.Equal(links, want) {
+		t.Errorf("links %q, want %q", links, want)
+	}
+}
+
+func TestCrawlLetterStops(t *testing.T) {
+	tests := []struct {
+		name     string
+		maxPage  int
+		pageHTML func(page int) string
+		want     []int
+	}{
+		{"no results", 300, func(page int) string {
+			if page == 0 {
+				return resultsPage(0, 1)
+			}
+			return `<p>No results found</p><a rel="next" href="/sds-search?page=2">Next</a>`
+		}, []int{0, 1}},
+		{"empty results", 300, func(page int) string {
+			if page == 0 {
+				return resultsPage(0, 1)
+			}
+			return `<table></table><a rel="next" href="/sds-search?page=2">Next</a>`
+		}, []int{0, 1}},
+		{"max page", 2, func(page int) string { return resultsPage(page, page+1) }, []int{0, 1, 2}},
+		{"max page without next links", 2, func(page int) string { return resultsPage(page, -1) }, []int{0, 1, 2}},
+		{"loop", 300, func(page int) string { return resultsPage(page, []int{1, 2, 1}[page]) }, []int{0, 1, 2}}, // Page 2 links back to page 1
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if fetched, _ := crawlServedLetter(t, test.maxPage, test.pageHTML); !slices.Equal(fetched, test.want) {
+				t.Errorf("fetched pages %v, want %v", fetched, test.want)
+			}
+		})
+	}
+}
+
+func TestHasMoreResults(t *testing.T) {
+	listing := `<table><tr><td><a href="/msds/001001.pdf">SDS</a></td></tr></table>`
+	tests := []struct {
+		html string
+		want bool
+	}{
+		{listing, true},
+		{"<p>Showing 120 results</p>" + listing, true},
+		{"<p>10 results</p>" + listing, true},
+		{"<p>20 results per page</p>" + listing, true},
+		{"<p>0 results</p>", false},
+		{"<p>Your search returned 0 results.</p>" + listing, false},
+		{"<p>No Results Found</p>" + listing, false},
+		{"<p>No results were found for a</p>", false},
+		{"<table></table>", false},
+	}
+	for _, test := range tests {
+		if got := hasMoreResults([]byte(test.html), htmlExtractor{}); got != test.want {
+			t.Errorf("hasMoreResults(%s) = %v, want %v", test.html, got, test.want)
+		}
+	}
+}
+
+func TestCrawlLetterPagesPastResultCounts(t *testing.T) {
+	fetched, _ := crawlServedLetter(t, 300, func(page int) string {
+		if page == 2 {
+			return "<p>0 results</p>"
+		}
+		return "<p>120 results, 20 results per page</p>" + resultsPage(page, page+1)
+	})
+	if want := []int{0, 1, 2}; !slices.Equal(fetched, want) {
+		t.Errorf("fetched pages %v, want %v", fetched, want)
+	}
+}
+
+func TestCrawlLetterFallsBackToPageNumbers(t *testing.T) {
+	fetched, links := crawlServedLetter(t, 300, func(page int) string {
+		if page < 3 {
+			return resultsPage(page, -1) // Results, but no next link to follow
+		}
+		return "<p>No results found</p>"
+	})
+	if want := []int{0, 1, 2, 3}; !slices.Equal(fetched, want) {
+		t.Errorf("fetched pages %v, want %v", fetched, want)
+	}
+	want := []string{"https://www.airgas.com/msds/0.pdf", "https://www.airgas.com/msds/1.pdf", "https://www.airgas.com/msds/2.pdf"}
+	if !slices.Equal(links, want) {
+		t.Errorf("links %q, want %q", links, want)
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"         // For reading the start of a partial file
+	"context"       // For cancelling in-flight work
+	"crypto/sha256" // For checksumming resumed files
+	"encoding/hex"  // For encoding checksums as text
+	"encoding/json" // For the partial state sidecar
+	"errors"        // For combining error values
+	"fmt"           // For formatted I/O operations
+	"io"            // For general I/O primitives
+	"net/http"      // For range request headers
+	"os"            // For file and system operations
+	"strconv"       // For parsing Content-Range values
+	"strings"       // For string manipulation
+)
+
+// partialSuffix names a download kept by -resume-partial after it was interrupted
+const partialSuffix = ".part"
+
+// partialStateSuffix names the sidecar recording which version of a document a .part file holds
+const partialStateSuffix = ".part.json"
+
+// partialState identifies the response a .part file's bytes came from, sent back as If-Range so
+// a changed document is downloaded in full instead of being spliced onto old bytes
+type partialState struct {
+	ETag         string `json:"etag,omitempty"`          // ETag of the interrupted response
+	LastModified string `json:"last_modified,omitempty"` // Last-Modified of the interrupted response
+}
+
+// validator returns the If-Range value for the state, preferring a strong ETag; weak ETags
+// can't be used with If-Range
+func (state partialState) validator() string {
+	if state.ETag != "" && !strings.HasPrefix(state.ETag, "W/") {
+		return state.ETag
+	}
+	return state.LastModified
+}
+
+// discardPartial removes the .part file for the document at filePath and its state sidecar
+func discardPartial(fsys FS, filePath string) {
+	discardFile(fsys, filePath+partialSuffix)
+	discardFile(fsys, filePath+partialStateSuffix)
+}
+
+// resumeOffset returns how many bytes of the .part file for the document at filePath can be
+// resumed, adding the Range and If-Range headers asking for the rest to header; a partial that
+// can't be resumed safely is removed and 0 is returned
+func resumeOffset(fsys FS, filePath string, header http.Header) int64 {
+	partPath := filePath + partialSuffix
+	info, err := fsys.Stat(partPath)
+	if err != nil {
+		return 0 // Nothing to resume
+	}
+	content, err := readFile(fsys, filePath+partialStateSuffix) // Version the bytes came from
+	var state partialState
+	if err == nil {
+		err = json.Unmarshal(content, &state)
+	}
+	if err != nil || state.validator() == "" || info.Size() == 0 || !partialLooksLikePDF(fsys, partPath) {
+		discardPartial(fsys, filePath) // Can't prove the rest would match
+		return 0
+	}
+	header.Set("Range", fmt.Sprintf("bytes=%d-", info.Size())) // Ask only for the missing bytes
+	header.Set("If-Range", state.validator())                  // Or the whole document if it changed
+	return info.Size()
+}
+
+// partialLooksLikePDF reports whether the file at path starts with the PDF signature
+func partialLooksLikePDF(fsys FS, path string) bool {
+	file, err := fsys.Open(path)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	head, _ := bufio.NewReader(file).Peek(len(pdfMagic))
+	return looksLikePDF(head)
+}
+
+// contentRangeStart parses a "bytes start-end/total" Content-Range header, returning the first
+// byte and the complete length (-1 if the server didn't say)
+func contentRangeStart(value string) (int64, int64, bool) {
+	spec, found := strings.CutPrefix(value, "bytes ")
+	byteRange, totalText, hasTotal := strings.Cut(spec, "/")
+	startText, _, hasEnd := strings.Cut(byteRange, "-")
+	if !found || !hasTotal || !hasEnd {
+		return 0, 0, false
+	}
+	start, err := strconv.ParseInt(startText, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	total := int64(-1) // "*" means unknown
+	if totalText != "*" {
+		if total, err = strconv.ParseInt(totalText, 10, 64); err != nil {
+			return 0, 0, false
+		}
+	}
+	return start, total, true
+}
+
+// writePartialFile writes reader to the .part file for the document at filePath after its first
+// offset bytes, which are kept, and returns the file's path, complete size and hex SHA-256; the
+// state sidecar is written first when a new partial is started, and an interrupted write leaves
+// the file in place to be resumed by a later run
+func writePartialFile(ctx context.Context, fsys FS, filePath string, offset int64, state partialState, reader io.Reader) (string, int64, string, error) {
+	partPath := filePath + partialSuffix
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC // Start over
+	if offset > 0 {
+		flags = os.O_RDWR | os.O_APPEND // Keep the bytes already downloaded
+	} else {
+		data, err := json.Marshal(state)
+		if err == nil {
+			err = writeFile(fsys, filePath+partialStateSuffix, data, 0o644) // Needed to resume this partial later
+		}
+		if err != nil {
+			return "", 0, "", err
+		}
+	}
+	file, err := fsys.OpenFile(partPath, flags, 0o644)
+	if err != nil {
+		return "", 0, "", err
+	}
+	hasher := sha256.New()
+	existing, err := io.Copy(hasher, io.NewSectionReader(file, 0, offset)) // Hash the kept bytes
+	if err == nil && existing != offset {
+		err = fmt.Errorf("partial file shrank to %d bytes, expected %d", existing, offset)
+	}
+	var written int64
+	if err == nil {
+		written, err = io.Copy(io.MultiWriter(file, hasher), reader) // Append the rest
+	}
+	if err == nil {
+		err = file.Sync() // Make sure the bytes are on disk before the rename publishes them
+	}
+	err = errors.Join(err, file.Close(), ctx.Err())
+	if err != nil {
+		return "", 0, "", err // Kept for the next run to resume
+	}
+	return partPath, offset + written, hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// partialWriter is the bodyWriter of -resume-partial, which continues and keeps .part files
+type partialWriter struct{}
+
+// prepare asks for the rest of the .part file for the document at filePath, if any; a
+// conditional request is about the stored copy, so it isn't resumed
+func (partialWriter) prepare(fsys FS, filePath string, header http.Header) int64 {
+	offset := int64(0) // Bytes kept from an interrupted download
+	if len(header) == 0 {
+		offset = resumeOffset(fsys, filePath, header)
+	}
+	header.Set("Accept-Encoding", "identity") // Byte ranges must count the bytes that are stored
+	return offset
+}
+
+// write appends body to the .part file for the document at filePath; an incomplete file is kept
+// for the next attempt, and one of the wrong size is discarded
+func (partialWriter) write(ctx context.Context, fsys FS, filePath string, filename string, offset int64, state partialState, body io.Reader, expectedSize int64) (string, int64, string, error) {
+	partPath, written, checksumHex, err := writePartialFile(ctx, fsys, filePath, offset, state, body) // Append to a resumable .part file
+	if err != nil {
+		return "", 0, "", err
+	}
+	if expectedSize >= 0 && written != expectedSize {
+		discardPartial(fsys, filePath) // Corrupt; the next run starts over
+		return "", 0, "", fmt.Errorf("%w: downloaded %d bytes, expected %d", errSizeMismatch, written, expectedSize)
+	}
+	discardFile(fsys, filePath+partialStateSuffix) // Complete; nothing left to resume
+	return partPath, written, checksumHex, nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"     // For cancelling in-flight work
+	"log/slog"    // For structured, levelled logging
+	"sync"        // For handling concurrency
+	"sync/atomic" // For the paused time counter
+	"time"        // For time-related operations
+)
+
+// pauseFilePollInterval is how often -pause-file is checked for
+const pauseFilePollInterval = time.Second
+
+// pauseGate holds back new page fetches and downloads while the run is paused, letting those
+// already running finish; the time spent paused is added to paused; it is safe for concurrent
+// use, and a nil gate is never paused
+type pauseGate struct {
+	mutex   sync.Mutex
+	cond    *sync.Cond      // Broadcast on resume
+	reasons map[string]bool // Why the run is paused, such as "signal" or "file"; paused while not empty
+	since   time.Time       // When the current pause started
+	paused  *atomic.Int64   // Nanoseconds spent paused, for the summary
+}
+
+// newPauseGate returns an open gate adding its paused time to paused
+func newPauseGate(paused *atomic.Int64) *pauseGate {
+	gate := &pauseGate{reasons: make(map[string]bool), paused: paused}
+	gate.cond = sync.NewCond(&gate.mutex)
+	return gate
+}
+
+// pause closes the gate for reason until resume is called with the same reason
+func (gate *pauseGate) pause(reason string) {
+	gate.mutex.Lock()
+	defer gate.mutex.Unlock()
+	if gate.reasons[reason] {
+		return // Already paused for this
+	}
+	if len(gate.reasons) == 0 {
+		gate.since = time.Now()
+		slog.Info("Pausing: running requests finish, no new ones start", "by", reason)
+	}
+	gate.reasons[reason] = true
+}
+
+// resume lifts the pause for reason, opening the gate once no other reason holds it
+func (gate *pauseGate) resume(reason string) {
+	gate.mutex.Lock()
+	defer gate.mutex.Unlock()
+	if !gate.reasons[reason] {
+		return // Not paused for this
+	}
+	delete(gate.reasons, reason)
+	if len(gate.reasons) == 0 {
+		pausedFor := time.Since(gate.since)
+		gate.paused.Add(int64(pausedFor))
+		slog.Info("Resuming", "by", reason, "paused_for", pausedFor.Round(time.Millisecond))
+		gate.cond.Broadcast()
+	}
+}
+
+// wait blocks while the gate is paused; it returns ctx's error if ctx is done first
+func (gate *pauseGate) wait(ctx context.Context) error {
+	if gate == nil {
+		return nil // Pausing isn't set up
+	}
+	stop := context.AfterFunc(ctx, func() {
+		gate.mutex.Lock()
+		defer gate.mutex.Unlock()
+		gate.cond.Broadcast() // Wake the waiters so they see the cancellation
+	})
+	defer stop()
+	gate.mutex.Lock()
+	defer gate.mutex.Unlock()
+	for len(gate.reasons) > 0 && ctx.Err() == nil {
+		gate.cond.Wait()
+	}
+	return ctx.Err()
+}
+
+// close ends a pause still in progress, so the summary counts it
+func (gate *pauseGate) close() {
+	gate.mutex.Lock()
+	defer gate.mutex.Unlock()
+	if len(gate.reasons) > 0 {
+		gate.paused.Add(int64(time.Since(gate.since)))
+		clear(gate.reasons)
+		gate.cond.Broadcast()
+	}
+}
+
+// watchPauseFile pauses the gate while a file exists at path on fsys, checking until ctx is done
+func (gate *pauseGate) watchPauseFile(ctx context.Context, fsys FS, path string) {
+	ticker := time.NewTicker(pauseFilePollInterval)
+	defer ticker.Stop()
+	for {
+		if fileExists(fsys, path) {
+			gate.pause("file")
+		} else {
+			gate.resume("file")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
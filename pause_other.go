@@ -0,0 +1,9 @@
+//go:build !unix
+
+package main
+
+import "context" // For cancelling in-flight work
+
+// watchPauseSignals does nothing: there is no SIGUSR1 or SIGUSR2 on this platform, so only
+// -pause-file pauses the run
+func (gate *pauseGate) watchPauseSignals(ctx context.Context) {}
@@ -0,0 +1,29 @@
+//go:build unix
+
+package main
+
+import (
+	"context"   // For cancelling in-flight work
+	"os"        // For signal values
+	"os/signal" // For catching SIGUSR1 and SIGUSR2
+	"syscall"   // For the signal numbers
+)
+
+// watchPauseSignals pauses the gate on SIGUSR1 and resumes it on SIGUSR2 until ctx is done
+func (gate *pauseGate) watchPauseSignals(ctx context.Context) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1, syscall.SIGUSR2)
+	defer signal.Stop(signals)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case received := <-signals:
+			if received == syscall.SIGUSR1 {
+				gate.pause("signal")
+			} else {
+				gate.resume("signal")
+			}
+		}
+	}
+}
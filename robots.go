@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"  // For cancelling in-flight work
+	"errors"   // For combining error values
+	"fmt"      // For formatted I/O operations
+	"io"       // For general I/O primitives
+	"net/http" // For making HTTP requests
+	"net/url"  // For parsing and manipulating URLs
+	"regexp"   // For matching robots.txt patterns
+	"strconv"  // For parsing numeric header values
+	"strings"  // For string manipulation
+	"time"     // For time-related operations
+)
+
+// errDisallowedByRobots is returned for requests that robots.txt forbids
+var errDisallowedByRobots = errors.New("disallowed by robots.txt")
+
+// robotsRule is one Allow or Disallow line of robots.txt
+type robotsRule struct {
+	pattern string         // Path pattern as written in robots.txt
+	matcher *regexp.Regexp // Pattern compiled with * and $ support
+	allow   bool           // Whether the rule allows or disallows
+}
+
+// robotsRules are the robots.txt rules that apply to this scraper
+type robotsRules struct {
+	rules      []robotsRule  // Allow and Disallow rules of the matching group
+	crawlDelay time.Duration // Crawl-delay of the matching group, if any
+}
+
+// compileRobotsPattern turns a robots.txt path pattern into a regexp anchored at the start
+func compileRobotsPattern(pattern string) *regexp.Regexp {
+	anchored := strings.HasSuffix(pattern, "$")                  // "$" pins the end of the path
+	quoted := regexp.QuoteMeta(strings.TrimSuffix(pattern, "$")) // Escape everything else
+	expression := "^" + strings.ReplaceAll(quoted, `\*`, ".*")   // "*" matches any sequence
+	if anchored {
+		expression += "$"
+	}
+	return regexp.MustCompile(expression) // QuoteMeta output always compiles
+}
+
+// parseRobots extracts the rules for userAgent from a robots.txt body, falling back to the
+// "*" group when no group names the scraper's product token
+func parseRobots(content string, userAgent string) robotsRules {
+	token := strings.ToLower(strings.SplitN(userAgent, "/", 2)[0]) // "airgas-sds-scraper/1.0" → "airgas-sds-scraper"
+	groups := make(map[string]*robotsRules)                        // Rules per lowercased user-agent
+	var current []*robotsRules                                     // Groups the following rules belong to
+	inAgents := false                                              // Whether the previous line was a User-agent line
+	for _, line := range strings.Split(content, "\n") {
+		line, _, _ = strings.Cut(line, "#") // Drop comments
+		key, value, found := strings.Cut(line, ":")
+		if !found {
+			continue // Not a key: value line
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+		if key == "user-agent" {
+			if !inAgents {
+				current = nil // A new group starts
+			}
+			agent := strings.ToLower(value)
+			if groups[agent] == nil {
+				groups[agent] = &robotsRules{}
+			}
+			current = append(current, groups[agent])
+			inAgents = true
+			continue
+		}
+		inAgents = false
+		for _, group := range current {
+			switch key {
+			case "allow", "disallow":
+				if value != "" { // An empty Disallow allows everything
+					group.rules = append(group.rules, robotsRule{pattern: value, matcher: compileRobotsPattern(value), allow: key == "allow"})
+				}
+			case "crawl-delay":
+				if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds > 0 {
+					group.crawlDelay = time.Duration(seconds * float64(time.Second))
+				}
+			}
+		}
+	}
+	if group, ok := groups[token]; ok {
+		return *group // Rules written for this scraper
+	}
+	if group, ok := groups["*"]; ok {
+		return *group // Rules for every crawler
+	}
+	return robotsRules{} // No matching group: everything is allowed
+}
+
+// Allowed reports whether the path (including any query) may be fetched; the longest matching
+// rule wins and Allow wins ties
+func (robots robotsRules) Allowed(requestPath string) bool {
+	allowed, longest := true, -1 // No match means allowed
+	for _, rule := range robots.rules {
+		if !rule.matcher.MatchString(requestPath) {
+			continue
+		}
+		if length := len(rule.pattern); length > longest || (length == longest && rule.allow) {
+			allowed, longest = rule.allow, length // More specific rule
+		}
+	}
+	return allowed
+}
+
+// robotsTransport refuses requests to host that its robots.txt rules disallow
+type robotsTransport struct {
+	base   http.RoundTripper // Transport that sends permitted requests
+	host   string            // Host the rules apply to
+	robots robotsRules       // Rules from the host's robots.txt
+}
+
+// RoundTrip sends the request unless robots.txt forbids it
+func (transport *robotsTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	if strings.EqualFold(request.URL.Host, transport.host) && !transport.robots.Allowed(request.URL.RequestURI()) {
+		return nil, errDisallowedByRobots // Never contact disallowed URLs
+	}
+	return transport.base.RoundTrip(request)
+}
+
+// loadRobots fetches and parses robots.txt for the search host; a missing file allows everything
+func loadRobots(ctx context.Context, client *http.Client, config Config) (robotsRules, error) {
+	robotsURL := searchBaseURL.ResolveReference(&url.URL{Path: "/robots.txt"}).String()
+	response, err := httpGetWithRetry(ctx, client, nil, robotsURL, nil, config.RequestTimeout, config) // Fetch robots.txt
+	if err != nil {
+		return robotsRules{}, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode >= 400 && response.StatusCode < 500 {
+		return robotsRules{}, nil // No robots.txt: nothing is disallowed
+	}
+	if response.StatusCode != http.StatusOK {
+		return robotsRules{}, fmt.Errorf("unexpected status %d for %s", response.StatusCode, robotsURL)
+	}
+	body, err := io.ReadAll(response.Body) // Read the rules
+	if err != nil {
+		return robotsRules{}, err
+	}
+	return parseRobots(string(body), config.UserAgent), nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"            // For inspecting error values
+	"net/http"          // For test handlers
+	"net/http/httptest" // For test servers
+	"net/url"           // For the server's host
+	"testing"           // For the test framework
+	"time"              // For crawl delays
+)
+
+// robotsFixture is a robots.txt with a group for every crawler and one for the scraper
+const robotsFixture = `# airgas.com
+User-agent: *
+Disallow: /cart
+Disallow: /msds/
+Allow: /msds/*.pdf$
+Crawl-delay: 5
+
+User-agent: airgas-sds-scraper
+User-agent: other-bot
+Disallow: /sds-search?*page=
+Allow: /sds-search?*page=0
+Disallow: /private   # staff only
+Crawl-delay: 0.5
+`
+
+func TestRobotsAllowed(t *testing.T) {
+	tests := []struct {
+		userAgent string
+		path      string
+		want      bool
+	}{
+		{"Mozilla/5.0", "/", true},
+		{"Mozilla/5.0", "/cart", false},
+		{"Mozilla/5.0", "/cart/items", false},
+		{"Mozilla/5.0", "/msds/001001.pdf", true},      // The longer Allow wins
+		{"Mozilla/5.0", "/msds/001001.pdf?v=2", false}, // $ pins the end
+		{"Mozilla/5.0", "/msds/list", false},
+		{"Mozilla/5.0", "/private", true},         // Only the scraper's group disallows it
+		{"airgas-sds-scraper/1.0", "/cart", true}, // Its own group replaces the * one
+		{"airgas-sds-scraper/1.0", "/sds-search?searchKeyWord=a&page=3", false},
+		{"airgas-sds-scraper/1.0", "/sds-search?searchKeyWord=a&page=0", true},
+		{"airgas-sds-scraper/1.0", "/sds-search?searchKeyWord=a", true},
+		{"airgas-sds-scraper/1.0", "/private/x", false},
+		{"AIRGAS-SDS-SCRAPER", "/private", false}, // Tokens match case-insensitively
+		{"other-bot/2", "/private", false},        // Shares the group
+	}
+	for _, test := range tests {
+		if got := parseRobots(robotsFixture, test.userAgent).Allowed(test.path); got != test.want {
+			t.Errorf("%s: Allowed(%q) = %v, want %v", test.userAgent, test.path, got, test.want)
+		}
+	}
+}
+
+func TestRobotsCrawlDelay(t *testing.T) {
+	if delay := parseRobots(robotsFixture, "Mozilla/5.0").crawlDelay; delay != 5*time.Second {
+		t.Errorf("* crawl delay = %s, want 5s", delay)
+	}
+	if delay := parseRobots(robotsFixture, "airgas-sds-scraper/1.0").crawlDelay; delay != 500*time.Millisecond {
+		t.Errorf("scraper crawl delay = %s, want 500ms", delay)
+	}
+	if rules := parseRobots("User-agent: other\nDisallow: /\n", "airgas-sds-scraper/1.0"); !rules.Allowed("/anything") {
+		t.Error("no matching group disallowed a path")
+	}
+	if rules := parseRobots("User-agent: *\nDisallow:\n", "x"); !rules.Allowed("/anything") {
+		t.Error("an empty Disallow disallowed a path")
+	}
+}
+
+func TestRobotsTransport(t *testing.T) {
+	var fetched []string
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		fetched = append(fetched, request.URL.Path)
+	}))
+	defer server.Close()
+	host, _ := url.Parse(server.URL)
+	client := &http.Client{Transport: &robotsTransport{base: http.DefaultTransport, host: host.Host, robots: parseRobots(robotsFixture, "x")}}
+
+	if _, err := client.Get(server.URL + "/cart"); !errors.Is(err, errDisallowedByRobots) {
+		t.Errorf("disallowed GET err = %v, want errDisallowedByRobots", err)
+	}
+	response, err := client.Get(server.URL + "/msds/001001.pdf")
+	if err != nil {
+		t.Fatalf("allowed GET: %v", err)
+	}
+	response.Body.Close()
+	if len(fetched) != 1 || fetched[0] != "/msds/001001.pdf" {
+		t.Errorf("server saw %q, want only the allowed path", fetched)
+	}
+}
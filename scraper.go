@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"  // For cancelling in-flight work
+	"errors"   // For creating error values
+	"fmt"      // For formatted I/O operations
+	"log/slog" // For structured, levelled logging
+	"net/http" // For making HTTP requests
+	"os"       // For file and system operations
+	"slices"   // For sorting links
+	"strings"  // For comparing URLs
+	"sync"     // For handling concurrency
+)
+
+// Scraper holds the settings and shared state used by every request of one run
+type Scraper struct {
+	config      Config                 // Settings for the run
+	client      *http.Client           // One client, and connection pool, for every request
+	limiter     *rateLimiter           // Shared limiter for every request to airgas.com
+	jitter      *jitter                // Random crawl order and delays with -shuffle, or nil
+	stats       *Stats                 // Counters for the end-of-run summary
+	recorder    *ManifestRecorder      // Records downloaded PDFs; set before the download phase
+	storage     Storage                // Where PDFs are saved; set before the download phase
+	names       *nameAssigner          // Hands out output filenames; set before the download phase
+	hookSlots   chan struct{}          // Semaphore bounding -on-download processes
+	inFlight    sync.Map               // Canonical URLs currently being downloaded
+	abort       func(error)            // Cancels the whole run with a fatal cause
+	events      *eventStream           // -events-json stream, or nil
+	concurrency *concurrencyController // Paces the downloads with -concurrency-auto, or nil
+	pause       *pauseGate             // Holds back new requests while paused, or nil
+}
+
+// newScraper returns a Scraper that sends its requests through client at config's rate, in a
+// random order with -shuffle, and calls abort with the cause when a failure should end the run
+func newScraper(config Config, client *http.Client, abort func(error)) *Scraper {
+	var random *jitter // Crawl in order without -shuffle
+	if config.Shuffle {
+		random = newJitter(config.Seed, config.JitterMin, config.JitterMax, config.clock())
+	}
+	limiter := newRateLimiter(config.RequestsPerSec, config.clock())
+	if limiter != nil {
+		limiter.adaptive = config.AdaptiveRPS // Back off across the run on 429/503
+	}
+	var concurrency *concurrencyController // Fixed number of downloads without -concurrency-auto
+	if config.ConcurrencyAuto {
+		concurrency = newConcurrencyController(config.ConcurrencyMin, config.ConcurrencyMax)
+	}
+	return &Scraper{
+		config:      config,
+		client:      client,
+		limiter:     limiter,
+		jitter:      random,
+		hookSlots:   make(chan struct{}, max(config.HookConcurrency, 1)),
+		stats:       newStats(),
+		abort:       abort,
+		concurrency: concurrency,
+	}
+}
+
+// errDiskFull is the cause a run is aborted with once writes fail for lack of space
+var errDiskFull = errors.New("disk full")
+
+// checkWriteError aborts the run when err says the disk is full, since every later write would
+// fail the same way, and returns err
+func (scraper *Scraper) checkWriteError(err error) error {
+	if isDiskFull(err) {
+		scraper.abort(fmt.Errorf("%w: %w", errDiskFull, err)) // Only the first cause is kept
+	}
+	return err
+}
+
+// downloadJob is a unique document queued for download
+type downloadJob struct {
+	url      string // URL to fetch
+	filename string // Output path relative to the output directory
+}
+
+// runPipeline streams the links produced by source through one deduplicating stage, which
+// skips repeated documents, those listed as older than -since and, with -only-new, those
+// already mirrored, and assigns filenames, to -download-workers workers calling downloader, so
+// downloads start while the crawl is still running; both queues hold -queue-size links, so a
+// crawl ahead of the downloads waits instead of buffering every link; it stops early once a
+// -max-files/-max-bytes limit is reached or ctx is cancelled, and returns source's error
+func (scraper *Scraper) runPipeline(ctx context.Context, source func(context.Context, func(PDFLink)) error, downloader Downloader) error {
+	pipelineCtx, stopPipeline := context.WithCancel(ctx) // Cancelled early once a download limit is reached
+	defer stopPipeline()
+	if !scraper.config.Quiet && !scraper.config.DryRun && isTerminal(os.Stdout) {
+		stopProgress := scraper.stats.startProgress(os.Stdout, progressInterval, scraper.stats.LinksFound.Load) // Feedback for long runs
+		defer stopProgress()
+	}
+
+	links := make(chan PDFLink, scraper.config.queueSize()) // Links as the crawl finds them; emit blocks when full
+	sourceDone := make(chan error, 1)                       // source's result, once it has returned
+	go func() {
+		defer close(links) // Ends the dedup stage once every link is in
+		sourceDone <- source(pipelineCtx, func(link PDFLink) {
+			select {
+			case links <- link:
+			case <-pipelineCtx.Done(): // Nobody downloads it any more
+			}
+		})
+	}()
+
+	jobs := make(chan downloadJob, scraper.config.queueSize()) // Unique documents with their filenames
+	go func() {
+		defer close(jobs)               // Ends the workers once every job is queued
+		seen := make(map[string]string) // URLs queued, by canonical form; only this goroutine uses it
+		for link := range links {
+			canonical := canonicalizeURL(link.URL, scraper.config.IgnoreParams) // Same document however the URL is spelled
+			if first, ok := seen[canonical]; ok {
+				if first != link.URL {
+					slog.Debug("Skipping variant of a document already queued", "url", link.URL, "queued", first)
+				}
+				continue
+			}
+			seen[canonical] = link.URL      // The first spelling found stands for the document
+			scraper.stats.LinksFound.Add(1) // Count unique links
+			scraper.events.emit(Event{Event: eventLinkFound, URL: link.URL})
+			if link.listedBefore(scraper.config.Since) {
+				slog.Debug("Skipping document updated before -since", "url", link.URL, "modified", link.Modified)
+				scraper.stats.SkippedOld.Add(1) // Too old to refresh
+				continue
+			}
+			filename := scraper.names.assign(link.URL) // Keep the first spelling for fetching
+			if filename == "" {
+				slog.Warn("Skipping URL that gives no usable filename", "url", link.URL)
+				scraper.stats.SkippedInvalid.Add(1) // Would be saved as a bare extension, or not at all
+				continue
+			}
+			if scraper.config.OnlyNew || scraper.config.NewList != "" {
+				if scraper.isMirrored(link.URL, filename) {
+					if scraper.config.OnlyNew {
+						slog.Debug("Skipping document already in the mirror", "url", link.URL, "path", filename)
+						scraper.stats.SkippedExisting.Add(1) // Not even checked for changes
+						continue
+					}
+				} else {
+					scraper.recordNew(link.URL)
+				}
+			}
+			select {
+			case jobs <- downloadJob{url: link.URL, filename: filename}:
+			case <-pipelineCtx.Done(): // Drain the links without queueing them
+			}
+		}
+	}()
+
+	var workers sync.WaitGroup // Download workers still running
+	for range scraper.config.downloadWorkers() {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for job := range jobs {
+				if scraper.pause.wait(pipelineCtx) != nil {
+					continue // Don't start downloads once the run is stopping
+				}
+				if scraper.concurrency != nil && !scraper.concurrency.acquire(pipelineCtx) {
+					continue // Stopped while waiting for a slot
+				}
+				downloader.Download(pipelineCtx, job.url, job.filename) // Try to download the PDF; the downloader counts the outcome
+				if scraper.concurrency != nil {
+					scraper.concurrency.release()
+				}
+				if limitReached(scraper.config, scraper.stats) && !scraper.stats.LimitReached.Swap(true) {
+					slog.Info("Download limit reached, stopping", "max_files", scraper.config.MaxFiles, "max_bytes", scraper.config.MaxBytes)
+					stopPipeline() // Stop the crawl and new downloads, and abort in-flight ones
+				}
+			}
+		}()
+	}
+	workers.Wait()
+	return <-sourceDone
+}
+
+// sortedSource returns a source that collects every link source finds, then emits them sorted
+// by URL, so downloads start only after the crawl finished
+func sortedSource(source func(context.Context, func(PDFLink)) error) func(context.Context, func(PDFLink)) error {
+	return func(ctx context.Context, emit func(PDFLink)) error {
+		var links []PDFLink
+		var mutex sync.Mutex // Crawl workers may emit concurrently
+		err := source(ctx, func(link PDFLink) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			links = append(links, link)
+		})
+		slices.SortStableFunc(links, func(a, b PDFLink) int { return strings.Compare(a.URL, b.URL) })
+		for _, link := range links {
+			if ctx.Err() != nil {
+				break // Nobody downloads them any more
+			}
+			emit(link)
+		}
+		return err
+	}
+}
+
+// isMirrored reports whether the document at rawURL is already stored, under filename or under
+// the name the manifest recorded for the URL
+func (scraper *Scraper) isMirrored(rawURL string, filename string) bool {
+	if scraper.storage.Exists(filename) {
+		return true
+	}
+	recorded, ok := scraper.recorder.FilenameForURL(rawURL) // Stored from another spelling or as a duplicate
+	return ok && scraper.storage.Exists(recorded)
+}
+
+// recordNew counts a document that isn't in the mirror yet and appends its URL to the -new-list
+// file, if one is set
+func (scraper *Scraper) recordNew(uri string) {
+	scraper.stats.NewDocuments.Add(1)
+	if scraper.config.NewList == "" {
+		return
+	}
+	if err := scraper.checkWriteError(appendByteToFile(scraper.config.fileSystem(), scraper.config.NewList, []byte(uri+"\n"))); err != nil {
+		slog.Warn("Failed to record new document", "url", uri, "path", scraper.config.NewList, "error", err)
+	}
+}
+
+// workerPool runs every task while keeping at most maxConcurrency of them running at once,
+// stopping early without starting new tasks once ctx is cancelled
+func workerPool(ctx context.Context, tasks []func(), maxConcurrency int) {
+	if maxConcurrency < 1 {
+		maxConcurrency = 1 // Always allow at least one worker
+	}
+	semaphore := make(chan struct{}, maxConcurrency) // Buffered channel used as a counting semaphore
+	var waitGroup sync.WaitGroup                     // WaitGroup to wait for every task to finish
+	for _, task := range tasks {
+		select {
+		case <-ctx.Done():
+			waitGroup.Wait() // Let in-flight tasks drain
+			return           // Don't start any more tasks
+		case semaphore <- struct{}{}: // Acquire a slot (blocks while the pool is full)
+		}
+		waitGroup.Add(1) // Register the task before it starts
+		go func() {
+			defer waitGroup.Done()         // Mark task as done when it finishes
+			defer func() { <-semaphore }() // Release the slot for the next task
+			task()                         // Run the task
+		}()
+	}
+	waitGroup.Wait() // Wait for all tasks to complete
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"bytes"         // Provides buffer for reading/writing data
+	"context"       // For cancelling in-flight work
+	"encoding/json" // For JSON search request bodies
+	"errors"        // For inspecting error values
+	"fmt"           // For formatted I/O operations
+	"io"            // For general I/O primitives
+	"log/slog"      // For structured, levelled logging
+	"net/http"      // For making HTTP requests
+	"net/url"       // For parsing and manipulating URLs
+	"os"            // For file and system operations
+	"path/filepath" // For manipulating filename paths
+	"regexp"        // For the no-results phrases
+	"strconv"       // For page numbers in next links
+	"strings"       // For string manipulation
+	"text/template" // For naming files from templates
+	"time"          // For time-related operations
+)
+
+// SearchOptions selects the product categories of the airgas.com SDS search and how the
+// search is asked for them
+type SearchOptions struct {
+	PureGases  bool   // Include pure gases
+	MixedGases bool   // Include mixed gases
+	HardGoods  bool   // Include hardgoods
+	Method     string // HTTP method of the search requests: GET, or POST with the parameters as the body
+	BodyFormat string // How POST search requests encode the parameters: form or json
+}
+
+// searchMethods and searchBodyFormats are the accepted -search-method and -search-body values
+var (
+	searchMethods     = []string{http.MethodGet, http.MethodPost}
+	searchBodyFormats = []string{"form", "json"}
+)
+
+// searchRequest is the request for one page of search results
+type searchRequest struct {
+	method      string // GET or POST
+	url         string // Where the request is sent
+	body        []byte // POST payload, or nil
+	contentType string // Content-Type of body
+	key         string // GET URL of the same page, naming it in the HTML cache and the logs
+}
+
+// getRequest returns the GET request for the page at uri
+func getRequest(uri string) searchRequest {
+	return searchRequest{method: http.MethodGet, url: uri, key: uri}
+}
+
+// buildSearchURL returns the SDS search results URL for a keyword letter and page
+func buildSearchURL(letter rune, page int, opts SearchOptions) string {
+	return fmt.Sprintf("https://www.airgas.com/sds-search?searchKeyWord=%s&sortOrder=&searchPureGases=%t&searchMixedGases=%t&searchHardGoods=%t&maintainType=true&page=%d",
+		url.QueryEscape(string(letter)), opts.PureGases, opts.MixedGases, opts.HardGoods, page)
+}
+
+// buildSearchRequest returns the request for a keyword letter and page of the SDS search: a GET
+// of buildSearchURL, or with opts.Method POST the same parameters as a form or JSON body sent
+// to the search URL without its query
+func buildSearchRequest(letter rune, page int, opts SearchOptions) searchRequest {
+	request := getRequest(buildSearchURL(letter, page, opts))
+	if opts.Method != http.MethodPost {
+		return request
+	}
+	request.method = http.MethodPost
+	request.url = searchBaseURL.String()
+	if opts.BodyFormat == "json" {
+		request.contentType = "application/json"
+		request.body, _ = json.Marshal(map[string]any{ // Plain values, so this can't fail
+			"searchKeyWord":    string(letter),
+			"sortOrder":        "",
+			"searchPureGases":  opts.PureGases,
+			"searchMixedGases": opts.MixedGases,
+			"searchHardGoods":  opts.HardGoods,
+			"maintainType":     true,
+			"page":             page,
+		})
+		return request
+	}
+	parsed, _ := url.Parse(request.key) // Built above, so valid
+	request.contentType = "application/x-www-form-urlencoded"
+	request.body = []byte(parsed.RawQuery) // Already form-encoded
+	return request
+}
+
+// getDataFromURL sends a search or sitemap page request, saves the response body as the page's
+// file in the HTML cache directory and returns it with the extractor its Content-Type calls for
+// (nil if the page could not be fetched or saved)
+func (scraper *Scraper) getDataFromURL(ctx context.Context, request searchRequest) ([]byte, Extractor) {
+	if err := scraper.pause.wait(ctx); err != nil {
+		return nil, nil // Cancelled while paused
+	}
+	if err := scraper.jitter.Wait(ctx); err != nil {
+		return nil, nil // Cancelled before the request was sent
+	}
+	start := time.Now() // Track how long the page takes
+	uri := request.key  // Names the page, whichever method fetches it
+
+	var header http.Header // Content-Type of a POST body
+	if request.body != nil {
+		header = http.Header{"Content-Type": {request.contentType}}
+	}
+	response, err := httpDoWithRetry(ctx, scraper.client, scraper.limiter, request.method, request.url, header, request.body, scraper.config.RequestTimeout, scraper.config) // Send the request
+	if err != nil {
+		slog.Error("HTTP "+request.method+" failed", "url", uri, "error", err) // Log error
+		scraper.stats.PagesFailed.Add(1)
+		return nil, nil
+	}
+	defer func() {
+		if err := response.Body.Close(); err != nil {
+			slog.Warn("Error closing response body", "url", uri, "error", err) // Log error on closing
+		}
+	}()
+
+	finalURL := response.Request.URL.String() // Get final URL after redirects
+	if request.method != http.MethodGet && finalURL == request.url {
+		finalURL = uri // A POST to the search URL stands for the page it asked for
+	}
+	slog.Debug("Final URL after redirects", "url", uri, "final_url", finalURL)
+
+	if response.StatusCode != http.StatusOK { // Check if status is not 200 OK
+		slog.Warn("Non-OK HTTP status", "url", finalURL, "status", response.StatusCode)
+		scraper.stats.PagesFailed.Add(1)
+		return nil, nil
+	}
+
+	body, err := io.ReadAll(response.Body) // Read the response body
+	if err != nil {
+		slog.Error("Failed to read body", "url", finalURL, "error", err)
+		scraper.stats.PagesFailed.Add(1)
+		return nil, nil
+	}
+
+	extractor, extension := extractorFor(response.Header.Get("Content-Type"), scraper.config.DocumentPatterns)                                       // HTML page or JSON API response
+	cachePath := scraper.pageCachePath(uri, extension)                                                                                               // The page's own cache file
+	tempPath, _, _, err := writeTempFile(ctx, scraper.config.fileSystem(), filepath.Dir(cachePath), filepath.Base(cachePath), bytes.NewReader(body)) // Never leave a partial page behind
+	if err == nil {
+		err = scraper.config.fileSystem().Rename(tempPath, cachePath) // Publish the page; its presence marks it as done
+	}
+	if scraper.checkWriteError(err) != nil {
+		slog.Error("Failed to write body to file", "url", finalURL, "path", cachePath, "error", err)
+		scraper.stats.PagesFailed.Add(1)
+		return nil, nil
+	}
+
+	scraper.stats.PagesFetched.Add(1) // Count the saved page
+	scraper.events.emit(Event{Event: eventPageFetched, URL: finalURL, Status: response.StatusCode, Bytes: int64(len(body)), DurationMS: time.Since(start).Milliseconds()})
+
+	slog.Info("Completed scraping URL", "url", finalURL, "status", response.StatusCode, "bytes", len(body), "duration", time.Since(start)) // Log successful scrape
+	return body, extractor                                                                                                                 // Hand the page back for pagination checks
+}
+
+// noResultsPattern matches the phrases the search page shows once a query has run out of
+// results, as whole words so counts such as "120 results" don't match
+var noResultsPattern = regexp.MustCompile(`(?i)\b(?:no results (?:were )?found|0 results)\b`)
+
+// hasMoreResults reports whether a search results page still lists documents
+func hasMoreResults(body []byte, extractor Extractor) bool {
+	if noResultsPattern.Match(body) {
+		return false // Page explicitly says there is nothing left
+	}
+	return len(extractor.Extract(body, searchBaseURL)) > 0 // An empty result container has no SDS links
+}
+
+// searchBaseURL is the page relative links in the search results resolve against
+var searchBaseURL = &url.URL{Scheme: "https", Host: "www.airgas.com", Path: "/sds-search"}
+
+// pageNameTemplate names cached search pages, whatever -name-template says about documents
+var pageNameTemplate = template.Must(parseNameTemplate(defaultNameTemplate))
+
+// pageCachePath returns the file in the HTML cache directory that caches the page at uri, named
+// like a downloaded document but with the extension of its format
+func (scraper *Scraper) pageCachePath(uri string, extension string) string {
+	name := urlToFilename(uri, QueryNaming{Mode: "keep"}, pageNameTemplate) // Every page of a search differs only in its query
+	name = strings.TrimSuffix(name, getFileExtension(name)) + extension     // Search pages are HTML or JSON, not PDFs
+	return filepath.Join(scraper.config.HTMLCacheDir, name)
+}
+
+// readCachedPage returns the cached copy of the page at uri, in whichever format it was saved,
+// and its extractor; it returns nil if the page isn't cached or -refresh-html is set.
+//
+// The HTML cache goes through these states: a page missing from the cache is fetched and saved;
+// a cached page is read instead of fetched, unless -refresh-html fetches it again and replaces
+// the copy; after a completed crawl the cache is kept for the next run, unless -keep-html=false
+// removes it so the next run starts from nothing
+func (scraper *Scraper) readCachedPage(uri string) ([]byte, Extractor) {
+	if scraper.config.RefreshHTML {
+		return nil, nil // Every page is fetched again
+	}
+	for _, extension := range pageExtensions {
+		body, err := readFile(scraper.config.fileSystem(), scraper.pageCachePath(uri, extension)) // Page saved by an earlier run
+		if err == nil {
+			return body, extractorForExtension(extension, scraper.config.DocumentPatterns)
+		}
+	}
+	return nil, nil
+}
+
+// crawlLetter walks the search pages for one letter, starting at page 0 and following each
+// page's next link, or asking for the following page by number when a page that still lists
+// results has no next link the parser recognizes, until a page has no results or config.MaxPage
+// pages were followed, passing the PDF links of every page to emit; pages already in the HTML
+// cache are read from it instead of being fetched again
+func (scraper *Scraper) crawlLetter(ctx context.Context, letter rune, emit func(PDFLink)) {
+	request := buildSearchRequest(letter, 0, scraper.config.Search) // First results page for this letter
+	if !isUrlValid(request.url) {
+		return
+	}
+	visited := make(map[string]bool) // Pages crawled already, so a loop of next links ends
+	number := 0                      // Page number of the current request, as the search counts pages
+	for page := 0; page <= scraper.config.MaxPage && ctx.Err() == nil; page++ {
+		uri := request.key
+		visited[uri] = true
+		body, extractor := scraper.readCachedPage(uri) // Page saved by an earlier run
+		if body == nil {
+			body, extractor = scraper.getDataFromURL(ctx, request) // Fetch and cache the page
+		} else {
+			scraper.stats.PagesCached.Add(1)
+		}
+		if body == nil {
+			slog.Warn("Search page unavailable, stopping pagination", "letter", string(letter), "page", page, "url", uri)
+			return // Without the page there is no next link to follow
+		}
+		if !hasMoreResults(body, extractor) {
+			slog.Info("No more results, stopping pagination", "letter", string(letter), "page", page)
+			return // Results exhausted for this letter
+		}
+		for _, link := range extractor.Extract(body, searchBaseURL) {
+			emit(link) // Hand the link to the downloads right away
+		}
+		if request.method == http.MethodPost {
+			number++
+			request = buildSearchRequest(letter, number, scraper.config.Search) // POST APIs are paged by number, not by links
+			continue
+		}
+		base, _ := url.Parse(uri)                  // Valid, as it was fetched
+		next, ok := extractor.NextPage(body, base) // Link to the following page, if any
+		if !ok {
+			slog.Debug("No next page link, asking for the next page by number", "letter", string(letter), "page", page)
+			number++
+			request = buildSearchRequest(letter, number, scraper.config.Search) // The results may go on without a link we recognize
+			if visited[request.key] {
+				return // Numbered pages already crawled through next links
+			}
+			continue
+		}
+		if visited[next] {
+			slog.Info("Next page link loops back, stopping pagination", "letter", string(letter), "page", page)
+			return // Last page for this letter
+		}
+		number = linkedPageNumber(next, number+1)
+		request = getRequest(next)
+	}
+	slog.Warn("Reached -max-page, stopping pagination", "letter", string(letter), "max_page", scraper.config.MaxPage)
+}
+
+// linkedPageNumber returns the page number a next link asks for in its page parameter, or
+// fallback when it has none
+func linkedPageNumber(link string, fallback int) int {
+	parsed, err := url.Parse(link)
+	if err != nil {
+		return fallback
+	}
+	number, err := strconv.Atoi(parsed.Query().Get("page"))
+	if err != nil {
+		return fallback // Paged by something other than a page number
+	}
+	return number
+}
+
+// clearPageCache removes the cached pages in dir on fsys, then dir itself if nothing else is in it
+func clearPageCache(fsys FS, dir string) error {
+	for _, extension := range pageExtensions {
+		pages, err := fsys.Glob(filepath.Join(dir, "*"+extension))
+		if err != nil {
+			return err
+		}
+		for _, page := range pages {
+			if err := removeFile(fsys, page); err != nil {
+				return err
+			}
+		}
+	}
+	if err := removeFile(fsys, dir); err != nil && !errors.Is(err, os.ErrNotExist) {
+		slog.Debug("Keeping HTML cache directory", "path", dir, "error", err) // Holds files the scraper didn't write
+	}
+	return nil
+}
+
+// crawlSearchLinks crawls the SDS search pages into the HTML cache directory, fetching only
+// pages that aren't cached yet, and passes the PDF links found on every page to emit, which
+// is called from several goroutines at once
+func (scraper *Scraper) crawlSearchLinks(ctx context.Context, emit func(PDFLink)) error {
+	cacheDir := scraper.config.HTMLCacheDir // Directory holding one file per search page
+	if err := scraper.config.fileSystem().MkdirAll(cacheDir, 0o755); err != nil {
+		return err
+	}
+	removeStaleTempFiles(scraper.config.fileSystem(), cacheDir) // Pages half-written by a killed run
+
+	letters := []rune(scraper.config.Letters) // Alphabetical unless -shuffle is set
+	scraper.jitter.shuffle(letters)           // Pages of a letter stay in order, as each is found from the last
+	var scrapeTasks []func()                  // Tasks for the scraping phase
+	for _, letter := range letters {          // Loop over each letter
+		scrapeTasks = append(scrapeTasks, func() { scraper.crawlLetter(ctx, letter, emit) }) // Queue the letter's pages
+	}
+	workerPool(ctx, scrapeTasks, scraper.config.scrapeWorkers()) // Crawl letters with bounded concurrency
+	return nil
+}
+
+// readURLFile reads newline-separated URLs from path on fsys, ignoring blank lines and # comments and
+// logging lines that aren't valid URLs
+func readURLFile(fsys FS, path string) ([]string, error) {
+	content, err := readFile(fsys, path) // Read the whole list
+	if err != nil {
+		return nil, err
+	}
+	var urls []string
+	for number, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue // Skip blanks and comments
+		}
+		if !isUrlValid(line) {
+			slog.Warn("Skipping invalid URL", "path", path, "line", number+1, "url", line)
+			continue
+		}
+		urls = append(urls, line) // Keep valid URLs in file order
+	}
+	return urls, nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"         // Provides buffer for reading/writing data
+	"compress/gzip" // For .xml.gz sitemaps
+	"context"       // For cancelling in-flight work
+	"encoding/xml"  // For parsing sitemaps
+	"fmt"           // For formatted I/O operations
+	"io"            // For general I/O primitives
+	"log/slog"      // For structured, levelled logging
+	"net/http"      // For checking response statuses
+	"net/url"       // For parsing and manipulating URLs
+)
+
+// maxSitemapBytes caps a sitemap's uncompressed size at the 50 MB the sitemaps protocol allows
+const maxSitemapBytes = 50 << 20
+
+// maxSitemapDepth is how many levels of sitemap index files are followed
+const maxSitemapDepth = 5
+
+// sitemapDocument holds either kind of sitemap file: a <urlset> of pages or a <sitemapindex>
+// of further sitemaps
+type sitemapDocument struct {
+	XMLName  xml.Name       // urlset or sitemapindex
+	URLs     []sitemapEntry `xml:"url"`     // Pages of a urlset
+	Sitemaps []sitemapEntry `xml:"sitemap"` // Sitemaps of a sitemapindex
+}
+
+// sitemapEntry is one <url> or <sitemap> element
+type sitemapEntry struct {
+	Location string `xml:"loc"`     // Absolute URL
+	Modified string `xml:"lastmod"` // Optional W3C datetime of the last change
+}
+
+// fetchSitemap downloads the sitemap at uri, decompressing it if it is gzipped
+func (scraper *Scraper) fetchSitemap(ctx context.Context, uri string) ([]byte, error) {
+	response, err := httpGetWithRetry(ctx, scraper.client, scraper.limiter, uri, nil, scraper.config.RequestTimeout, scraper.config)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", response.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(response.Body, maxSitemapBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(body, []byte{0x1f, 0x8b}) { // .xml.gz served as a file, not with Content-Encoding
+		reader, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		if body, err = io.ReadAll(io.LimitReader(reader, maxSitemapBytes+1)); err != nil {
+			return nil, err
+		}
+	}
+	if len(body) > maxSitemapBytes {
+		return nil, fmt.Errorf("sitemap is larger than %d bytes", maxSitemapBytes)
+	}
+	return body, nil
+}
+
+// collectSitemap reads the sitemap at uri and, through the sitemap index files it names, every
+// sitemap below it, passing each listed PDF to emit and collecting the other pages in pages;
+// visited stops index files that name each other from looping
+func (scraper *Scraper) collectSitemap(ctx context.Context, uri string, depth int, visited map[string]bool, emit func(PDFLink), pages *[]string) {
+	if visited[uri] || ctx.Err() != nil {
+		return
+	}
+	visited[uri] = true
+	body, err := scraper.fetchSitemap(ctx, uri)
+	if err != nil {
+		slog.Error("Failed to fetch sitemap", "url", uri, "error", err)
+		scraper.stats.PagesFailed.Add(1)
+		return
+	}
+	scraper.stats.PagesFetched.Add(1)
+	scraper.events.emit(Event{Event: eventPageFetched, URL: uri, Bytes: int64(len(body))})
+	var document sitemapDocument
+	if err := xml.Unmarshal(body, &document); err != nil {
+		slog.Error("Failed to parse sitemap", "url", uri, "error", err)
+		return
+	}
+	base, _ := url.Parse(uri) // Valid, as it was fetched
+	switch document.XMLName.Local {
+	case "sitemapindex":
+		if depth >= maxSitemapDepth {
+			slog.Warn("Sitemap indexes nested too deeply, not following", "url", uri, "max_depth", maxSitemapDepth)
+			return
+		}
+		for _, sitemap := range document.Sitemaps {
+			if next, ok := resolvePageLink(sitemap.Location, base); ok {
+				scraper.collectSitemap(ctx, next, depth+1, visited, emit, pages) // Child sitemap on the same site
+			}
+		}
+	case "urlset":
+		slog.Info("Read sitemap", "url", uri, "urls", len(document.URLs))
+		for _, entry := range document.URLs {
+			if link, ok := resolvePDFLink(entry.Location, base, scraper.config.DocumentPatterns); ok {
+				document := PDFLink{URL: link}
+				document.Modified, _ = parseDocumentDate(entry.Modified) // Zero when lastmod is missing
+				emit(document)                                           // Listed document, downloaded right away
+			} else if page, ok := resolvePageLink(entry.Location, base); ok {
+				*pages = append(*pages, page) // May link to documents
+			}
+		}
+	default:
+		slog.Error("Not a sitemap", "url", uri, "root", document.XMLName.Local)
+	}
+}
+
+// crawlSitemap collects the URLs listed by the sitemap at config.Sitemap instead of crawling the
+// search: documents are passed to emit as they are read, and every other page is then scraped,
+// through the HTML cache, for the PDF links on it; emit is called from several goroutines at once
+func (scraper *Scraper) crawlSitemap(ctx context.Context, emit func(PDFLink)) error {
+	if err := scraper.config.fileSystem().MkdirAll(scraper.config.HTMLCacheDir, 0o755); err != nil {
+		return err
+	}
+	removeStaleTempFiles(scraper.config.fileSystem(), scraper.config.HTMLCacheDir) // Pages half-written by a killed run
+
+	var pages []string // On-site pages listed by the sitemap
+	scraper.collectSitemap(ctx, scraper.config.Sitemap, 0, make(map[string]bool), emit, &pages)
+	slog.Info("Scraping pages listed in the sitemap", "pages", len(pages))
+
+	var tasks []func()
+	for _, page := range pages {
+		tasks = append(tasks, func() {
+			body, extractor := scraper.readCachedPage(page) // Page saved by an earlier run
+			if body == nil {
+				body, extractor = scraper.getDataFromURL(ctx, getRequest(page))
+			} else {
+				scraper.stats.PagesCached.Add(1)
+			}
+			if body == nil {
+				return // Already logged
+			}
+			base, _ := url.Parse(page) // Valid, as the sitemap listed it
+			for _, link := range extractor.Extract(body, base) {
+				emit(link) // Hand the link to the downloads right away
+			}
+		})
+	}
+	workerPool(ctx, tasks, scraper.config.scrapeWorkers())
+	return nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"           // For crawl contexts
+	"fmt"               // For the served sitemaps
+	"maps"              // For the emitted URLs
+	"net/http"          // For test handlers
+	"net/http/httptest" // For test servers
+	"slices"            // For comparing links
+	"strings"           // For building sitemaps
+	"sync"              // For guarding emitted links
+	"testing"           // For the test framework
+	"time"              // For lastmod dates
+)
+
+// sitemapServer serves a sitemap index naming a gzipped sitemap of documents, a sitemap of
+// pages, itself, and an off-site sitemap, and the pages the second one lists
+func sitemapServer(t *testing.T) *httptest.Server {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		base := server.URL
+		switch request.URL.Path {
+		case "/sitemap.xml":
+			fmt.Fprintf(writer, `<?xml version="1.0" encoding="UTF-8"?>
+<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+	<sitemap><loc>%[1]s/sitemap-sds.xml.gz</loc></sitemap>
+	<sitemap><loc>%[1]s/sitemap-pages.xml</loc></sitemap>
+	<sitemap><loc>%[1]s/sitemap.xml</loc></sitemap>
+	<sitemap><loc>https://example.com/sitemap.xml</loc></sitemap>
+</sitemapindex>`, base)
+		case "/sitemap-sds.xml.gz":
+			writer.Header().Set("Content-Type", "application/x-gzip")
+			writer.Write(encodeBody(t, "gzip", []byte(fmt.Sprintf(`<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+	<url><loc>%[1]s/msds/001001.pdf</loc><lastmod>2024-03-01</lastmod></url>
+	<url><loc>%[1]s/msds/001002.PDF</loc></url>
+</urlset>`, base))))
+		case "/sitemap-pages.xml":
+			fmt.Fprintf(writer, `<urlset><url><loc>%[1]s/products/acetylene</loc></url><url><loc>https://example.com/elsewhere</loc></url></urlset>`, base)
+		case "/products/acetylene":
+			writer.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(writer, `<a href="/msds/001003.pdf">SDS</a><a href="/products">All products</a>`)
+		default:
+			http.NotFound(writer, request)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestCrawlSitemap(t *testing.T) {
+	server := sitemapServer(t)
+	config := testConfig(t)
+	config.HTMLCacheDir = t.TempDir()
+	config.Sitemap = server.URL + "/sitemap.xml"
+	scraper := newTestScraper(t, config, server.Client())
+	var mutex sync.Mutex
+	links := make(map[string]PDFLink)
+	err := scraper.crawlSitemap(context.Background(), func(link PDFLink) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		links[link.URL] = link
+	})
+	if err != nil {
+		t.Fatalf("crawlSitemap: %v", err)
+	}
+	want := []string{server.URL + "/msds/001001.pdf", server.URL + "/msds/001002.PDF", server.URL + "/msds/001003.pdf"}
+	if got := slices.Sorted(maps.Keys(links)); !slices.Equal(got, want) {
+		t.Errorf("links %q, want %q", got, want)
+	}
+	if modified := links[want[0]].Modified; !modified.Equal(time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("lastmod of %s = %s, want 2024-03-01", want[0], modified)
+	}
+	if !links[want[1]].Modified.IsZero() {
+		t.Errorf("a document without lastmod got %s", links[want[1]].Modified)
+	}
+	if fetched := scraper.stats.PagesFetched.Load(); fetched != 4 {
+		t.Errorf("PagesFetched = %d, want the 3 same-site sitemaps and the listed page", fetched)
+	}
+}
+
+func TestFetchSitemapRejections(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		switch request.URL.Path {
+		case "/missing.xml":
+			http.NotFound(writer, request)
+		case "/corrupt.xml.gz":
+			writer.Write([]byte{0x1f, 0x8b, 0, 0})
+		case "/huge.xml":
+			writer.Write([]byte(strings.Repeat(" ", maxSitemapBytes+1)))
+		}
+	}))
+	defer server.Close()
+	scraper := newTestScraper(t, testConfig(t), server.Client())
+	for _, path := range []string{"/missing.xml", "/corrupt.xml.gz", "/huge.xml"} {
+		if body, err := scraper.fetchSitemap(context.Background(), server.URL+path); err == nil {
+			t.Errorf("%s: fetched %d bytes, want an error", path, len(body))
+		}
+	}
+}
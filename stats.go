@@ -0,0 +1,188 @@
+package main
+
+import (
+	"cmp"         // For ordering timings
+	"context"     // For cancelling in-flight work
+	"fmt"         // For formatted I/O operations
+	"io"          // For general I/O primitives
+	"slices"      // For sorting timings
+	"sync"        // For guarding timings
+	"sync/atomic" // For counters shared between goroutines
+	"time"        // For time-related operations
+)
+
+// Stats counts what happened during a run; every counter is updated atomically from many
+// goroutines, and timings is guarded by timingsMutex
+type Stats struct {
+	Start            time.Time    // When the run started
+	Requests         atomic.Int64 // HTTP requests sent, including retries and redirects
+	PagesFetched     atomic.Int64 // Search pages saved to the HTML cache
+	PagesCached      atomic.Int64 // Search pages read from the HTML cache instead of fetched
+	PagesFailed      atomic.Int64 // Search pages that could not be fetched
+	LinksFound       atomic.Int64 // Unique PDF links extracted from the pages
+	NewDocuments     atomic.Int64 // PDFs not in the mirror yet, counted with -only-new or -new-list
+	Downloaded       atomic.Int64 // PDFs downloaded and saved
+	SkippedExisting  atomic.Int64 // PDFs skipped because the file already existed
+	SkippedDuplicate atomic.Int64 // PDFs skipped because identical content was already saved
+	SkippedOld       atomic.Int64 // PDFs skipped because they were last updated before -since
+	SkippedTooLarge  atomic.Int64 // PDFs skipped because they exceed -max-file-size or the -max-bytes budget left
+	SkippedOffSite   atomic.Int64 // PDFs skipped because they redirect away from airgas.com with -same-host
+	SkippedInvalid   atomic.Int64 // PDFs skipped because their URL doesn't give a usable filename
+	Failed           atomic.Int64 // PDFs that could not be downloaded or saved
+	Cataloged        atomic.Int64 // PDFs recorded from their headers alone with -head-only
+	CatalogedBytes   atomic.Int64 // Sizes the servers reported for the cataloged PDFs
+	Cancelled        atomic.Int64 // PDFs abandoned because the run was cancelled or a limit was hit
+	Bytes            atomic.Int64 // Total bytes of saved PDFs
+	InFlight         atomic.Int64 // PDF downloads currently in progress
+	LimitReached     atomic.Bool  // Whether -max-files or -max-bytes stopped the download phase
+	Paused           atomic.Int64 // Nanoseconds the run spent paused by SIGUSR1 or -pause-file
+	SizeMismatches   atomic.Int64 // Download attempts whose size differed from the declared Content-Length
+	timingsMutex     sync.Mutex
+	timings          []DownloadTiming // Every download of the run, for the slowest-downloads report
+}
+
+// DownloadTiming is how long one download took and how much it transferred
+type DownloadTiming struct {
+	URL      string        // URL the PDF was downloaded from
+	Bytes    int64         // Bytes transferred by this run
+	Duration time.Duration // From the first request, retries included, to the last byte
+}
+
+// throughput returns the download's bytes per second
+func (timing DownloadTiming) throughput() float64 {
+	return float64(timing.Bytes) / max(timing.Duration.Seconds(), 1e-9)
+}
+
+// recordTiming adds a finished download to the slowest-downloads report
+func (stats *Stats) recordTiming(timing DownloadTiming) {
+	stats.timingsMutex.Lock()
+	defer stats.timingsMutex.Unlock()
+	stats.timings = append(stats.timings, timing)
+}
+
+// printSlowest writes the count slowest downloads of the run, and the count with the lowest
+// throughput, to w; it writes nothing when count is 0 or nothing was downloaded
+func (stats *Stats) printSlowest(w io.Writer, count int) {
+	stats.timingsMutex.Lock()
+	timings := slices.Clone(stats.timings)
+	stats.timingsMutex.Unlock()
+	if count <= 0 || len(timings) == 0 {
+		return
+	}
+	slices.SortStableFunc(timings, func(a, b DownloadTiming) int { return cmp.Compare(b.Duration, a.Duration) })
+	fmt.Fprintln(w, "Slowest downloads:")
+	for _, timing := range timings[:min(count, len(timings))] {
+		fmt.Fprintf(w, "  %10s  %10d bytes  %s\n", timing.Duration.Round(time.Millisecond), timing.Bytes, timing.URL)
+	}
+	slices.SortStableFunc(timings, func(a, b DownloadTiming) int { return cmp.Compare(a.throughput(), b.throughput()) })
+	fmt.Fprintln(w, "Lowest throughput:")
+	for _, timing := range timings[:min(count, len(timings))] {
+		fmt.Fprintf(w, "  %8.1f KB/s  %10d bytes  %s\n", timing.throughput()/1024, timing.Bytes, timing.URL)
+	}
+}
+
+// newStats creates a Stats whose elapsed time is measured from now
+func newStats() *Stats {
+	return &Stats{Start: time.Now()}
+}
+
+// downloadFailed counts a download that didn't complete, separating cancellations from real failures
+func (stats *Stats) downloadFailed(ctx context.Context) {
+	if ctx.Err() != nil {
+		stats.Cancelled.Add(1) // Stopped on purpose, not a failure
+		return
+	}
+	stats.Failed.Add(1)
+}
+
+// limitReached reports whether the run has used up its -max-files or -max-bytes budget
+func limitReached(config Config, stats *Stats) bool {
+	filesDone := config.MaxFiles > 0 && stats.Downloaded.Load() >= int64(config.MaxFiles) // File budget used up
+	bytesDone := config.MaxBytes > 0 && stats.Bytes.Load() >= config.MaxBytes             // Byte budget used up
+	return filesDone || bytesDone
+}
+
+// progressInterval is how often progress is reported during the download phase
+const progressInterval = 10 * time.Second
+
+// downloadsDone returns how many downloads have finished, however they ended
+func (stats *Stats) downloadsDone() int64 {
+	return stats.Downloaded.Load() + stats.SkippedExisting.Load() + stats.SkippedDuplicate.Load() + stats.SkippedOld.Load() +
+		stats.SkippedTooLarge.Load() + stats.SkippedOffSite.Load() + stats.SkippedInvalid.Load() + stats.Cataloged.Load() + stats.Failed.Load() + stats.Cancelled.Load()
+}
+
+// startProgress writes a progress line to w every interval until the returned function is called:
+// downloads finished out of the total known so far, as returned by total, throughput since the
+// last line and the estimated time left
+func (stats *Stats) startProgress(w io.Writer, interval time.Duration, total func() int64) (stop func()) {
+	done := make(chan struct{})     // Closed to stop the reporter
+	finished := make(chan struct{}) // Closed once the reporter has exited
+	go func() {
+		defer close(finished)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		start := time.Now()                              // When the download phase started
+		startDone := stats.downloadsDone()               // Documents counted before the phase started, such as -since skips
+		lastBytes, lastTick := stats.Bytes.Load(), start // Previous sample for the throughput
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				completed, bytes, total := stats.downloadsDone()-startDone, stats.Bytes.Load(), total() // Progress within this phase
+				throughput := float64(bytes-lastBytes) / (1 << 20) / now.Sub(lastTick).Seconds()        // MB/s over the last interval
+				lastBytes, lastTick = bytes, now
+				eta := "unknown"
+				if rate := float64(completed) / now.Sub(start).Seconds(); rate > 0 {
+					remaining := time.Duration(float64(total-completed) / rate * float64(time.Second))
+					eta = remaining.Round(time.Second).String() // At the average pace so far
+				}
+				percent := 0.0
+				if total > 0 {
+					percent = float64(completed) / float64(total) * 100
+				}
+				fmt.Fprintf(w, "Progress: %d/%d downloads (%.1f%%), %.2f MB/s, ETA %s\n", completed, total, percent, throughput, eta)
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-finished // Don't write after the caller moves on
+	}
+}
+
+// printSummary writes a human-readable report of the run to w
+func (stats *Stats) printSummary(w io.Writer) {
+	fmt.Fprintln(w, "Run summary:")
+	fmt.Fprintf(w, "  Requests sent:      %d\n", stats.Requests.Load())
+	fmt.Fprintf(w, "  Pages fetched:      %d\n", stats.PagesFetched.Load())
+	fmt.Fprintf(w, "  Pages cached:       %d\n", stats.PagesCached.Load())
+	fmt.Fprintf(w, "  Pages failed:       %d\n", stats.PagesFailed.Load())
+	fmt.Fprintf(w, "  Links found:        %d\n", stats.LinksFound.Load())
+	fmt.Fprintf(w, "  PDFs downloaded:    %d\n", stats.Downloaded.Load())
+	fmt.Fprintf(w, "  Skipped (existing): %d\n", stats.SkippedExisting.Load())
+	fmt.Fprintf(w, "  Skipped (dupes):    %d\n", stats.SkippedDuplicate.Load())
+	fmt.Fprintf(w, "  Skipped (old):      %d\n", stats.SkippedOld.Load())
+	fmt.Fprintf(w, "  Skipped (too big):  %d\n", stats.SkippedTooLarge.Load())
+	fmt.Fprintf(w, "  Skipped (off-site): %d\n", stats.SkippedOffSite.Load())
+	fmt.Fprintf(w, "  Skipped (bad URL):  %d\n", stats.SkippedInvalid.Load())
+	fmt.Fprintf(w, "  Failed:             %d\n", stats.Failed.Load())
+	fmt.Fprintf(w, "  Cancelled:          %d\n", stats.Cancelled.Load())
+	fmt.Fprintf(w, "  Total bytes:        %d (%.2f MB)\n", stats.Bytes.Load(), float64(stats.Bytes.Load())/(1<<20))
+	fmt.Fprintf(w, "  Elapsed time:       %s\n", time.Since(stats.Start).Round(time.Millisecond))
+	if paused := time.Duration(stats.Paused.Load()); paused > 0 {
+		fmt.Fprintf(w, "  Paused time:        %s (of the elapsed time)\n", paused.Round(time.Millisecond))
+	}
+	if count := stats.SizeMismatches.Load(); count > 0 {
+		fmt.Fprintf(w, "  Size mismatches:    %d (truncated transfers, retried or failed)\n", count)
+	}
+	if count := stats.Cataloged.Load(); count > 0 {
+		fmt.Fprintf(w, "  Cataloged:          %d (%.2f MB reported, not downloaded)\n", count, float64(stats.CatalogedBytes.Load())/(1<<20))
+	}
+	if count := stats.NewDocuments.Load(); count > 0 {
+		fmt.Fprintf(w, "  New documents:      %d (not in the mirror before this run)\n", count)
+	}
+	if stats.LimitReached.Load() {
+		fmt.Fprintln(w, "  Stopped early: the -max-files/-max-bytes limit was reached")
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"       // For cancelling in-flight work
+	"fmt"           // For formatted I/O operations
+	"io"            // For general I/O primitives
+	"log/slog"      // For structured, levelled logging
+	"mime"          // For content types of stored documents
+	"net/url"       // For parsing and manipulating URLs
+	"path"          // For joining object keys
+	"path/filepath" // For manipulating filename paths
+	"strings"       // For string manipulation
+	"sync"          // For handling concurrency
+
+	"github.com/aws/aws-sdk-go-v2/aws"              // For AWS value helpers
+	awsconfig "github.com/aws/aws-sdk-go-v2/config" // For loading AWS credentials and region
+	"github.com/aws/aws-sdk-go-v2/service/s3"       // For the S3 API
+)
+
+// Storage is where downloaded documents are kept; names are paths relative to its root
+type Storage interface {
+	Exists(name string) bool                                        // Whether a document is stored under name
+	Write(ctx context.Context, name string, reader io.Reader) error // Store reader's content under name
+}
+
+// fileAdopter is implemented by storages that can take over a finished local file without
+// copying it
+type fileAdopter interface {
+	Adopt(name string, localPath string) error // Move the file at localPath to name
+}
+
+// localStorage keeps documents in a directory on the local filesystem
+type localStorage struct {
+	fs  FS     // Filesystem holding the directory
+	dir string // Root directory of the stored documents
+}
+
+// Exists reports whether name exists below the storage directory
+func (storage *localStorage) Exists(name string) bool {
+	filePath, err := safeJoin(storage.dir, name)
+	return err == nil && fileExists(storage.fs, filePath) // Names outside the directory are never stored
+}
+
+// Write stores reader's content under name via a temp file, so a partial write never looks
+// like a finished document
+func (storage *localStorage) Write(ctx context.Context, name string, reader io.Reader) error {
+	filePath, err := safeJoin(storage.dir, name)
+	if err != nil {
+		return err // Would escape the storage directory
+	}
+	dir := filepath.Dir(filePath) // Storage directory or a shard subdirectory
+	if err := storage.fs.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	tempPath, _, _, err := writeTempFile(ctx, storage.fs, dir, filepath.Base(name), reader) // Stream to a temp file
+	if err != nil {
+		return err
+	}
+	return storage.Adopt(name, tempPath)
+}
+
+// Adopt moves the finished file at localPath to name
+func (storage *localStorage) Adopt(name string, localPath string) error {
+	filePath, err := safeJoin(storage.dir, name)
+	if err != nil {
+		discardFile(storage.fs, localPath)
+		return err // Would escape the storage directory
+	}
+	if err := storage.fs.MkdirAll(filepath.Dir(filePath), 0o755); err != nil { // Shard directory, which may differ from the staging one
+		discardFile(storage.fs, localPath)
+		return err
+	}
+	if err := moveFile(storage.fs, localPath, filePath); err != nil { // Publish the finished file under its final name
+		discardFile(storage.fs, localPath) // Drop the orphaned temp file
+		return err
+	}
+	return nil
+}
+
+// s3Storage keeps documents as objects below a key prefix in an S3 bucket
+type s3Storage struct {
+	client *s3.Client      // S3 API client
+	bucket string          // Bucket holding the documents
+	prefix string          // Key prefix, without a trailing slash
+	mutex  sync.Mutex      // Guards keys
+	keys   map[string]bool // Keys below prefix, listed once at start-up and kept up to date
+}
+
+// newS3Storage connects to the bucket and prefix of an s3://bucket/prefix URL using the standard
+// AWS credential chain, and lists the objects already stored there
+func newS3Storage(ctx context.Context, location *url.URL) (*s3Storage, error) {
+	awsConfig, err := awsconfig.LoadDefaultConfig(ctx) // Credentials and region from the environment
+	if err != nil {
+		return nil, fmt.Errorf("loading AWS configuration: %w", err)
+	}
+	storage := &s3Storage{
+		client: s3.NewFromConfig(awsConfig),
+		bucket: location.Host,
+		prefix: strings.Trim(location.Path, "/"),
+		keys:   make(map[string]bool),
+	}
+	listPrefix := storage.prefix
+	if listPrefix != "" {
+		listPrefix += "/" // Only objects below the prefix
+	}
+	paginator := s3.NewListObjectsV2Paginator(storage.client, &s3.ListObjectsV2Input{Bucket: aws.String(storage.bucket), Prefix: aws.String(listPrefix)})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx) // One page of existing objects
+		if err != nil {
+			return nil, fmt.Errorf("listing s3://%s/%s: %w", storage.bucket, listPrefix, err)
+		}
+		for _, object := range page.Contents {
+			storage.keys[aws.ToString(object.Key)] = true // Remember what's already stored
+		}
+	}
+	slog.Info("Using S3 storage", "bucket", storage.bucket, "prefix", storage.prefix, "objects", len(storage.keys))
+	return storage, nil
+}
+
+// key returns the object key for name
+func (storage *s3Storage) key(name string) string {
+	return path.Join(storage.prefix, filepath.ToSlash(name)) // Keys always use forward slashes
+}
+
+// Exists reports whether an object is stored under name
+func (storage *s3Storage) Exists(name string) bool {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+	return storage.keys[storage.key(name)]
+}
+
+// Write uploads reader's content as the object for name
+func (storage *s3Storage) Write(ctx context.Context, name string, reader io.Reader) error {
+	key := storage.key(name)
+	contentType := mime.TypeByExtension(path.Ext(key)) // application/pdf for .pdf
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	_, err := storage.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(storage.bucket),
+		Key:         aws.String(key),
+		Body:        reader,
+		ContentType: aws.String(contentType),
+	})
+	if err != nil {
+		return fmt.Errorf("uploading s3://%s/%s: %w", storage.bucket, key, err)
+	}
+	storage.mutex.Lock()
+	storage.keys[key] = true // Stored now
+	storage.mutex.Unlock()
+	return nil
+}
+
+// newStorage returns the storage selected by -storage: the output directory on fsys when
+// location is empty, or an S3 bucket for an s3://bucket/prefix URL
+func newStorage(ctx context.Context, fsys FS, location string, outputDir string) (Storage, error) {
+	if location == "" {
+		return &localStorage{fs: fsys, dir: outputDir}, nil // Default: files in the output directory
+	}
+	parsed, err := url.Parse(location)
+	if err != nil || parsed.Scheme != "s3" || parsed.Host == "" {
+		return nil, fmt.Errorf("invalid -storage %q: want s3://bucket/prefix", location)
+	}
+	return newS3Storage(ctx, parsed)
+}
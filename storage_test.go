@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"             // For stored content
+	"context"           // For storage contexts
+	"errors"            // For inspecting error values
+	"io"                // For reading uploads
+	"net/http"          // For the fake S3 API
+	"net/http/httptest" // For test servers
+	"os"                // For reading stored files
+	"path/filepath"     // For storage paths
+	"strings"           // For storage locations
+	"sync"              // For guarding uploaded objects
+	"testing"           // For the test framework
+
+	"github.com/aws/aws-sdk-go-v2/aws"        // For S3 client settings
+	"github.com/aws/aws-sdk-go-v2/service/s3" // For the S3 client
+)
+
+func TestLocalStorage(t *testing.T) {
+	dir := t.TempDir()
+	storage := &localStorage{fs: osFS{}, dir: dir}
+	if storage.Exists("a.pdf") {
+		t.Fatal("a.pdf exists in an empty storage")
+	}
+	if err := storage.Write(context.Background(), "sub/a.pdf", strings.NewReader("%PDF-a")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if got, _ := os.ReadFile(filepath.Join(dir, "sub", "a.pdf")); string(got) != "%PDF-a" || !storage.Exists("sub/a.pdf") {
+		t.Errorf("stored %q, Exists %v", got, storage.Exists("sub/a.pdf"))
+	}
+
+	staged := filepath.Join(t.TempDir(), "staged.tmp")
+	os.WriteFile(staged, []byte("%PDF-b"), 0o644)
+	if err := storage.Adopt("b.pdf", staged); err != nil {
+		t.Fatalf("Adopt: %v", err)
+	}
+	if got, _ := os.ReadFile(filepath.Join(dir, "b.pdf")); string(got) != "%PDF-b" || fileExists(osFS{}, staged) {
+		t.Errorf("adopted %q, staged file still there: %v", got, fileExists(osFS{}, staged))
+	}
+	if leftovers, _ := filepath.Glob(filepath.Join(dir, "sub", "*"+tempFileSuffix)); len(leftovers) != 0 {
+		t.Errorf("temp files left behind: %v", leftovers)
+	}
+
+	escaping := filepath.Join(t.TempDir(), "escaping.tmp")
+	os.WriteFile(escaping, []byte("%PDF-c"), 0o644)
+	if err := storage.Adopt("../c.pdf", escaping); !errors.Is(err, errPathEscapes) {
+		t.Errorf("Adopt outside the directory: err = %v", err)
+	}
+	if err := storage.Write(context.Background(), "../c.pdf", strings.NewReader("%PDF-c")); !errors.Is(err, errPathEscapes) {
+		t.Errorf("Write outside the directory: err = %v", err)
+	}
+	if fileExists(osFS{}, filepath.Join(dir, "..", "c.pdf")) || fileExists(osFS{}, escaping) {
+		t.Error("a document outside the directory was written, or its staged file kept")
+	}
+	os.WriteFile(filepath.Join(dir, "..", "outside.pdf"), []byte("%PDF-d"), 0o644)
+	if storage.Exists("../outside.pdf") {
+		t.Error("a file outside the directory exists in storage")
+	}
+}
+
+// fakeS3 serves path-style PutObject requests, keeping the uploaded objects
+type fakeS3 struct {
+	mutex   sync.Mutex
+	objects map[string][]byte // Body by /bucket/key path
+	types   map[string]string // Content-Type by /bucket/key path
+}
+
+// ServeHTTP stores a PUT's body
+func (fake *fakeS3) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodPut {
+		http.Error(writer, "unsupported", http.StatusNotImplemented)
+		return
+	}
+	body, _ := io.ReadAll(request.Body)
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+	fake.objects[request.URL.Path] = body
+	fake.types[request.URL.Path] = request.Header.Get("Content-Type")
+}
+
+func TestS3Storage(t *testing.T) {
+	fake := &fakeS3{objects: make(map[string][]byte), types: make(map[string]string)}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	client := s3.New(s3.Options{
+		Region:       "us-east-1",
+		BaseEndpoint: aws.String(server.URL),
+		UsePathStyle: true,
+		Credentials:  aws.AnonymousCredentials{},
+	})
+	storage := &s3Storage{client: client, bucket: "sds", prefix: "mirror", keys: map[string]bool{"mirror/old.pdf": true}}
+
+	if !storage.Exists("old.pdf") || storage.Exists("new.pdf") {
+		t.Errorf("Exists(old.pdf) = %v, Exists(new.pdf) = %v; want only the listed object", storage.Exists("old.pdf"), storage.Exists("new.pdf"))
+	}
+	for _, name := range []string{"a/new.pdf", "scan.png", "notes"} {
+		if err := storage.Write(context.Background(), name, bytes.NewReader([]byte("content of "+name))); err != nil {
+			t.Fatalf("Write(%s): %v", name, err)
+		}
+		if !storage.Exists(name) {
+			t.Errorf("%s doesn't exist after it was written", name)
+		}
+	}
+	for path, want := range map[string]string{"/sds/mirror/a/new.pdf": "application/pdf", "/sds/mirror/scan.png": "image/png", "/sds/mirror/notes": "application/octet-stream"} {
+		if body, ok := fake.objects[path]; !ok || !strings.HasPrefix(string(body), "content of ") {
+			t.Errorf("object %s = %q, %v", path, body, ok)
+		}
+		if fake.types[path] != want {
+			t.Errorf("object %s has Content-Type %q, want %q", path, fake.types[path], want)
+		}
+	}
+	if key := (&s3Storage{}).key("a/b.pdf"); key != "a/b.pdf" {
+		t.Errorf("key without a prefix = %q, want the name itself", key)
+	}
+}
+
+func TestNewStorage(t *testing.T) {
+	storage, err := newStorage(context.Background(), osFS{}, "", "PDFs")
+	if local, ok := storage.(*localStorage); err != nil || !ok || local.dir != "PDFs" {
+		t.Errorf("default storage = %#v, %v; want the output directory", storage, err)
+	}
+	for _, location := range []string{"PDFs/", "gs://bucket/prefix", "s3:///prefix", "s3://%zz"} {
+		if _, err := newStorage(context.Background(), osFS{}, location, "PDFs"); err == nil || !strings.Contains(err.Error(), "want s3://bucket/prefix") {
+			t.Errorf("newStorage(%q): err = %v", location, err)
+		}
+	}
+}
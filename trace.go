@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"            // For attaching traces to requests
+	"crypto/tls"         // For TLS handshake results
+	"log/slog"           // For structured, levelled logging
+	"net/http"           // For HTTP client functionality
+	"net/http/httptrace" // For timing the phases of a request
+	"sync"               // For handling concurrency
+	"sync/atomic"        // For the send time shared with the transport
+	"time"               // For time-related operations
+)
+
+// requestTimings collects how long each phase of one request took; connection callbacks can run
+// on other goroutines, so fields are guarded by mutex
+type requestTimings struct {
+	mutex        sync.Mutex
+	start        time.Time     // When the request was handed to the transport
+	dnsStart     time.Time     // When the DNS lookup started
+	dns          time.Duration // DNS lookup
+	connectStart time.Time     // When dialing started
+	connect      time.Duration // TCP connect
+	tlsStart     time.Time     // When the TLS handshake started
+	tls          time.Duration // TLS handshake
+	reused       bool          // Whether a pooled connection was used, skipping the phases above
+	firstByte    time.Duration // From the start to the first response byte
+}
+
+// clientTrace returns the hooks that fill in timings
+func (timings *requestTimings) clientTrace() *httptrace.ClientTrace {
+	// record calls update with the current time, under the mutex
+	record := func(update func(now time.Time)) {
+		timings.mutex.Lock()
+		defer timings.mutex.Unlock()
+		update(time.Now())
+	}
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) { record(func(now time.Time) { timings.dnsStart = now }) },
+		DNSDone:  func(httptrace.DNSDoneInfo) { record(func(now time.Time) { timings.dns = now.Sub(timings.dnsStart) }) },
+		ConnectStart: func(string, string) {
+			record(func(now time.Time) {
+				if timings.connectStart.IsZero() {
+					timings.connectStart = now // First of possibly several dual-stack dials
+				}
+			})
+		},
+		ConnectDone: func(_, _ string, err error) {
+			record(func(now time.Time) {
+				if err == nil {
+					timings.connect = now.Sub(timings.connectStart) // Dial that won
+				}
+			})
+		},
+		TLSHandshakeStart: func() { record(func(now time.Time) { timings.tlsStart = now }) },
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			record(func(now time.Time) { timings.tls = now.Sub(timings.tlsStart) })
+		},
+		GotConn:              func(info httptrace.GotConnInfo) { record(func(time.Time) { timings.reused = info.Reused }) },
+		GotFirstResponseByte: func() { record(func(now time.Time) { timings.firstByte = now.Sub(timings.start) }) },
+	}
+}
+
+// tracingTransport logs, at debug level, how long the DNS lookup, connect, TLS handshake and
+// wait for the first byte took for every request, telling a slow server from a slow network
+type tracingTransport struct {
+	base http.RoundTripper // Transport that sends the requests
+}
+
+// RoundTrip sends the request with a client trace attached and logs its phase durations once
+// the response headers arrived
+func (transport *tracingTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	timings := &requestTimings{start: time.Now()}
+	traced := request.WithContext(httptrace.WithClientTrace(request.Context(), timings.clientTrace()))
+	response, err := transport.base.RoundTrip(traced)
+	timings.mutex.Lock()
+	defer timings.mutex.Unlock()
+	attributes := []any{
+		"method", request.Method,
+		"url", request.URL.String(),
+		"reused", timings.reused,
+		"dns", timings.dns,
+		"connect", timings.connect,
+		"tls", timings.tls,
+		"first_byte", timings.firstByte,
+		"total", time.Since(timings.start),
+	}
+	if err != nil {
+		attributes = append(attributes, "error", err)
+	} else {
+		attributes = append(attributes, "status", response.StatusCode)
+	}
+	slog.Debug("Request timings", attributes...)
+	return response, err
+}
+
+// withSendTime returns a context that notes when a request made with it first asks for a
+// connection, after any rate limiter wait, and a function returning that time, or fallback
+// when no request was sent
+func withSendTime(ctx context.Context, fallback time.Time) (context.Context, func() time.Time) {
+	var sent atomic.Int64 // Unix nanoseconds of the first connection request, or 0
+	traced := httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GetConn: func(string) { sent.CompareAndSwap(0, time.Now().UnixNano()) },
+	})
+	return traced, func() time.Time {
+		if nanos := sent.Load(); nanos != 0 {
+			return time.Unix(0, nanos)
+		}
+		return fallback
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bufio"         // For buffering the list
+	"context"       // For cancelling in-flight work
+	"encoding/json" // For the JSON lines format
+	"fmt"           // For formatted I/O operations
+	"io"            // For general I/O primitives
+	"os"            // For file and system operations
+	"slices"        // For searching slices
+	"sync"          // For handling concurrency
+)
+
+// listFormats are the accepted -list-format values
+var listFormats = []string{"text", "json"}
+
+// listedURL is one line of the JSON -list-only output
+type listedURL struct {
+	URL      string `json:"url"`      // Document URL, as found
+	Filename string `json:"filename"` // Path it would be saved as, relative to the output directory
+}
+
+// urlLister writes the documents a -list-only run finds, one per line, in place of downloading
+// them; it is safe for concurrent use
+type urlLister struct {
+	mutex  sync.Mutex    // Serializes lines
+	writer *bufio.Writer // Buffered output
+	file   io.Closer     // -urls-out file, or nil for stdout
+	format string        // text for bare URLs, json for JSON lines
+	err    error         // First write error
+}
+
+// newURLLister writes to the file at path on fsys, replacing it, or to stdout when path is ""
+func newURLLister(fsys FS, path string, format string) (*urlLister, error) {
+	if !slices.Contains(listFormats, format) {
+		return nil, fmt.Errorf("invalid -list-format %q: want text or json", format)
+	}
+	lister := &urlLister{writer: bufio.NewWriter(os.Stdout), format: format}
+	if path != "" {
+		file, err := fsys.Create(path)
+		if err != nil {
+			return nil, err
+		}
+		lister.writer, lister.file = bufio.NewWriter(file), file
+	}
+	return lister, nil
+}
+
+// Download writes one document's line instead of downloading it, so the lister can stand in for
+// the run's Downloader; write errors are kept for Close to report
+func (lister *urlLister) Download(ctx context.Context, uri string, filename string) (DownloadResult, error) {
+	lister.mutex.Lock()
+	defer lister.mutex.Unlock()
+	result := DownloadResult{Outcome: outcomeListed, Filename: filename}
+	if lister.err != nil {
+		return result, lister.err // Reported by Close
+	}
+	if lister.format == "json" {
+		line, err := json.Marshal(listedURL{URL: uri, Filename: filename})
+		if err == nil {
+			_, err = lister.writer.Write(append(line, '\n'))
+		}
+		lister.err = err
+		return result, err
+	}
+	_, lister.err = fmt.Fprintln(lister.writer, uri)
+	return result, lister.err
+}
+
+// Close flushes the list and closes the -urls-out file, returning the first error
+func (lister *urlLister) Close() error {
+	lister.mutex.Lock()
+	defer lister.mutex.Unlock()
+	err := lister.err
+	if flushErr := lister.writer.Flush(); err == nil {
+		err = flushErr
+	}
+	if lister.file != nil {
+		if closeErr := lister.file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json" // For reading the manifest
+	"fmt"           // For formatted I/O operations
+	"io"            // For general I/O primitives
+	"io/fs"         // For walking the output directory
+	"log/slog"      // For structured, levelled logging
+	"path/filepath" // For manipulating filename paths
+	"sort"          // For ordering the report
+	"strings"       // For string manipulation
+)
+
+// CorruptFile is a stored document that failed validation
+type CorruptFile struct {
+	Filename string // Path relative to the output directory
+	Reason   string // Why it failed
+}
+
+// ValidateReport is the result of checking the documents already in the output directory
+type ValidateReport struct {
+	Checked  int           // Documents that passed
+	Corrupt  []CorruptFile // Documents that failed, sorted by filename
+	Repaired int           // Corrupt documents removed so they are downloaded again
+}
+
+// validateFile returns why the document at path on fsys, named name and size bytes long, is
+// corrupt, or "" if it looks fine: it must be at least minBytes (and never empty), start with the
+// PDF signature if it is a .pdf, and match its manifest checksum when checksums is set
+func validateFile(fsys FS, path string, name string, size int64, minBytes int64, recorded map[string]ManifestEntry, checksums bool) (string, error) {
+	if size == 0 || size < minBytes {
+		return fmt.Sprintf("only %d bytes", size), nil
+	}
+	if strings.EqualFold(getFileExtension(name), ".pdf") && !partialLooksLikePDF(fsys, path) {
+		return "doesn't start with " + string(pdfMagic), nil
+	}
+	entry, known := recorded[name]
+	if !checksums || !known || entry.SHA256 == "" {
+		return "", nil
+	}
+	actualSize, checksum, err := fileChecksum(fsys, path)
+	if err != nil {
+		return "", err
+	}
+	if actualSize != entry.Size || !strings.EqualFold(checksum, entry.SHA256) {
+		return "checksum differs from the manifest", nil
+	}
+	return "", nil
+}
+
+// validateExisting checks every document below dir on fsys with validateFile, using the manifest
+// at manifestPath for checksums, and removes the corrupt ones unless keep is set, so they are
+// downloaded again; ignored names, relative to dir, are skipped
+func validateExisting(fsys FS, manifestPath string, dir string, ignored []string, minBytes int64, checksums bool, keep bool) (ValidateReport, error) {
+	var report ValidateReport
+	recorded := make(map[string]ManifestEntry) // Manifest entries by cleaned filename
+	if content, err := readFile(fsys, manifestPath); err == nil {
+		var manifest Manifest
+		if json.Unmarshal(content, &manifest) == nil {
+			for _, entry := range manifest.Entries {
+				recorded[filepath.Clean(entry.Filename)] = entry
+			}
+		}
+	}
+	err := fsys.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.Type().IsRegular() {
+			return err // Directories and special files aren't documents
+		}
+		name, err := filepath.Rel(dir, path)
+		if err != nil || isBookkeepingFile(name, ignored) {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		reason, err := validateFile(fsys, path, name, info.Size(), minBytes, recorded, checksums)
+		if err != nil {
+			return err
+		}
+		if reason == "" {
+			report.Checked++
+			return nil
+		}
+		report.Corrupt = append(report.Corrupt, CorruptFile{Filename: filepath.ToSlash(name), Reason: reason})
+		if keep {
+			return nil // Only reported
+		}
+		if err := removeFile(fsys, path); err != nil {
+			slog.Warn("Failed to remove corrupt file", "path", path, "error", err)
+			return nil
+		}
+		report.Repaired++
+		return nil
+	})
+	sort.Slice(report.Corrupt, func(i, j int) bool { return report.Corrupt[i].Filename < report.Corrupt[j].Filename })
+	return report, err
+}
+
+// printValidateReport writes one line per corrupt document and a closing total to w
+func printValidateReport(w io.Writer, report ValidateReport) {
+	for _, file := range report.Corrupt {
+		fmt.Fprintf(w, "CORRUPT\t%s\t%s\n", file.Filename, file.Reason)
+	}
+	fmt.Fprintf(w, "Validated: %d ok, %d corrupt, %d removed to be downloaded again\n", report.Checked, len(report.Corrupt), report.Repaired)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"crypto/sha256" // For checksumming local files
+	"encoding/hex"  // For comparing against hex-encoded checksums
+	"encoding/json" // For reading the manifest
+	"fmt"           // For formatted I/O operations
+	"io"            // For general I/O primitives
+	"io/fs"         // For walking the output directory
+	"os"            // For file and system operations
+	"path/filepath" // For manipulating filename paths
+	"slices"        // For searching slices
+	"sort"          // For ordering the report
+	"strings"       // For string manipulation
+)
+
+// VerifyReport lists the differences between a manifest and the files in a directory
+type VerifyReport struct {
+	Checked    int      // Files that matched their manifest entry
+	Mismatched []string // Files whose size or SHA-256 differs from the manifest
+	Missing    []string // Manifest entries without a file
+	Extra      []string // Files without a manifest entry
+}
+
+// Discrepancies returns how many problems the report found
+func (report VerifyReport) Discrepancies() int {
+	return len(report.Mismatched) + len(report.Missing) + len(report.Extra)
+}
+
+// fileChecksum returns the size and hex-encoded SHA-256 checksum of the file at path on fsys
+func fileChecksum(fsys FS, path string) (int64, string, error) {
+	file, err := fsys.Open(path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer file.Close()
+	hasher := sha256.New()
+	size, err := io.Copy(hasher, file) // Hash the whole file
+	if err != nil {
+		return 0, "", err
+	}
+	return size, hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// isBookkeepingFile reports whether name, relative to the output directory, is one of the
+// scraper's own files rather than a downloaded document
+func isBookkeepingFile(name string, ignored []string) bool {
+	for _, suffix := range []string{tempFileSuffix, metadataSuffix, partialSuffix, partialStateSuffix} {
+		if strings.HasSuffix(name, suffix) {
+			return true // Temp file, sidecar or interrupted download
+		}
+	}
+	return name == lockFileName || slices.Contains(ignored, name)
+}
+
+// verifyManifest recomputes the checksum of every file listed in the manifest at manifestPath,
+// looking for it below dir on fsys, and lists the files below dir the manifest doesn't know
+// about; ignored names, relative to dir, are never reported as extras
+func verifyManifest(fsys FS, manifestPath string, dir string, ignored []string) (VerifyReport, error) {
+	var report VerifyReport
+	content, err := readFile(fsys, manifestPath) // Manifest from an earlier run
+	if err != nil {
+		return report, err
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(content, &manifest); err != nil {
+		return report, fmt.Errorf("parsing %s: %w", manifestPath, err)
+	}
+
+	listed := make(map[string]bool) // Cleaned filenames of the manifest entries
+	for _, entry := range manifest.Entries {
+		listed[filepath.Clean(entry.Filename)] = true
+		filePath, err := safeJoin(dir, entry.Filename)
+		if err != nil {
+			report.Mismatched = append(report.Mismatched, entry.Filename) // Entry points outside the directory
+			continue
+		}
+		size, checksum, err := fileChecksum(fsys, filePath)
+		switch {
+		case os.IsNotExist(err):
+			report.Missing = append(report.Missing, entry.Filename)
+		case err != nil:
+			return report, err // Can't tell either way
+		case size != entry.Size || !strings.EqualFold(checksum, entry.SHA256):
+			report.Mismatched = append(report.Mismatched, entry.Filename)
+		default:
+			report.Checked++
+		}
+	}
+
+	err = fsys.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.Type().IsRegular() {
+			return err // Directories and special files aren't documents
+		}
+		name, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if !listed[name] && !isBookkeepingFile(name, ignored) {
+			report.Extra = append(report.Extra, filepath.ToSlash(name)) // Not part of the mirror
+		}
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+	sort.Strings(report.Mismatched)
+	sort.Strings(report.Missing)
+	sort.Strings(report.Extra) // Stable order for diffing reports
+	return report, nil
+}
+
+// printVerifyReport writes one line per discrepancy and a closing total to w
+func printVerifyReport(w io.Writer, report VerifyReport) {
+	for _, group := range []struct {
+		label string
+		names []string
+	}{{"MISMATCH", report.Mismatched}, {"MISSING", report.Missing}, {"EXTRA", report.Extra}} {
+		for _, name := range group.names {
+			fmt.Fprintf(w, "%s\t%s\n", group.label, name)
+		}
+	}
+	fmt.Fprintf(w, "Verified: %d ok, %d mismatched, %d missing, %d extra\n", report.Checked, len(report.Mismatched), len(report.Missing), len(report.Extra))
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"         // For the printed report
+	"encoding/json" // For writing the manifest
+	"os"            // For the mirror's files
+	"path/filepath" // For the mirror's paths
+	"slices"        // For comparing reports
+	"testing"       // For the test framework
+)
+
+// writeMirror writes files, by path relative to dir, and a manifest listing entries to dir
+func writeMirror(t *testing.T, dir string, files map[string]string, entries []ManifestEntry) string {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	content, _ := json.Marshal(Manifest{Entries: entries})
+	manifestPath := filepath.Join(dir, manifestFileName)
+	if err := os.WriteFile(manifestPath, content, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return manifestPath
+}
+
+// verifiedEntry returns the manifest entry of filename holding content
+func verifiedEntry(filename string, content string) ManifestEntry {
+	return ManifestEntry{Filename: filename, Size: int64(len(content)), SHA256: checksumOf([]byte(content))}
+}
+
+func TestVerifyManifest(t *testing.T) {
+	dir := t.TempDir()
+	entries := []ManifestEntry{
+		verifiedEntry("a.pdf", "%PDF-a"),
+		verifiedEntry("b/upper.pdf", "%PDF-b"),
+		verifiedEntry("changed.pdf", "%PDF-original"),
+		verifiedEntry("resized.pdf", "%PDF-longer"),
+		verifiedEntry("gone.pdf", "%PDF-gone"),
+		verifiedEntry("../outside.pdf", "%PDF-x"),
+	}
+	entries[1].SHA256 = string(bytes.ToUpper([]byte(entries[1].SHA256))) // Hex case doesn't matter
+	entries[3].SHA256 = checksumOf([]byte("%PDF-short"))                 // Same content, wrong size
+	manifestPath := writeMirror(t, dir, map[string]string{
+		"a.pdf":                        "%PDF-a",
+		"b/upper.pdf":                  "%PDF-b",
+		"changed.pdf":                  "%PDF-tampered",
+		"resized.pdf":                  "%PDF-short",
+		"stray.pdf":                    "%PDF-stray",
+		"c/stray.pdf":                  "%PDF-stray",
+		"a.pdf" + metadataSuffix:       "{}",
+		"new.pdf" + tempFileSuffix:     "%PDF-",
+		"new.pdf" + partialSuffix:      "%PDF-",
+		"new.pdf" + partialStateSuffix: "{}",
+		lockFileName:                   "123",
+		failuresFileName:               "",
+	}, entries)
+
+	report, err := verifyManifest(osFS{}, manifestPath, dir, []string{manifestFileName, failuresFileName})
+	if err != nil {
+		t.Fatalf("verifyManifest: %v", err)
+	}
+	if report.Checked != 2 {
+		t.Errorf("Checked = %d, want 2", report.Checked)
+	}
+	if want := []string{"../outside.pdf", "changed.pdf", "resized.pdf"}; !slices.Equal(report.Mismatched, want) {
+		t.Errorf("Mismatched = %q, want %q", report.Mismatched, want)
+	}
+	if want := []string{"gone.pdf"}; !slices.Equal(report.Missing, want) {
+		t.Errorf("Missing = %q, want %q", report.Missing, want)
+	}
+	if want := []string{"c/stray.pdf", "stray.pdf"}; !slices.Equal(report.Extra, want) {
+		t.Errorf("Extra = %q, want %q", report.Extra, want)
+	}
+	if report.Discrepancies() != 6 {
+		t.Errorf("Discrepancies = %d, want 6", report.Discrepancies())
+	}
+
+	var printed bytes.Buffer
+	printVerifyReport(&printed, report)
+	want := "MISMATCH\t../outside.pdf\nMISMATCH\tchanged.pdf\nMISMATCH\tresized.pdf\nMISSING\tgone.pdf\nEXTRA\tc/stray.pdf\nEXTRA\tstray.pdf\n" +
+		"Verified: 2 ok, 3 mismatched, 1 missing, 2 extra\n"
+	if printed.String() != want {
+		t.Errorf("printed report:\n%s\nwant:\n%s", printed.String(), want)
+	}
+}
+
+func TestVerifyManifestErrors(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := verifyManifest(osFS{}, filepath.Join(dir, "missing.json"), dir, nil); !os.IsNotExist(err) {
+		t.Errorf("missing manifest: err = %v", err)
+	}
+	broken := filepath.Join(dir, "broken.json")
+	os.WriteFile(broken, []byte("{not json"), 0o644)
+	if _, err := verifyManifest(osFS{}, broken, dir, nil); err == nil {
+		t.Error("unparseable manifest verified")
+	}
+}